@@ -0,0 +1,48 @@
+// Command mockidp runs the mock OpenID Connect identity provider, so that single sign-on can be tried out locally.
+// Start the web app with -oidc-issuer http://localhost:4001 -oidc-client-id snippetbox and OIDC_CLIENT_SECRET set
+// to the same secret as here.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mabego/snippetbox-mysql/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":4001", "HTTP network address")
+	issuer := flag.String("issuer", "http://localhost:4001", "Issuer URL")
+	clientID := flag.String("client-id", "snippetbox", "Client ID")
+	clientSecret := flag.String("client-secret", "secret", "Client secret")
+	subject := flag.String("subject", "mock-user", "Subject of the user to log in")
+	name := flag.String("name", "Mock User", "Name of the user to log in")
+	email := flag.String("email", "mock@example.com", "Email address of the user to log in")
+	groups := flag.String("groups", "", "Comma-separated groups of the user to log in")
+
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	user := oidctest.User{
+		Subject:       *subject,
+		Name:          *name,
+		Email:         *email,
+		EmailVerified: true,
+	}
+	if *groups != "" {
+		user.Groups = strings.Split(*groups, ",")
+	}
+
+	provider, err := oidctest.New(*issuer, *clientID, *clientSecret, user)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	infoLog.Printf("Starting mock identity provider on %s", *addr)
+	errorLog.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+const StatsCacheTTL = time.Minute
+
+// statsCache holds reviewer statistics for each time window for a short time, so that reloading the stats page
+// does not rerun the aggregate query every time.
+type statsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	stats   []*models.ReviewerStats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{
+		ttl:     ttl,
+		entries: make(map[string]statsCacheEntry),
+	}
+}
+
+// get returns the cached statistics for a window, if they have not expired.
+func (c *statsCache) get(window string) ([]*models.ReviewerStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[window]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.stats, true
+}
+
+func (c *statsCache) set(window string, stats []*models.ReviewerStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[window] = statsCacheEntry{
+		stats:   stats,
+		expires: time.Now().Add(c.ttl),
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
-// The contextKey type provides unique keys to store and retrieve authentication status without the risk of
-// naming collisions.
+// The contextKey type provides unique keys to store and retrieve authentication status and permissions without the
+// risk of naming collisions.
 type contextKey string
 
 const (
-	isAuthenticatedContextKey = contextKey("isAuthenticated")
-	isAuthorizedContextKey    = contextKey("isAuthorized")
+	isAuthenticatedContextKey     = contextKey("isAuthenticated")
+	authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+	permissionsContextKey         = contextKey("permissions")
+	isUnverifiedContextKey        = contextKey("isUnverified")
+	snippetContextKey             = contextKey("snippet")
 )
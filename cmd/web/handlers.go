@@ -1,15 +1,22 @@
 package main
 
 import (
-	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/mabego/snippetbox-mysql/internal/models"
+	"github.com/mabego/snippetbox-mysql/internal/oidc"
+	"github.com/mabego/snippetbox-mysql/internal/totp"
 	"github.com/mabego/snippetbox-mysql/internal/validator"
+	"github.com/skip2/go-qrcode"
 )
 
 const (
@@ -18,8 +25,45 @@ const (
 	TitleMaxChars = 100
 	Week          = 7
 	Year          = 365
+	DateLayout    = "2006-01-02"
+	Month         = 30
+	BodyMaxChars  = 5000
+	InvitationTTL = Week * 24 * time.Hour
+	ResetTTL      = time.Hour
+	// What happens to a user's snippets when they delete their account.
+	SnippetsDelete   = "delete"
+	SnippetsReassign = "reassign"
+	// Verification emails are valid for a day and can be resent once a minute, up to VerificationDailyLimit
+	// times a day.
+	VerificationTTL            = Day * 24 * time.Hour
+	VerificationResendInterval = time.Minute
+	VerificationDailyLimit     = 5
+	TwoFactorLoginTimeout      = 5 * time.Minute
+	TwoFactorIssuer            = "Snippetbox"
+	// RememberTTL is how long a user who ticks "Remember me" stays logged in across sessions.
+	RememberTTL        = Month * 24 * time.Hour
+	RememberCookieName = "remember"
+	QRCodeSize         = 256
+	// Profiles have a bio of up to BioMaxChars characters and an avatar image of up to AvatarMaxBytes bytes, and
+	// list a user's RecentReviewsLimit most recent reviews. The profile form, avatar included, is cut off at
+	// ProfileMaxBytes.
+	NameMaxChars       = 255
+	BioMaxChars        = 500
+	AvatarMaxBytes     = 256 << 10
+	ProfileMaxBytes    = AvatarMaxBytes + 16<<10
+	RecentReviewsLimit = 10
+	// API tokens have a name of up to TokenNameMaxChars characters.
+	TokenNameMaxChars = 100
+	TeamNameMaxChars  = 100
+	// The audit log shows the AuditViewLimit newest events that match a search, and exports up to
+	// AuditExportLimit of them.
+	AuditViewLimit   = 200
+	AuditExportLimit = 10000
 )
 
+// statsWindows maps the time windows offered on the stats page to their length in days. Zero means all time.
+var statsWindows = map[string]int{"7d": Week, "30d": Month, "365d": Year, "all": 0}
+
 // The struct tags tell the go-playground/form decoder how to map HTML form values into the different struct fields.
 // Any type conversions are handled automatically.
 // The struct tag `form:"-"` tells the decoder to completely ignore a field during decoding.
@@ -30,17 +74,147 @@ type snippetCreateForm struct {
 	validator.Validator `form:"-"`
 }
 
+// snippetEditForm uses an Expires value of 0 to keep the current expiry date.
+type snippetEditForm struct {
+	Title               string `form:"title"`
+	Content             string `form:"content"`
+	Expires             int    `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
 type userSignupForm struct {
 	Name                string `form:"name"`
 	Email               string `form:"email"`
 	Password            string `form:"password"`
-	Owner               bool   `form:"owner"`
+	Role                string `form:"role"`
+	validator.Validator `form:"-"`
+}
+
+type adminUserForm struct {
+	Role                string `form:"role"`
+	Disabled            bool   `form:"disabled"`
+	validator.Validator `form:"-"`
+}
+
+type invitationForm struct {
+	Email               string `form:"email"`
+	Role                string `form:"role"`
+	validator.Validator `form:"-"`
+}
+
+// userInviteForm is the signup form for invitees; the email address and role come from the invitation.
+type userInviteForm struct {
+	Name                string `form:"name"`
+	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	Remember            bool   `form:"remember"`
+	validator.Validator `form:"-"`
+}
+
+type reviewForm struct {
+	Verdict             string `form:"verdict"`
+	validator.Validator `form:"-"`
+}
+
+// reviewRequestForm asks either the reviewer with an email address or, if Team is not 0, the members of a team to
+// review a snippet.
+type reviewRequestForm struct {
+	Reviewer            string `form:"reviewer"`
+	Team                int    `form:"team"`
+	Due                 string `form:"due"`
+	validator.Validator `form:"-"`
+}
+
+type starForm struct {
+	Star                bool `form:"star"`
+	validator.Validator `form:"-"`
+}
+
+type commentForm struct {
+	Body                string `form:"body"`
+	ParentID            int    `form:"parentID"`
+	validator.Validator `form:"-"`
+}
+
+type userPasswordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type userPasswordResetForm struct {
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+type userLoginTwoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// accountTwoFactorForm enables two-factor authentication with a code from the authenticator app, or disables it
+// with the user's password.
+type accountTwoFactorForm struct {
+	Code                string `form:"code"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type adminSettingsForm struct {
+	RequireAdminTwoFactor bool `form:"requireAdminTwoFactor"`
+	validator.Validator   `form:"-"`
+}
+
+// accountProfileForm changes the user's name, email address and bio. The avatar image arrives as a file upload in
+// the same multipart form, so it is not decoded into the struct.
+type accountProfileForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	Bio                 string `form:"bio"`
+	RemoveAvatar        bool   `form:"removeAvatar"`
+	validator.Validator `form:"-"`
+}
+
+type teamForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+// teamMemberForm adds the user with an email address to a team, or changes their role if they are a member.
+type teamMemberForm struct {
+	Email               string `form:"email"`
+	Role                string `form:"role"`
+	validator.Validator `form:"-"`
+}
+
+type teamMemberRemoveForm struct {
+	UserID int `form:"userID"`
+}
+
+// accountTokenForm creates an API token. Expires is the number of days the token lasts, or 0 if it never expires.
+type accountTokenForm struct {
+	Name                string   `form:"name"`
+	Scopes              []string `form:"scopes"`
+	Expires             int      `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+// HasScope reports whether the scope is ticked, so that the form keeps its checkboxes when it is shown again.
+func (f accountTokenForm) HasScope(scope string) bool {
+	return slices.Contains(f.Scopes, scope)
+}
+
+// accountDeleteForm deletes the user's account once they re-enter their password. Snippets is SnippetsDelete or
+// SnippetsReassign, in which case their snippets go to the user with the email address ReassignTo.
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	Snippets            string `form:"snippets"`
+	ReassignTo          string `form:"reassignTo"`
 	validator.Validator `form:"-"`
 }
 
@@ -52,7 +226,12 @@ type accountPasswordUpdateForm struct {
 }
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	sort := r.URL.Query().Get("sort")
+	if !validator.PermittedValue(sort, models.SortNewest, models.SortStars) {
+		sort = models.SortNewest
+	}
+
+	snippets, err := app.snippets.Latest(sort)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -60,6 +239,7 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.Sort = sort
 
 	app.render(w, http.StatusOK, "home.page.tmpl", data)
 }
@@ -75,7 +255,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippet(r, id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
@@ -85,22 +265,72 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data, err := app.snippetViewData(r, snippet)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, http.StatusOK, "view.page.tmpl", data)
+}
+
+// snippetViewData collects the template data for the snippet view page, which is rendered by snippetView and
+// redisplayed by the handlers that post forms from it.
+func (app *application) snippetViewData(r *http.Request, snippet *models.Snippet) (*templateData, error) {
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.Form = reviewRequestForm{}
 
-	// Retrieve the authenticatedUserID value from the session
-	// GetInt will return 0 if no authenticatedUserID value is in the session.
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// Retrieve the ID of the user the authentication middleware logged in. It is 0 for a visitor.
+	userID := app.authenticatedUserID(r)
 
 	review, err := app.reviews.Get(userID, snippet.ID)
 	if err != nil {
-		app.serverError(w, err)
-		return
+		return nil, err
 	}
 
 	data.Review = review
 
-	app.render(w, http.StatusOK, "view.page.tmpl", data)
+	starred, err := app.stars.Exists(userID, snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Starred = starred
+
+	requests, err := app.reviewRequests.ForSnippet(snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.ReviewRequests = requests
+
+	events, err := app.snippetEvents.Timeline(snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.SnippetEvents = events
+
+	comments, err := app.comments.ForSnippet(snippet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Comments = comments
+	data.CommentForm = commentForm{}
+
+	// Reviews can be requested from a whole team.
+	if app.can(r, models.PermReviewRequest) {
+		teams, err := app.teams.List(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Teams = teams
+	}
+
+	return data, nil
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
@@ -114,6 +344,11 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	app.createSnippet(w, r, nil)
+}
+
+// createSnippet creates a snippet from the posted form, owned by team if it is not nil.
+func (app *application) createSnippet(w http.ResponseWriter, r *http.Request, team *models.Team) {
 	var form snippetCreateForm
 
 	err := app.decodePostForm(r, &form)
@@ -133,11 +368,19 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
+		data.Team = team
 		app.render(w, http.StatusUnprocessableEntity, "create.page.tmpl", data)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	userID := app.authenticatedUserID(r)
+
+	var teamID int
+	if team != nil {
+		teamID = team.ID
+	}
+
+	id, err := app.snippets.Insert(userID, teamID, form.Title, form.Content, form.Expires)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -150,63 +393,55 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
-	owner, err := app.users.Owner()
-	if err != nil {
-		app.serverError(w, err)
-	}
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
 
-	// Retrieve the authenticatedUserID value from the session
-	// GetInt will return 0 if no authenticatedUserID value is in the session.
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
 
-	authorized, err := app.users.Authorize(userID)
+	snippet, err := app.snippet(r, id)
 	if err != nil {
-		// A user needs to be logged in to be an owner.
-		if !errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
 			app.serverError(w, err)
-			return
 		}
-		authorized = false
-	}
-
-	if owner && !authorized {
-		http.Redirect(w, r, "/about", http.StatusSeeOther)
 		return
 	}
 
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
+	data.Snippet = snippet
+	data.Form = snippetEditForm{
+		Title:   snippet.Title,
+		Content: snippet.Content,
+	}
 
-	app.render(w, http.StatusOK, "signup.page.tmpl", data)
+	app.render(w, http.StatusOK, "edit.page.tmpl", data)
 }
 
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	owner, err := app.users.Owner()
-	if err != nil {
-		app.serverError(w, err)
-	}
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
 
-	// Retrieve the authenticatedUserID value from the session
-	// GetInt will return 0 if no authenticatedUserID value is in the session.
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
 
-	authorized, err := app.users.Authorize(userID)
+	snippet, err := app.snippet(r, id)
 	if err != nil {
-		// A user needs to be logged in to be an owner.
-		if !errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
 			app.serverError(w, err)
-			return
 		}
-		authorized = false
-	}
-
-	if owner && !authorized {
-		http.Redirect(w, r, "/about", http.StatusSeeOther)
 		return
 	}
 
-	var form userSignupForm
+	var form snippetEditForm
 
 	err = app.decodePostForm(r, &form)
 	if err != nil {
@@ -214,50 +449,98 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
-		"This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, MinChars), "password",
-		"This field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, TitleMaxChars), "title",
+		"This field cannot be more than 100 characters long")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Expires, 0, Day, Week, Year), "expires",
+		"This field must equal 0, 1, 7 or 365")
 
-	// If there are validation errors, redisplay the signup form along with a 422 status code.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.Snippet = snippet
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.page.tmpl", data)
+		app.render(w, http.StatusUnprocessableEntity, "edit.page.tmpl", data)
 		return
 	}
 
-	err = app.users.Insert(form.Name, form.Email, form.Password, form.Owner)
+	userID := app.authenticatedUserID(r)
+
+	err = app.snippets.Update(snippet.ID, userID, app.impersonatorID(r), form.Title, form.Content, form.Expires)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.page.tmpl", data)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
 
+	err = app.snippets.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in")
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+// signupOpen reports whether the current visitor may use the signup form. Anyone can sign up until there is an
+// admin, so that the first user can become one; after that only users who manage users can. Otherwise it sends
+// the visitor away with a flash message and returns false.
+func (app *application) signupOpen(w http.ResponseWriter, r *http.Request) bool {
+	adminExists, err := app.users.AdminExists()
+	if err != nil {
+		app.serverError(w, err)
+		return false
+	}
+
+	if adminExists && !app.can(r, models.PermUserManage) {
+		app.sessionManager.Put(r.Context(), "flash", "Signup is by invitation. Please ask an admin to invite you")
+		http.Redirect(w, r, "/about", http.StatusSeeOther)
+		return false
+	}
+
+	return true
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	if !app.signupOpen(w, r) {
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
+	data.Form = userSignupForm{Role: models.RoleReviewer}
 
-	app.render(w, http.StatusOK, "login.page.tmpl", data)
+	app.render(w, http.StatusOK, "signup.page.tmpl", data)
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	var form userLoginForm
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	if !app.signupOpen(w, r) {
+		return
+	}
+
+	var form userSignupForm
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -265,114 +548,2685 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
 	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
 		"This field must be a valid email address")
 	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(models.ValidRole(form.Role), "role", "This field must be a valid role")
+
+	err = app.checkPassword(&form.Validator, "password", form.Password, form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
+	// If there are validation errors, redisplay the signup form along with a 422 status code.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.page.tmpl", data)
+		app.render(w, http.StatusUnprocessableEntity, "signup.page.tmpl", data)
 		return
 	}
 
-	// If the credentials are invalid, add a generic non-field error and redisplay the login form.
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	err = app.users.Insert(form.Name, form.Email, form.Password, form.Role)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.page.tmpl", data)
+			app.render(w, http.StatusUnprocessableEntity, "signup.page.tmpl", data)
 		} else {
 			app.serverError(w, err)
 		}
+
 		return
 	}
 
-	// RenewToken changes the current session ID when the authentication state changes for the user
-	// with the login operation.
-	err = app.sessionManager.RenewToken(r.Context())
+	err = app.audit(r, models.AuditEvent{Action: models.AuditSignup, TargetEmail: form.Email, Detail: form.Role})
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now logged in.
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-
-	owner, err := app.users.Authorize(id)
+	// The account exists by now, so a failed email only means the user has to ask for another one.
+	err = app.sendVerification(form.Email)
 	if err != nil {
-		app.serverError(w, err)
+		app.errorLog.Print(err)
+		app.sessionManager.Put(r.Context(), "flash",
+			"Your signup was successful, but we couldn't send your verification email. Please log in to resend it")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
-	if owner {
-		app.sessionManager.Put(r.Context(), "owner", owner)
-	}
 
-	// PopString pops the value for the "redirectPathAfterLogin" key from the session data.
-	// If there is no matching key in the session data, it will return an empty string.
-	urlPath := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
-	if urlPath != "" {
-		http.Redirect(w, r, urlPath, http.StatusSeeOther)
-		return
-	}
+	app.sessionManager.Put(r.Context(), "flash",
+		"Your signup was successful. Please check your email to verify your address and log in")
 
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	// RenewToken changes the current session ID when the authentication state changes for the user
-	// with the logout operation.
-	err := app.sessionManager.RenewToken(r.Context())
+// sendVerification emails a new verification link to the unverified user with an email address.
+func (app *application) sendVerification(email string) error {
+	token, err := app.emailVerifications.Insert(email, VerificationTTL)
 	if err != nil {
-		app.serverError(w, err)
-		return
+		return err
 	}
 
-	// Remove authenticatedUserID from the session data so the user is logged out.
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Remove(r.Context(), "owner")
+	return app.mailVerification(email, token)
+}
 
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+// mailVerification emails a verification link with a token to an address.
+func (app *application) mailVerification(email, token string) error {
+	body := fmt.Sprintf("Please verify your email address for Snippetbox within a day at:\n%s/user/verify/%s",
+		app.baseURL, token)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return app.mailer.Send(email, "Verify your Snippetbox email address", body)
 }
 
-func (app *application) aboutView(w http.ResponseWriter, r *http.Request) {
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	_, err := app.emailVerifications.Verify(params.ByName("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.sessionManager.Put(r.Context(), "flash",
+				"This verification link is invalid or has expired. Please log in to request a new one")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash", "This email address is already in use by another account")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified!")
+
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+
+	app.render(w, http.StatusOK, "login.page.tmpl", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	var form userLoginForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login.page.tmpl", data)
+		return
+	}
+
+	// Don't check the password at all while the IP address or the account is locked out.
+	ip := clientIP(r)
+
+	lockedUntil, err := app.loginThrottle.LockedUntil(ip, form.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		err = app.audit(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetEmail: form.Email,
+			Detail: "locked out"})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddNonFieldError(lockoutMessage(lockedUntil))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login.page.tmpl", data)
+		return
+	}
+
+	// If the credentials are invalid, add a generic non-field error and redisplay the login form.
+	id, err := app.authenticator.Authenticate(form.Email, form.Password)
+	if err != nil {
+		failure := models.AuditEvent{Action: models.AuditLoginFailed, TargetEmail: form.Email}
+
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			failure.Detail = "incorrect email or password"
+			form.AddNonFieldError("Email or password is incorrect")
+
+			lockedUntil, err := app.loginThrottle.Fail(ip, form.Email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			if !lockedUntil.IsZero() {
+				form.AddNonFieldError(lockoutMessage(lockedUntil))
+			}
+		case errors.Is(err, models.ErrAccountDisabled):
+			failure.Detail = "account disabled"
+			form.AddNonFieldError("Your account has been disabled")
+		case errors.Is(err, models.ErrLastAdmin):
+			failure.Detail = "only admin not in an admin group"
+			form.AddNonFieldError("Your directory groups don't make you an admin, but you are the only one. " +
+				"Please ask for your groups to be fixed")
+		case errors.Is(err, models.ErrDuplicateEmail):
+			failure.Detail = "email address not verified"
+			form.AddNonFieldError("An account with this email address has not been verified yet. " +
+				"Please verify it before you log in")
+		default:
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.audit(r, failure)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login.page.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// With two-factor authentication on, the password only gets the user as far as the code page. They are not
+	// logged in until they enter a code from their authenticator app or a recovery code.
+	if user.TwoFactorEnabled {
+		app.startTwoFactor(w, r, user, form.Remember)
+		return
+	}
+
+	app.completeLogin(w, r, user, form.Remember, "password")
+}
+
+// startTwoFactor sends a user who has proven who they are, but uses two-factor authentication, on to the code page.
+func (app *application) startTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", user.ID)
+	app.sessionManager.Put(r.Context(), "twoFactorStarted", time.Now().Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorRemember", remember)
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// completeLogin logs in a user who has proven who they are and sends them on to the page they need to see next.
+// If they asked to be remembered, it also gives them a remember token to log back in with once the session expires.
+// The method they proved who they are with is recorded in the audit log.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, remember bool,
+	method string) {
+	// An admin has reset the user's password, so they have to choose a new one through a reset link first, even if
+	// they proved who they are some other way.
+	if user.PasswordResetRequired {
+		app.sessionManager.Put(r.Context(), "flash",
+			"Your password has been reset. Please choose a new one with the link we emailed you")
+		http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	err := app.loginThrottle.Reset(user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, models.AuditEvent{ActorID: user.ID, Action: models.AuditLogin, TargetID: user.ID,
+		Detail: method})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	family := ""
+	if remember {
+		var token string
+
+		family, token, err = app.rememberTokens.Insert(user.ID, RememberTTL)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		setRememberCookie(w, token)
+	}
+
+	err = app.startSession(r, user, family)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Admins may have to set up two-factor authentication before they can do anything else.
+	required, err := app.twoFactorSetupRequired(user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if required {
+		app.sessionManager.Put(r.Context(), "flash",
+			"Two-factor authentication is required for admins. Please set it up to continue")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	// PopString pops the value for the "redirectPathAfterLogin" key from the session data.
+	// If there is no matching key in the session data, it will return an empty string.
+	urlPath := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if urlPath != "" {
+		http.Redirect(w, r, urlPath, http.StatusSeeOther)
+		return
+	}
+
+	// Send users who can't create snippets to the home page instead of a page they can't open.
+	if user.EmailVerified.IsZero() || !models.Permissions(user.Role)[models.PermSnippetCreate] {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// twoFactorUserID returns the ID of the user who entered their password and still has to enter a code. It returns
+// 0 if there is no such user or they took longer than TwoFactorLoginTimeout.
+func (app *application) twoFactorUserID(r *http.Request) int {
+	started := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStarted"), 0)
+	if time.Since(started) > TwoFactorLoginTimeout {
+		return 0
+	}
+
+	return app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+}
+
+// userLoginOIDC sends the user to the identity provider to log in. The state, nonce and PKCE code verifier wait in
+// the session until the provider sends the user back.
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state, err := oidc.NewState()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	nonce, err := oidc.NewState()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	verifier := oidc.NewVerifier()
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+// userLoginOIDCCallback logs in the user the identity provider sent back, creating their account on their first
+// sign-on. Single sign-on replaces the password but not the local second factor, which a user who has turned it on
+// still has to give.
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	query := r.URL.Query()
+
+	if state == "" || query.Get("state") != state {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" {
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on was cancelled or refused")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	identity, err := app.oidc.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		app.errorLog.Print(err)
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on failed. Please try again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Only trust an email address the identity provider has verified, since it may link an existing account.
+	if identity.Email == "" || !identity.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your identity provider has not verified your email address")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	name := identity.Name
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	id, err := app.users.ProvisionOIDC(identity.Subject, name, identity.Email, identity.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrAccountDisabled):
+			app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		case errors.Is(err, models.ErrLastAdmin):
+			app.sessionManager.Put(r.Context(), "flash",
+				"Your identity provider doesn't make you an admin, but you are the only one. Please log in with "+
+					"your password and make someone else an admin first")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash",
+				"An account with your email address has not been verified yet. Please verify it before you use "+
+					"single sign-on")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Single sign-on stands in for the password, not for the second factor.
+	if user.TwoFactorEnabled {
+		app.startTwoFactor(w, r, user, false)
+		return
+	}
+
+	app.completeLogin(w, r, user, false, "single sign-on")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.twoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userLoginTwoFactorForm{}
+
+	app.render(w, http.StatusOK, "twofactor.page.tmpl", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.twoFactorUserID(r)
+	if id == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form userLoginTwoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "twofactor.page.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Codes count towards the same lockout as passwords, so that they can't be guessed either.
+	ip := clientIP(r)
+
+	lockedUntil, err := app.loginThrottle.LockedUntil(ip, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		err = app.audit(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetID: id, Detail: "locked out"})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddNonFieldError(lockoutMessage(lockedUntil))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "twofactor.page.tmpl", data)
+		return
+	}
+
+	ok, err := app.twoFactor.Validate(id, form.Code)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// A code that is not from the authenticator app may be one of the user's recovery codes.
+	recovered := false
+	if !ok {
+		recovered, err = app.twoFactor.Recover(id, form.Code)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !ok && !recovered {
+		err = app.audit(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetID: id,
+			Detail: "incorrect two-factor code"})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddNonFieldError("Code is incorrect")
+
+		lockedUntil, err := app.loginThrottle.Fail(ip, user.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !lockedUntil.IsZero() {
+			form.AddNonFieldError(lockoutMessage(lockedUntil))
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "twofactor.page.tmpl", data)
+		return
+	}
+
+	method := "two-factor code"
+
+	if recovered {
+		method = "recovery code"
+
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("You logged in with a recovery code. You have %d recovery codes left", left))
+	}
+
+	app.completeLogin(w, r, user, app.sessionManager.GetBool(r.Context(), "twoFactorRemember"), method)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	// Take the session out of the index before its token changes.
+	err := app.userSessions.DeleteToken(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Logging out also forgets the user on this device.
+	if family := app.sessionManager.GetString(r.Context(), "rememberFamily"); family != "" {
+		err = app.rememberTokens.DeleteFamily(family)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	clearRememberCookie(w)
+
+	// RenewToken changes the current session ID when the authentication state changes for the user
+	// with the logout operation.
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Logging out also ends viewing as another user, for the admin who was doing so.
+	if impersonator := app.impersonatorID(r); impersonator != 0 {
+		err = app.audit(r, models.AuditEvent{ActorID: impersonator, Action: models.AuditImpersonationStop,
+			TargetID: app.authenticatedUserID(r), Detail: "logged out"})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.sessionManager.Remove(r.Context(), "impersonatorID")
+		app.sessionManager.Remove(r.Context(), "impersonatingName")
+	}
+
+	// Remove authenticatedUserID from the session data so the user is logged out.
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "rememberFamily")
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// impersonateStopPost ends an admin's view of the site as another user and logs them back in as themselves. An
+// admin who has since been disabled, deleted or lost the right to manage users is logged out instead.
+func (app *application) impersonateStopPost(w http.ResponseWriter, r *http.Request) {
+	impersonator := app.impersonatorID(r)
+	if impersonator == 0 {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	name := app.sessionManager.GetString(r.Context(), "impersonatingName")
+
+	allowed, err := app.canManageUsers(impersonator)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !allowed {
+		err = app.revokeSession(r.Context(), app.sessionManager.Token(r.Context()))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.auditAfter(r, models.AuditEvent{ActorID: impersonator, Action: models.AuditImpersonationStop,
+			TargetID: app.authenticatedUserID(r), Detail: "admin no longer allowed to manage users"})
+
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("You've stopped viewing as %s and been logged out", name))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = app.audit(r, models.AuditEvent{ActorID: impersonator, Action: models.AuditImpersonationStop,
+		TargetID: app.authenticatedUserID(r)})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Take the session out of the index before its token changes.
+	err = app.userSessions.DeleteToken(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", impersonator)
+	app.sessionManager.Remove(r.Context(), "impersonatorID")
+	app.sessionManager.Remove(r.Context(), "impersonatingName")
+
+	err = app.trackSession(r, impersonator)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You've stopped viewing as %s", name))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userPasswordForgotForm{}
+
+	app.render(w, http.StatusOK, "forgot.page.tmpl", data)
+}
+
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordForgotForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "forgot.page.tmpl", data)
+		return
+	}
+
+	token, err := app.passwordResets.Insert(form.Email, ResetTTL)
+	switch {
+	case err == nil:
+		body := fmt.Sprintf("Someone asked to reset the password of your Snippetbox account. If it was you, "+
+			"choose a new password within an hour at:\n%s/user/password/reset/%s\n\n"+
+			"If it was not you, you can ignore this email.", app.baseURL, token)
+
+		// Send the link in the background, so that an existing account takes no longer to answer than an
+		// unknown one. A failure is only logged, since reporting it would also reveal that the account exists.
+		email := form.Email
+		app.background(func() {
+			if err := app.mailer.Send(email, "Reset your Snippetbox password", body); err != nil {
+				app.errorLog.Print(err)
+			}
+		})
+	case !errors.Is(err, models.ErrNoRecord):
+		app.serverError(w, err)
+		return
+	}
+
+	// Show the same message whether or not the address has an account, so that the form does not reveal who
+	// has one.
+	app.sessionManager.Put(r.Context(), "flash",
+		"If an account exists for that email address, we have sent it a link to reset the password")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// passwordResetFromParams gets the valid password reset for the "token" parameter. If there is none, it sends the
+// visitor to the forgot password page with a flash message and returns nil.
+func (app *application) passwordResetFromParams(w http.ResponseWriter, r *http.Request) *models.PasswordReset {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	reset, err := app.passwordResets.Get(params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This reset link is invalid or has expired")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	return reset
+}
+
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	reset := app.passwordResetFromParams(w, r)
+	if reset == nil {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.PasswordReset = reset
+	data.Token = httprouter.ParamsFromContext(r.Context()).ByName("token")
+	data.Form = userPasswordResetForm{}
+
+	app.render(w, http.StatusOK, "reset.page.tmpl", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	reset := app.passwordResetFromParams(w, r)
+	if reset == nil {
+		return
+	}
+
+	var form userPasswordResetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation",
+		"This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation",
+		"Passwords do not match")
+
+	user, err := app.users.Get(reset.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.checkPassword(&form.Validator, "newPassword", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.PasswordReset = reset
+		data.Token = httprouter.ParamsFromContext(r.Context()).ByName("token")
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "reset.page.tmpl", data)
+		return
+	}
+
+	userID, err := app.passwordResets.Reset(httprouter.ParamsFromContext(r.Context()).ByName("token"),
+		form.NewPassword)
+	if err != nil {
+		// The link was used by another request since it was checked above.
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This reset link is invalid or has expired")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Whoever knew the old password may still be logged in, so log the user out everywhere.
+	err = app.destroyUserSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.auditAfter(r, models.AuditEvent{ActorID: userID, Action: models.AuditPasswordReset, TargetID: userID,
+		Detail: "reset link"})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) aboutView(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	app.render(w, http.StatusOK, "about.page.tmpl", data)
+}
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	// authenticatedUserID will return 0 if no user is logged in.
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data, err := app.accountViewData(r, user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, http.StatusOK, "account.page.tmpl", data)
+}
+
+// accountViewData collects the template data for the account page, which is rendered by accountView and
+// redisplayed by the two-factor authentication handlers.
+func (app *application) accountViewData(r *http.Request, user *models.User) (*templateData, error) {
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = accountTwoFactorForm{}
+
+	if user.TwoFactorEnabled {
+		left, err := app.twoFactor.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		data.RecoveryCodesLeft = left
+		return data, nil
+	}
+
+	if !app.twoFactor.Available() {
+		return data, nil
+	}
+
+	// Keep the secret for enrollment in the session until the user confirms it with a code, so that the QR code
+	// stays the same while they set up their app.
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSecret")
+	if secret == "" {
+		var err error
+
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		app.sessionManager.Put(r.Context(), "twoFactorSecret", secret)
+	}
+
+	data.TwoFactorSecret = secret
+
+	return data, nil
+}
+
+// accountTwoFactorQR serves the QR code that enrolls the pending secret in an authenticator app.
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSecret")
+	if secret == "" || !app.twoFactor.Available() {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URL(TwoFactorIssuer, user.Email, secret), qrcode.Medium, QRCodeSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !app.twoFactor.Available() {
+		app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication isn't available on this server")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	secret := app.sessionManager.GetString(r.Context(), "twoFactorSecret")
+	if user.TwoFactorEnabled || secret == "" {
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	var form accountTwoFactorForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, ok := totp.Validate(secret, form.Code, time.Now(), models.TOTPSkew, 0)
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	form.CheckField(ok, "code", "Code is incorrect. Check the time on your device and try again")
+
+	if !form.Valid() {
+		data, err := app.accountViewData(r, user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Form = form
+
+		app.render(w, http.StatusUnprocessableEntity, "account.page.tmpl", data)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(user.ID, secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "twoFactorSecret")
+
+	// The recovery codes are only stored as hashes, so this is the one time the user can see them.
+	data := app.newTemplateData(r)
+	data.RecoveryCodes = codes
+
+	app.render(w, http.StatusOK, "recovery.page.tmpl", data)
+}
+
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	var form accountTwoFactorForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if form.Valid() {
+		err = app.confirmPassword(r, &form.Validator, user, form.Password, "two-factor disable")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if form.Valid() && user.Role == models.RoleAdmin {
+		required, err := app.settings.Bool(models.SettingRequireAdminTwoFactor)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		form.CheckField(!required, "password", "Two-factor authentication is required for admins")
+	}
+
+	if !form.Valid() {
+		data, err := app.accountViewData(r, user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Form = form
+
+		app.render(w, http.StatusUnprocessableEntity, "account.page.tmpl", data)
+		return
+	}
+
+	err = app.twoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.auditAfter(r, models.AuditEvent{Action: models.AuditTwoFactorDisable, TargetID: user.ID})
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication turned off")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !user.EmailVerified.IsZero() {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	recent, err := app.emailVerifications.CountSince(user.ID, time.Now().Add(-VerificationResendInterval))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	daily, err := app.emailVerifications.CountSince(user.ID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	switch {
+	case recent > 0:
+		app.sessionManager.Put(r.Context(), "flash", "Please wait a minute before requesting another email")
+	case daily >= VerificationDailyLimit:
+		app.sessionManager.Put(r.Context(), "flash",
+			"You have requested too many verification emails today. Please try again tomorrow")
+	default:
+		err = app.sendVerification(user.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Verification email sent to %s!", user.Email))
+	}
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountProfile(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = accountProfileForm{Name: user.Name, Email: user.Email, Bio: user.Bio}
+
+	app.render(w, http.StatusOK, "profile.page.tmpl", data)
+}
+
+func (app *application) accountProfilePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The form is multipart when it carries an avatar, and the CSRF check has usually parsed it already. Its size is
+	// capped by limitBody.
+	err = r.ParseMultipartForm(ProfileMaxBytes)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var form accountProfileForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, NameMaxChars), "name",
+		"This field cannot be more than 255 characters long")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	form.CheckField(validator.MaxChars(form.Bio, BioMaxChars), "bio",
+		"This field cannot be more than 500 characters long")
+
+	avatar, contentType, err := readAvatar(r)
+	switch {
+	case errors.Is(err, errAvatarTooLarge):
+		form.AddFieldError("avatar", "This image must be at most 256 KB")
+	case errors.Is(err, errAvatarType):
+		form.AddFieldError("avatar", "This file must be a PNG, JPEG, GIF or WebP image")
+	case err != nil:
+		app.serverError(w, err)
+		return
+	}
+
+	var emailChanged bool
+
+	if form.Valid() {
+		emailChanged, err = app.users.UpdateProfile(userID, form.Name, form.Email, form.Bio)
+		if err != nil {
+			if !errors.Is(err, models.ErrDuplicateEmail) {
+				app.serverError(w, err)
+				return
+			}
+			form.AddFieldError("email", "Email address is already in use")
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "profile.page.tmpl", data)
+		return
+	}
+
+	if avatar != nil || form.RemoveAvatar {
+		err = app.users.SetAvatar(userID, avatar, contentType)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	flash := "Profile updated!"
+
+	if emailChanged {
+		token, err := app.emailVerifications.InsertPending(userID, VerificationTTL)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.mailVerification(form.Email, token)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		flash = "Profile updated. Your email address will change once you verify the new one from your email"
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// userView shows a user's public profile with their unexpired snippets and their most recent reviews.
+func (app *application) userView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if user.Disabled {
+		app.notFound(w)
+		return
+	}
+
+	snippets, err := app.snippets.ForUser(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	reviews, err := app.snippetEvents.RecentReviews(id, RecentReviewsLimit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.SnippetEvents = reviews
+
+	for _, s := range snippets {
+		if s.Expires.After(time.Now()) {
+			data.Snippets = append(data.Snippets, s)
+		}
+	}
+
+	app.render(w, http.StatusOK, "user.page.tmpl", data)
+}
+
+func (app *application) teamList(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = teamForm{}
+
+	app.renderTeams(w, r, http.StatusOK, data)
+}
+
+// renderTeams adds every team to data and renders the teams page.
+func (app *application) renderTeams(w http.ResponseWriter, r *http.Request, status int, data *templateData) {
+	teams, err := app.teams.List(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.Teams = teams
+
+	app.render(w, status, "teams.page.tmpl", data)
+}
+
+func (app *application) teamCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form teamForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, TeamNameMaxChars), "name",
+		fmt.Sprintf("This field cannot be more than %d characters long", TeamNameMaxChars))
+
+	if form.Valid() {
+		id, err := app.teams.Insert(form.Name, app.authenticatedUserID(r))
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "flash", "Team successfully created!")
+			http.Redirect(w, r, fmt.Sprintf("/team/view/%d", id), http.StatusSeeOther)
+			return
+		}
+
+		if !errors.Is(err, models.ErrDuplicateTeamName) {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddFieldError("name", "A team with this name already exists")
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+
+	app.renderTeams(w, r, http.StatusUnprocessableEntity, data)
+}
+
+// teamFromParams returns the team in the id URL parameter, with the role the current user has in it. It sends a 404
+// response and returns nil if there is no such team.
+func (app *application) teamFromParams(w http.ResponseWriter, r *http.Request) *models.Team {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil
+	}
+
+	team, err := app.teams.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	role, err := app.teams.Role(team.ID, app.authenticatedUserID(r))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return nil
+	}
+
+	team.Role = role
+
+	return team
+}
+
+// teamView shows a team with its members and the unexpired snippets it owns.
+func (app *application) teamView(w http.ResponseWriter, r *http.Request) {
+	team := app.teamFromParams(w, r)
+	if team == nil {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = teamMemberForm{Role: models.TeamRoleMember}
+
+	app.renderTeam(w, http.StatusOK, team, data)
+}
+
+// renderTeam adds a team, its members and its snippets to data and renders the team page.
+func (app *application) renderTeam(w http.ResponseWriter, status int, team *models.Team, data *templateData) {
+	members, err := app.teams.Members(team.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	snippets, err := app.snippets.ForTeam(team.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.Team = team
+	data.TeamMembers = members
+	data.TeamRoles = models.TeamRoles
+	data.Snippets = snippets
+
+	app.render(w, status, "team.page.tmpl", data)
+}
+
+func (app *application) teamMemberAddPost(w http.ResponseWriter, r *http.Request) {
+	team := app.teamFromParams(w, r)
+	if team == nil {
+		return
+	}
+
+	var form teamMemberForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	form.CheckField(models.ValidTeamRole(form.Role), "role", "This field must be a valid team role")
+
+	if form.Valid() {
+		err = app.teams.AddMember(team.ID, form.Email, form.Role)
+		switch {
+		case err == nil:
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is now a %s of %s!", form.Email, form.Role,
+				team.Name))
+			http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+			return
+		case errors.Is(err, models.ErrLastMaintainer):
+			form.AddFieldError("role", "A team needs at least one maintainer")
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddFieldError("email", "No user has this email address")
+		default:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+
+	app.renderTeam(w, http.StatusUnprocessableEntity, team, data)
+}
+
+func (app *application) teamMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	team := app.teamFromParams(w, r)
+	if team == nil {
+		return
+	}
+
+	var form teamMemberRemoveForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.teams.RemoveMember(team.ID, form.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrLastMaintainer):
+			app.sessionManager.Put(r.Context(), "flash", "A team needs at least one maintainer")
+			http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Member removed from the team")
+
+	http.Redirect(w, r, fmt.Sprintf("/team/view/%d", team.ID), http.StatusSeeOther)
+}
+
+// teamMemberFromParams returns the team in the id URL parameter for creating one of its snippets. Only members can
+// create team snippets, so anyone else gets a 403 response and nil.
+func (app *application) teamMemberFromParams(w http.ResponseWriter, r *http.Request) *models.Team {
+	team := app.teamFromParams(w, r)
+	if team == nil {
+		return nil
+	}
+
+	if team.Role == "" {
+		app.clientError(w, http.StatusForbidden)
+		return nil
+	}
+
+	return team
+}
+
+func (app *application) teamSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	team := app.teamMemberFromParams(w, r)
+	if team == nil {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = snippetCreateForm{Expires: Year}
+	data.Team = team
+
+	app.render(w, http.StatusOK, "create.page.tmpl", data)
+}
+
+func (app *application) teamSnippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	team := app.teamMemberFromParams(w, r)
+	if team == nil {
+		return
+	}
+
+	app.createSnippet(w, r, team)
+}
+
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	avatar, contentType, err := app.users.Avatar(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(avatar)
+}
+
+// accountExport is everything snippetbox holds about a user, as they download it.
+type accountExport struct {
+	Profile                accountExportProfile   `json:"profile"`
+	Snippets               []accountExportSnippet `json:"snippets"`
+	Reviews                []accountExportEvent   `json:"reviews"`
+	Edits                  []accountExportEvent   `json:"edits"`
+	Comments               []accountExportComment `json:"comments"`
+	Stars                  []accountExportStar    `json:"stars"`
+	ReviewRequestsMade     []accountExportRequest `json:"reviewRequestsMade"`
+	ReviewRequestsReceived []accountExportRequest `json:"reviewRequestsReceived"`
+}
+
+type accountExportProfile struct {
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Role             string     `json:"role"`
+	Created          time.Time  `json:"created"`
+	EmailVerified    *time.Time `json:"emailVerified"`
+	PendingEmail     string     `json:"pendingEmail,omitempty"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	Bio              string     `json:"bio"`
+	// Avatar is left out if the user has none.
+	Avatar *accountExportAvatar `json:"avatar,omitempty"`
+}
+
+// accountExportAvatar holds an avatar image, which encodes as base64.
+type accountExportAvatar struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+type accountExportSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Stars   int       `json:"stars"`
+}
+
+// accountExportEvent is a review or an edit from the snippet timeline. SnippetTitle is empty if the snippet has since
+// been deleted, and Detail holds the verdict of a review.
+type accountExportEvent struct {
+	SnippetID    int       `json:"snippetID"`
+	SnippetTitle string    `json:"snippetTitle"`
+	Detail       string    `json:"detail,omitempty"`
+	ActingAdmin  string    `json:"actingAdmin,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+type accountExportComment struct {
+	ID        int        `json:"id"`
+	SnippetID int        `json:"snippetID"`
+	ParentID  int        `json:"parentID,omitempty"`
+	Body      string     `json:"body"`
+	Created   time.Time  `json:"created"`
+	Updated   *time.Time `json:"updated,omitempty"`
+}
+
+type accountExportStar struct {
+	SnippetID    int       `json:"snippetID"`
+	SnippetTitle string    `json:"snippetTitle"`
+	Created      time.Time `json:"created"`
+}
+
+type accountExportRequest struct {
+	SnippetID    int        `json:"snippetID"`
+	SnippetTitle string     `json:"snippetTitle"`
+	Requester    string     `json:"requester"`
+	Reviewer     string     `json:"reviewer"`
+	Created      time.Time  `json:"created"`
+	Due          time.Time  `json:"due"`
+	Completed    *time.Time `json:"completed,omitempty"`
+}
+
+// accountExportView sends the user a JSON archive of their profile, their snippets and what they did on the site:
+// their reviews and edits, comments, stars and the review requests they made or received.
+func (app *application) accountExportView(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	snippets, err := app.snippets.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	events, err := app.snippetEvents.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	comments, err := app.comments.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	stars, err := app.stars.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	requests, err := app.reviewRequests.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	export := accountExport{
+		Profile: accountExportProfile{
+			ID:               user.ID,
+			Name:             user.Name,
+			Email:            user.Email,
+			Role:             user.Role,
+			Created:          user.Created,
+			PendingEmail:     user.PendingEmail,
+			TwoFactorEnabled: user.TwoFactorEnabled,
+			Bio:              user.Bio,
+		},
+		Snippets:               []accountExportSnippet{},
+		Reviews:                []accountExportEvent{},
+		Edits:                  []accountExportEvent{},
+		Comments:               []accountExportComment{},
+		Stars:                  []accountExportStar{},
+		ReviewRequestsMade:     []accountExportRequest{},
+		ReviewRequestsReceived: []accountExportRequest{},
+	}
+
+	if !user.EmailVerified.IsZero() {
+		export.Profile.EmailVerified = &user.EmailVerified
+	}
+
+	if user.HasAvatar {
+		avatar, contentType, err := app.users.Avatar(userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		export.Profile.Avatar = &accountExportAvatar{ContentType: contentType, Data: avatar}
+	}
+
+	for _, s := range snippets {
+		export.Snippets = append(export.Snippets, accountExportSnippet{
+			ID:      s.ID,
+			Title:   s.Title,
+			Content: s.Content,
+			Created: s.Created,
+			Expires: s.Expires,
+			Stars:   s.Stars,
+		})
+	}
+
+	for _, e := range events {
+		event := accountExportEvent{
+			SnippetID:    e.SnippetID,
+			SnippetTitle: e.SnippetTitle,
+			Detail:       e.Detail,
+			ActingAdmin:  e.ActingAdminName,
+			Created:      e.Created,
+		}
+
+		switch e.Kind {
+		case models.EventReview:
+			export.Reviews = append(export.Reviews, event)
+		case models.EventEdit:
+			export.Edits = append(export.Edits, event)
+		}
+	}
+
+	for _, c := range comments {
+		comment := accountExportComment{
+			ID:        c.ID,
+			SnippetID: c.SnippetID,
+			ParentID:  c.ParentID,
+			Body:      c.Body,
+			Created:   c.Created,
+		}
+		if !c.Updated.IsZero() {
+			comment.Updated = &c.Updated
+		}
+		export.Comments = append(export.Comments, comment)
+	}
+
+	for _, st := range stars {
+		export.Stars = append(export.Stars, accountExportStar{
+			SnippetID:    st.SnippetID,
+			SnippetTitle: st.SnippetTitle,
+			Created:      st.Created,
+		})
+	}
+
+	// A request a user made to themselves appears in both lists.
+	for _, rr := range requests {
+		request := accountExportRequest{
+			SnippetID:    rr.SnippetID,
+			SnippetTitle: rr.SnippetTitle,
+			Requester:    rr.RequesterName,
+			Reviewer:     rr.ReviewerName,
+			Created:      rr.Created,
+			Due:          rr.Due,
+		}
+		if !rr.Completed.IsZero() {
+			request.Completed = &rr.Completed
+		}
+		if rr.RequesterID == userID {
+			export.ReviewRequestsMade = append(export.ReviewRequestsMade, request)
+		}
+		if rr.ReviewerID == userID {
+			export.ReviewRequestsReceived = append(export.ReviewRequestsReceived, request)
+		}
+	}
+
+	js, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-export.json"`)
+	w.Write(js)
+}
+
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountDeleteForm{Snippets: SnippetsDelete}
+
+	app.render(w, http.StatusOK, "delete.page.tmpl", data)
+}
+
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	var form accountDeleteForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Snippets, SnippetsDelete, SnippetsReassign), "snippets",
+		"This field must be delete or reassign")
+
+	reassignTo := ""
+	if form.Snippets == SnippetsReassign {
+		form.CheckField(validator.NotBlank(form.ReassignTo), "reassignTo", "This field cannot be blank")
+		reassignTo = form.ReassignTo
+	}
+
+	if form.Valid() {
+		err = app.confirmPassword(r, &form.Validator, user, form.Password, "account deletion")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if form.Valid() {
+		err = app.users.DeleteAccount(userID, reassignTo)
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddFieldError("reassignTo", "No other user has this email address")
+		case errors.Is(err, models.ErrLastAdmin):
+			form.AddNonFieldError("You are the only admin, so make another user an admin first")
+		case err != nil:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "delete.page.tmpl", data)
+		return
+	}
+
+	app.auditAfter(r, models.AuditEvent{ActorID: user.ID, ActorEmail: user.Email, Action: models.AuditUserDelete,
+		TargetID: user.ID, TargetEmail: user.Email, Detail: "deleted by the user"})
+
+	// The user's other sessions end because they no longer exist, and their remember tokens went with them.
+	clearRememberCookie(w)
+
+	err = app.sessionManager.Destroy(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+
+	app.render(w, http.StatusOK, "password.page.tmpl", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword",
+		"This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword",
+		"This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation",
+		"This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation",
+		"Passwords do not match")
+
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.checkPassword(&form.Validator, "newPassword", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+
+		app.render(w, http.StatusUnprocessableEntity, "password.page.tmpl", data)
+		return
+	}
+
+	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+
+			app.render(w, http.StatusUnprocessableEntity, "password.page.tmpl", data)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Anyone who got into the account with the old password is logged out.
+	err = app.destroyOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.auditAfter(r, models.AuditEvent{Action: models.AuditPasswordChange, TargetID: userID})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) reviewUpdatePost(w http.ResponseWriter, r *http.Request) {
+	// ParamsFromContext returns a slice containing parameter names and values from the request context.
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// ByName gets the value of the "id" named parameter from the slice and validates it as normal.
+	snippetID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || snippetID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	var form reviewForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !validator.PermittedValue(form.Verdict, models.VerdictApproved, models.VerdictChangesRequested) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippet, err := app.snippet(r, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Retrieve the ID of the user the authentication middleware logged in. It is 0 for a visitor.
+	userID := app.authenticatedUserID(r)
+
+	err = app.reviews.Update(userID, snippet.ID, app.impersonatorID(r), form.Verdict)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// A submitted review completes any open review requests for this reviewer and snippet.
+	err = app.reviewRequests.Complete(userID, snippetID, form.Verdict)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Put adds a key and string value to the session data.
+	app.sessionManager.Put(r.Context(), "flash", "Review successfully submitted!")
+
+	// Redirect the user to the relevant page for the section.
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippetID), http.StatusSeeOther)
+}
+
+func (app *application) reviewRequestPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	snippetID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || snippetID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippet(r, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var form reviewRequestForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The due date covers the whole day, so the request becomes overdue at the end of it.
+	due, err := time.Parse(DateLayout, form.Due)
+	due = due.Add(24*time.Hour - time.Second)
+
+	if form.Team == 0 {
+		form.CheckField(validator.NotBlank(form.Reviewer), "reviewer", "This field cannot be blank")
+		form.CheckField(validator.Matches(form.Reviewer, validator.EmailRX), "reviewer",
+			"This field must be a valid email address")
+	}
+	form.CheckField(validator.NotBlank(form.Due), "due", "This field cannot be blank")
+	form.CheckField(err == nil, "due", "This field must be a valid date")
+	form.CheckField(due.After(time.Now()), "due", "This field must be a date in the future")
+
+	// A team can be asked by its own members, or by anyone for the snippets it owns.
+	if form.Valid() && form.Team != 0 && form.Team != snippet.TeamID {
+		_, err := app.teams.Role(form.Team, app.authenticatedUserID(r))
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, err)
+				return
+			}
+			form.AddFieldError("team", "You can only ask a team you are in, or the team that owns this snippet")
+		}
+	}
+
+	if form.Valid() && form.Team != 0 {
+		requesterID := app.authenticatedUserID(r)
+
+		n, err := app.reviewRequests.InsertTeam(snippet.ID, requesterID, form.Team, due)
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Review requested from %d team members!", n))
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+			return
+		}
+
+		if !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddFieldError("team", "Nobody else in this team can review this snippet")
+	} else if form.Valid() {
+		requesterID := app.authenticatedUserID(r)
+
+		_, err = app.reviewRequests.Insert(snippet.ID, requesterID, form.Reviewer, due)
+		if err == nil {
+			app.sessionManager.Put(r.Context(), "flash", "Review successfully requested!")
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+			return
+		}
+
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddFieldError("reviewer", "No user has this email address")
+		case errors.Is(err, models.ErrSelfReview):
+			form.AddFieldError("reviewer", "You can't ask yourself to review a snippet")
+		case errors.Is(err, models.ErrCannotReview):
+			form.AddFieldError("reviewer", "This user can't review this snippet")
+		case errors.Is(err, models.ErrAlreadyRequested):
+			form.AddFieldError("reviewer", "This user has already been asked to review this snippet")
+		default:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	// Redisplay the snippet with the request form errors along with a 422 status code.
+	data, err := app.snippetViewData(r, snippet)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.Form = form
+
+	app.render(w, http.StatusUnprocessableEntity, "view.page.tmpl", data)
+}
+
+func (app *application) reviewQueue(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	requests, err := app.reviewRequests.Queue(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.ReviewRequests = requests
+
+	app.render(w, http.StatusOK, "queue.page.tmpl", data)
+}
+
+func (app *application) reviewStats(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "30d"
+	}
+
+	days, ok := statsWindows[window]
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	stats, ok := app.statsCache.get(window)
+	if !ok {
+		var since time.Time
+		if days > 0 {
+			since = time.Now().AddDate(0, 0, -days)
+		}
+
+		var err error
+
+		stats, err = app.reviews.Stats(since)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.statsCache.set(window, stats)
+	}
+
+	data := app.newTemplateData(r)
+	data.ReviewerStats = stats
+	data.Window = window
+
+	app.render(w, http.StatusOK, "stats.page.tmpl", data)
+}
+
+func (app *application) commentCreatePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	snippetID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || snippetID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippet(r, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var form commentForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, BodyMaxChars), "body",
+		"This field cannot be more than 5000 characters long")
+
+	// Redisplay the snippet with the comment form errors along with a 422 status code.
+	if !form.Valid() {
+		data, err := app.snippetViewData(r, snippet)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.CommentForm = form
+
+		app.render(w, http.StatusUnprocessableEntity, "view.page.tmpl", data)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	id, err := app.comments.Insert(snippet.ID, userID, form.ParentID, form.Body)
+	if err != nil {
+		// The parent comment does not exist or belongs to another snippet.
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Comment successfully posted!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, id), http.StatusSeeOther)
+}
+
+// commentFromParams gets the comment named by the "id" parameter. It sends a 404 response and returns nil if the
+// comment does not exist, and sends a 403 response and returns nil if the current user did not write it and
+// moderate is false or the user cannot moderate comments.
+func (app *application) commentFromParams(w http.ResponseWriter, r *http.Request, moderate bool) *models.Comment {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil
+	}
+
+	comment, err := app.comments.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	// Moderators can remove any comment, but only the author can change one.
+	if comment.UserID != userID && !(moderate && app.can(r, models.PermCommentModerate)) {
+		app.clientError(w, http.StatusForbidden)
+		return nil
+	}
+
+	return comment
+}
+
+func (app *application) commentEdit(w http.ResponseWriter, r *http.Request) {
+	comment := app.commentFromParams(w, r, false)
+	if comment == nil {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = commentForm{Body: comment.Body}
+	data.Comment = comment
+
+	app.render(w, http.StatusOK, "comment.page.tmpl", data)
+}
+
+func (app *application) commentEditPost(w http.ResponseWriter, r *http.Request) {
+	comment := app.commentFromParams(w, r, false)
+	if comment == nil {
+		return
+	}
+
+	var form commentForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, BodyMaxChars), "body",
+		"This field cannot be more than 5000 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.Comment = comment
+
+		app.render(w, http.StatusUnprocessableEntity, "comment.page.tmpl", data)
+		return
+	}
+
+	err = app.comments.Update(comment.ID, form.Body)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Comment successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetID, comment.ID),
+		http.StatusSeeOther)
+}
+
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	comment := app.commentFromParams(w, r, true)
+	if comment == nil {
+		return
+	}
+
+	// Moderators remove the whole thread under a comment, but an author only takes back their own words.
+	var err error
+	if app.can(r, models.PermCommentModerate) {
+		err = app.comments.Delete(comment.ID)
+	} else {
+		err = app.comments.Retract(comment.ID)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Comment successfully deleted!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", comment.SnippetID), http.StatusSeeOther)
+}
+
+func (app *application) starPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	snippetID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || snippetID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippet(r, snippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// The form carries the state the user asked for rather than a toggle, so a repeated submission leaves the
+	// snippet starred or unstarred as intended.
+	var form starForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	err = app.stars.Set(userID, snippet.ID, form.Star)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+func (app *application) accountFavorites(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	snippets, err := app.stars.Favorites(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
-	app.render(w, http.StatusOK, "about.page.tmpl", data)
+	data.Snippets = snippets
+
+	app.render(w, http.StatusOK, "favorites.page.tmpl", data)
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	sessions, err := app.userSessions.List(userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Sessions = sessions
+
+	app.render(w, http.StatusOK, "sessions.page.tmpl", data)
+}
+
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	// Users can only revoke their own sessions, so the session of another user is not found.
+	session, err := app.userSessions.Delete(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// A remembered session would otherwise come straight back.
+	if session.RememberFamily != "" {
+		err = app.rememberTokens.DeleteFamily(session.RememberFamily)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	current := session.Token == app.sessionManager.Token(r.Context())
+
+	err = app.revokeSession(r.Context(), session.Token)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if current {
+		app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been signed out")
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+func (app *application) accountSessionsRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	err := app.destroyUserSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been signed out everywhere. Please log in again")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountTokenForm{Scopes: []string{models.ScopeRead}, Expires: Month}
+
+	// A new token is only shown once, straight after it is created.
+	data.Token = app.sessionManager.PopString(r.Context(), "apiToken")
+
+	app.renderTokens(w, r, http.StatusOK, data)
+}
+
+// renderTokens adds the current user's API tokens to data and renders the API tokens page.
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, status int, data *templateData) {
+	tokens, err := app.apiTokens.List(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.APITokens = tokens
+	data.Scopes = models.Scopes
+
+	app.render(w, status, "tokens.page.tmpl", data)
+}
+
+func (app *application) accountTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountTokenForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, TokenNameMaxChars), "name",
+		fmt.Sprintf("This field cannot be more than %d characters long", TokenNameMaxChars))
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Choose at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(models.ValidScope(scope), "scopes", "This field must be a valid scope")
+	}
+	form.CheckField(validator.PermittedValue(form.Expires, 0, Week, Month, 3*Month, Year), "expires",
+		"This field must equal 0, 7, 30, 90 or 365")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.renderTokens(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+
+	var expires time.Time
+	if form.Expires > 0 {
+		expires = time.Now().AddDate(0, 0, form.Expires)
+	}
+
+	token, err := app.apiTokens.Insert(app.authenticatedUserID(r), form.Name, form.Scopes, expires)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "apiToken", token)
+	app.sessionManager.Put(r.Context(), "flash", "API token created!")
+
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+func (app *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// Users can only revoke their own tokens, so the token of another user is not found.
+	err = app.apiTokens.Revoke(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The API token has been revoked")
+
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+func (app *application) invitationList(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = invitationForm{Role: models.RoleReviewer}
+
+	app.renderInvitations(w, http.StatusOK, data)
+}
+
+// renderInvitations adds the pending invitations to data and renders the invitations page.
+func (app *application) renderInvitations(w http.ResponseWriter, status int, data *templateData) {
+	invitations, err := app.invitations.Pending()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.Invitations = invitations
+
+	app.render(w, status, "invitations.page.tmpl", data)
+}
+
+func (app *application) invitationCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form invitationForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email",
+		"This field must be a valid email address")
+	form.CheckField(models.ValidRole(form.Role), "role", "This field must be a valid role")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.renderInvitations(w, http.StatusUnprocessableEntity, data)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	token, err := app.invitations.Insert(form.Email, form.Role, userID, InvitationTTL)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sendInvitation(form.Email, token)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s!", form.Email))
+
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+func (app *application) invitationResendPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	invitation, token, err := app.invitations.Resend(id, InvitationTTL)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.sendInvitation(invitation.Email, token)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Invitation resent to %s!", invitation.Email))
+
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+func (app *application) invitationRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.invitations.Revoke(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Invitation revoked!")
+
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
 }
 
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	// GetInt will return 0 if no authenticatedUserID value is in the session.
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+// sendInvitation sends the invitation link for a token to the invitee.
+func (app *application) sendInvitation(email, token string) error {
+	body := fmt.Sprintf("You have been invited to join Snippetbox. Sign up within %d days at:\n%s/user/invite/%s",
+		Week, app.baseURL, token)
 
-	user, err := app.users.Get(userID)
+	return app.mailer.Send(email, "Your Snippetbox invitation", body)
+}
+
+// invitationFromParams gets the pending invitation for the "token" parameter. If there is none, it sends the
+// visitor to the login page with a flash message and returns nil.
+func (app *application) invitationFromParams(w http.ResponseWriter, r *http.Request) *models.Invitation {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	invitation, err := app.invitations.Get(params.ByName("token"))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This invitation is invalid or has expired")
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		} else {
 			app.serverError(w, err)
 		}
-		return
+		return nil
 	}
 
-	data := app.newTemplateData(r)
-	data.User = user
-	app.render(w, http.StatusOK, "account.page.tmpl", data)
+	return invitation
 }
 
-func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+func (app *application) userInvite(w http.ResponseWriter, r *http.Request) {
+	invitation := app.invitationFromParams(w, r)
+	if invitation == nil {
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = accountPasswordUpdateForm{}
+	data.Invitation = invitation
+	data.Token = httprouter.ParamsFromContext(r.Context()).ByName("token")
+	data.Form = userInviteForm{}
 
-	app.render(w, http.StatusOK, "password.page.tmpl", data)
+	app.render(w, http.StatusOK, "invite.page.tmpl", data)
 }
 
-func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
-	var form accountPasswordUpdateForm
+func (app *application) userInvitePost(w http.ResponseWriter, r *http.Request) {
+	invitation := app.invitationFromParams(w, r)
+	if invitation == nil {
+		return
+	}
+
+	var form userInviteForm
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -380,73 +3234,332 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword",
-		"This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword",
-		"This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPassword, MinChars), "newPassword",
-		"This field must be at least 8 characters long")
-	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation",
-		"This field cannot be blank")
-	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation",
-		"Passwords do not match")
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	err = app.checkPassword(&form.Validator, "password", form.Password, form.Name, invitation.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.Invitation = invitation
+		data.Token = httprouter.ParamsFromContext(r.Context()).ByName("token")
 		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "invite.page.tmpl", data)
+		return
+	}
 
-		app.render(w, http.StatusUnprocessableEntity, "password.page.tmpl", data)
+	err = app.invitations.AcceptWithUser(invitation.ID, form.Name, form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddNonFieldError("An account already exists for this email address. Please log in")
+			data := app.newTemplateData(r)
+			data.Invitation = invitation
+			data.Token = httprouter.ParamsFromContext(r.Context()).ByName("token")
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "invite.page.tmpl", data)
+		case errors.Is(err, models.ErrNoRecord):
+			// Another submission of the same invitation got there first.
+			app.sessionManager.Put(r.Context(), "flash", "This invitation is invalid or has expired")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.audit(r, models.AuditEvent{Action: models.AuditSignup, TargetEmail: invitation.Email,
+		Detail: invitation.Role + " by invitation"})
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
 
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in")
 
-	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+
+	users, err := app.users.List(search)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddFieldError("currentPassword", "Current password is incorrect")
+		app.serverError(w, err)
+		return
+	}
 
-			data := app.newTemplateData(r)
-			data.Form = form
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Search = search
 
-			app.render(w, http.StatusUnprocessableEntity, "password.page.tmpl", data)
+	required, err := app.settings.Bool(models.SettingRequireAdminTwoFactor)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.RequireAdminTwoFactor = required
+
+	app.render(w, http.StatusOK, "users.page.tmpl", data)
+}
+
+// adminUserFromParams gets the user named by the "id" parameter for an admin to manage. It sends a 404 response
+// if the user does not exist, and refuses changes to the admin's own account so that there is always an admin
+// left to manage the others. In both cases it returns nil.
+func (app *application) adminUserFromParams(w http.ResponseWriter, r *http.Request) *models.User {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
+		return nil
+	}
+
+	if user.ID == app.authenticatedUserID(r) {
+		app.sessionManager.Put(r.Context(), "flash", "You can't change your own account here")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUserFromParams(w, r)
+	if user == nil {
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+	var form adminUserForm
 
-	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidRole(form.Role) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(user.ID, form.Role)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, models.AuditEvent{Action: models.AuditRoleChange, TargetID: user.ID,
+		Detail: user.Role + " to " + form.Role})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Role updated for %s!", user.Name))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
-func (app *application) reviewUpdatePost(w http.ResponseWriter, r *http.Request) {
-	// ParamsFromContext returns a slice containing parameter names and values from the request context.
-	params := httprouter.ParamsFromContext(r.Context())
+func (app *application) adminUserStatusPost(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUserFromParams(w, r)
+	if user == nil {
+		return
+	}
 
-	// ByName gets the value of the "id" named parameter from the slice and validates it as normal.
-	snippetID, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || snippetID < 1 {
-		app.notFound(w)
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetDisabled(user.ID, form.Disabled)
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
 
-	// Retrieve the authenticatedUserID value from the session
-	// GetInt will return 0 if no authenticatedUserID value is in the session.
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	status, action := "enabled", models.AuditUserEnable
+	if form.Disabled {
+		status, action = "disabled", models.AuditUserDisable
+	}
+
+	app.auditAfter(r, models.AuditEvent{Action: action, TargetID: user.ID})
 
-	err = app.reviews.Update(userID, snippetID)
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Account %s for %s!", status, user.Name))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserResetPost(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUserFromParams(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.users.RequirePasswordReset(user.ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Put adds a key and string value to the session data.
-	app.sessionManager.Put(r.Context(), "flash", "Review successfully submitted!")
+	err = app.destroyUserSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	// Redirect the user to the relevant page for the section.
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippetID), http.StatusSeeOther)
+	app.auditAfter(r, models.AuditEvent{Action: models.AuditPasswordReset, TargetID: user.ID,
+		Detail: "required by an admin"})
+
+	flash := fmt.Sprintf("%s's password has been reset and they have been emailed a link to choose a new one!",
+		user.Name)
+
+	// A disabled user gets no link, since they can't use it until they are enabled again.
+	token, err := app.passwordResets.Insert(user.Email, ResetTTL)
+	switch {
+	case err == nil:
+		body := fmt.Sprintf("An admin has reset the password of your Snippetbox account. Choose a new password "+
+			"within an hour at:\n%s/user/password/reset/%s\n\n"+
+			"If the link expires, you can ask for a new one at %s/user/password/forgot", app.baseURL, token,
+			app.baseURL)
+
+		// The password has already been reset, so a failure to send the link is reported to the admin rather
+		// than turned into an error page.
+		err = app.mailer.Send(user.Email, "Your Snippetbox password has been reset", body)
+		if err != nil {
+			app.errorLog.Print(err)
+			flash = fmt.Sprintf("%s's password has been reset, but we couldn't email them a link to choose a "+
+				"new one. They can ask for one from the forgot password page", user.Name)
+		}
+	case !errors.Is(err, models.ErrNoRecord):
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserImpersonatePost lets an admin view the site as another user, to see what they see. The admin stays in
+// the same session, which is listed among their own sessions rather than the user's, until they stop or log out.
+func (app *application) adminUserImpersonatePost(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUserFromParams(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.Disabled {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account is disabled", user.Name))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err := app.audit(r, models.AuditEvent{Action: models.AuditImpersonationStart, TargetID: user.ID})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	admin := app.authenticatedUserID(r)
+
+	// Take the session out of the index before its token changes.
+	err = app.userSessions.DeleteToken(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Index the renewed session under the admin before it is used as the user.
+	err = app.trackSession(r, admin)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
+	app.sessionManager.Put(r.Context(), "impersonatorID", admin)
+	app.sessionManager.Put(r.Context(), "impersonatingName", user.Name)
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You're now viewing Snippetbox as %s", user.Name))
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) adminUserDeletePost(w http.ResponseWriter, r *http.Request) {
+	user := app.adminUserFromParams(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.users.Delete(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The user is gone, so their email address can no longer be looked up by ID.
+	app.auditAfter(r, models.AuditEvent{Action: models.AuditUserDelete, TargetID: user.ID, TargetEmail: user.Email,
+		Detail: "deleted by an admin"})
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Account deleted for %s!", user.Name))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminSettingsPost(w http.ResponseWriter, r *http.Request) {
+	var form adminSettingsForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	required, err := app.settings.Bool(models.SettingRequireAdminTwoFactor)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.settings.SetBool(models.SettingRequireAdminTwoFactor, form.RequireAdminTwoFactor)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if required != form.RequireAdminTwoFactor {
+		app.auditAfter(r, models.AuditEvent{Action: models.AuditSettingChange,
+			Detail: fmt.Sprintf("%s: %t to %t", models.SettingRequireAdminTwoFactor, required,
+				form.RequireAdminTwoFactor)})
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Settings updated!")
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
 func ping(w http.ResponseWriter, r *http.Request) {
@@ -456,3 +3569,72 @@ func ping(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "OK")
 	}
 }
+
+// auditSearch returns the search text and the action the audit log is filtered by. An unknown action is ignored.
+func auditSearch(r *http.Request) (string, string) {
+	search := r.URL.Query().Get("q")
+
+	action := r.URL.Query().Get("action")
+	if !slices.Contains(models.AuditActions, action) {
+		action = ""
+	}
+
+	return search, action
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	search, action := auditSearch(r)
+
+	events, err := app.auditEvents.Search(search, action, AuditViewLimit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.AuditEvents = events
+	data.AuditActions = models.AuditActions
+	data.Search = search
+	data.Action = action
+
+	app.render(w, http.StatusOK, "audit.page.tmpl", data)
+}
+
+// adminAuditExport downloads the events matching the same search as the audit log as a CSV file.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	search, action := auditSearch(r)
+
+	events, err := app.auditEvents.Search(search, action, AuditExportLimit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-audit.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "action", "actor_id", "actor_email", "acting_admin_id", "acting_admin_email",
+		"target_id", "target_email", "detail", "ip", "user_agent"})
+
+	for _, e := range events {
+		cw.Write([]string{
+			e.Created.UTC().Format(time.RFC3339),
+			e.Action,
+			strconv.Itoa(e.ActorID),
+			csvText(e.ActorEmail),
+			strconv.Itoa(e.ActingAdminID),
+			csvText(e.ActingAdminEmail),
+			strconv.Itoa(e.TargetID),
+			csvText(e.TargetEmail),
+			csvText(e.Detail),
+			csvText(e.IP),
+			csvText(e.UserAgent),
+		})
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.errorLog.Print(err)
+	}
+}
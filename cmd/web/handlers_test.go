@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mabego/snippetbox-mysql/internal/assert"
+	"github.com/mabego/snippetbox-mysql/internal/auth"
+	"github.com/mabego/snippetbox-mysql/internal/models"
+	"github.com/mabego/snippetbox-mysql/internal/models/mocks"
+	"github.com/mabego/snippetbox-mysql/internal/oidc"
+	"github.com/mabego/snippetbox-mysql/internal/oidc/oidctest"
+	"github.com/mabego/snippetbox-mysql/internal/totp"
 )
 
 func TestPing(t *testing.T) {
@@ -61,6 +73,12 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "Review completed.",
 		},
+		{
+			name:     "Timeline",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "approved the snippet",
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/2",
@@ -122,6 +140,7 @@ func TestUserSignup(t *testing.T) {
 		userName     string
 		userEmail    string
 		userPassword string
+		role         string
 		csrfToken    string
 		wantCode     int
 		wantFormTag  string
@@ -134,6 +153,16 @@ func TestUserSignup(t *testing.T) {
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusSeeOther,
 		},
+		{
+			name:         "Invalid role",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			role:         "owner",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 		{
 			name:         "Invalid CSRF Token",
 			userName:     validName,
@@ -187,6 +216,24 @@ func TestUserSignup(t *testing.T) {
 			wantCode:     http.StatusUnprocessableEntity,
 			wantFormTag:  formTag,
 		},
+		{
+			name:         "Weak password",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: "qwerty2024",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Password contains email",
+			userName:     validName,
+			userEmail:    "robert.smith@example.com",
+			userPassword: "smith-Pa$$word-42",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 		{
 			name:         "Duplicate email",
 			userName:     validName,
@@ -206,6 +253,13 @@ func TestUserSignup(t *testing.T) {
 			form.Add("password", tt.userPassword)
 			form.Add("csrf_token", tt.csrfToken)
 
+			// Default to a valid role so that each case only breaks one field.
+			role := tt.role
+			if role == "" {
+				role = "reviewer"
+			}
+			form.Add("role", role)
+
 			code, _, body := ts.postForm(t, "/user/signup", form)
 
 			assert.Equal(t, code, tt.wantCode)
@@ -215,6 +269,24 @@ func TestUserSignup(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Verification email fails", func(t *testing.T) {
+		app.mailer = failingSender{}
+
+		form := url.Values{}
+		form.Add("name", validName)
+		form.Add("email", validEmail)
+		form.Add("password", validPassword)
+		form.Add("role", "reviewer")
+		form.Add("csrf_token", validCSRFToken)
+
+		code, headers, _ := ts.postForm(t, "/user/signup", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.StringContains(t, body, "we couldn&#39;t send your verification email")
+	})
 }
 
 func TestSnippetCreate(t *testing.T) {
@@ -248,3 +320,2976 @@ func TestSnippetCreate(t *testing.T) {
 		assert.StringContains(t, body, "<form action=\"/snippet/create\" method=\"POST\">")
 	})
 }
+
+func TestSnippetDeletePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/snippet/delete/1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/delete/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestReviewQueue(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/review/queue")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<a href="/snippet/view/1">An old silent pond</a>`)
+	assert.StringContains(t, body, `<span class="overdue">`)
+}
+
+func TestReviewRequestPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(DateLayout)
+	yesterday := time.Now().AddDate(0, 0, -1).Format(DateLayout)
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		reviewer  string
+		team      string
+		due       string
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  "/snippet/request/1",
+			reviewer: "bob@example.com",
+			due:      tomorrow,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:      "Unknown reviewer",
+			urlPath:   "/snippet/request/1",
+			reviewer:  "nobody@example.com",
+			due:       tomorrow,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "No user has this email address",
+		},
+		{
+			name:      "Requester as reviewer",
+			urlPath:   "/snippet/request/1",
+			reviewer:  "alice@example.com",
+			due:       tomorrow,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "You can&#39;t ask yourself to review a snippet",
+		},
+		{
+			name:      "Reviewer with an open request",
+			urlPath:   "/snippet/request/1",
+			reviewer:  "dave@example.com",
+			due:       tomorrow,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This user has already been asked to review this snippet",
+		},
+		{
+			name:      "Past due date",
+			urlPath:   "/snippet/request/1",
+			reviewer:  "alice@example.com",
+			due:       yesterday,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field must be a date in the future",
+		},
+		{
+			name:      "Invalid due date",
+			urlPath:   "/snippet/request/1",
+			reviewer:  "alice@example.com",
+			due:       "next week",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field must be a valid date",
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/request/2",
+			reviewer: "alice@example.com",
+			due:      tomorrow,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Team",
+			urlPath:  "/snippet/request/1",
+			team:     "1",
+			due:      tomorrow,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Team that owns the snippet",
+			urlPath:  "/snippet/request/3",
+			team:     "1",
+			due:      tomorrow,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			// Alice isn't in Security, which doesn't own snippet 1.
+			name:      "Team the requester is not in",
+			urlPath:   "/snippet/request/1",
+			team:      "2",
+			due:       tomorrow,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "You can only ask a team you are in, or the team that owns this snippet",
+		},
+		{
+			name:      "Non-existent team",
+			urlPath:   "/snippet/request/1",
+			team:      "9",
+			due:       tomorrow,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "You can only ask a team you are in, or the team that owns this snippet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("reviewer", tt.reviewer)
+			form.Add("team", tt.team)
+			form.Add("due", tt.due)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantError != "" {
+				assert.StringContains(t, body, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReviewStats(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Default window",
+			urlPath:  "/review/stats",
+			wantCode: http.StatusOK,
+			wantBody: "<b>30d</b>",
+		},
+		{
+			name:     "All time",
+			urlPath:  "/review/stats?window=all",
+			wantCode: http.StatusOK,
+			wantBody: "<td>75%</td>",
+		},
+		{
+			name:     "Unknown window",
+			urlPath:  "/review/stats?window=2d",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetEditPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	const formTag = `<form action="/snippet/edit/1" method="POST">`
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		title       string
+		content     string
+		expires     string
+		wantCode    int
+		wantFormTag string
+	}{
+		{
+			name:     "Valid submission",
+			urlPath:  "/snippet/edit/1",
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond",
+			expires:  "0",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:        "Empty title",
+			urlPath:     "/snippet/edit/1",
+			title:       "",
+			content:     "A frog jumps into the pond",
+			expires:     "7",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:        "Invalid expiry",
+			urlPath:     "/snippet/edit/1",
+			title:       "An old silent pond",
+			content:     "A frog jumps into the pond",
+			expires:     "30",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantFormTag: formTag,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/edit/2",
+			title:    "An old silent pond",
+			content:  "A frog jumps into the pond",
+			expires:  "0",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantFormTag != "" {
+				assert.StringContains(t, body, tt.wantFormTag)
+			}
+		})
+	}
+}
+
+func TestCommentCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     string
+		parentID string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid comment",
+			urlPath:  "/snippet/comment/1",
+			body:     "A *frog* jumps",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Valid reply",
+			urlPath:  "/snippet/comment/1",
+			body:     "The sound of water",
+			parentID: "1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Empty body",
+			urlPath:  "/snippet/comment/1",
+			body:     "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Unknown parent",
+			urlPath:  "/snippet/comment/1",
+			body:     "The sound of water",
+			parentID: "99",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/comment/2",
+			body:     "A *frog* jumps",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("parentID", tt.parentID)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("Edit own comment", func(t *testing.T) {
+		code, _, body := ts.get(t, "/comment/edit/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<form action="/comment/edit/1" method="POST" novalidate>`)
+	})
+
+	t.Run("Edit another user's comment", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/comment/edit/2")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Admin deletes another user's comment", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, headers, _ := ts.postForm(t, "/comment/delete/2", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/view/1")
+
+		// A moderator's deletion takes the replies with it.
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.Equal(t, strings.Contains(body, `id="comment-2"`), false)
+		assert.Equal(t, strings.Contains(body, `id="comment-3"`), false)
+	})
+}
+
+func TestCommentDeleteByAuthor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Bob is a reviewer, who cannot moderate comments, and Alice has replied to his comment.
+	csrfToken := ts.login(t, "bob@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, headers, _ := ts.postForm(t, "/comment/delete/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/view/1")
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `id="comment-2"`)
+	assert.StringContains(t, body, models.CommentDeletedBody)
+	assert.Equal(t, strings.Contains(body, "Splash! Silence again."), false)
+	assert.StringContains(t, body, "The ripples fade")
+}
+
+func TestStarPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		star     string
+		csrf     string
+		wantCode int
+	}{
+		{
+			name:     "Star",
+			urlPath:  "/snippet/star/1",
+			star:     "true",
+			csrf:     csrfToken,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Star again",
+			urlPath:  "/snippet/star/1",
+			star:     "true",
+			csrf:     csrfToken,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Unstar",
+			urlPath:  "/snippet/star/1",
+			star:     "false",
+			csrf:     csrfToken,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid CSRF Token",
+			urlPath:  "/snippet/star/1",
+			star:     "true",
+			csrf:     "wrongToken",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/star/2",
+			star:     "true",
+			csrf:     csrfToken,
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("star", tt.star)
+			form.Add("csrf_token", tt.csrf)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAccountFavorites(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/favorites")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<a href="/snippet/view/1">An old silent pond</a>`)
+	assert.StringContains(t, body, "★ 3")
+}
+
+func TestInvitationCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	sender := &recordingSender{}
+	app.mailer = sender
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name      string
+		email     string
+		role      string
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "Reviewer",
+			email:    "bob@example.com",
+			role:     "reviewer",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Admin",
+			email:    "carol@example.com",
+			role:     "admin",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:      "Invalid email",
+			email:     "bob@example.",
+			role:      "reviewer",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field must be a valid email address",
+		},
+		{
+			name:      "Invalid role",
+			email:     "dave@example.com",
+			role:      "owner",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field must be a valid role",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("role", tt.role)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/admin/invitations", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantError != "" {
+				assert.StringContains(t, body, tt.wantError)
+			}
+		})
+	}
+
+	// Only the valid submissions send an invitation.
+	assert.Equal(t, len(sender.recipients), 2)
+	assert.Equal(t, sender.recipients[0], "bob@example.com")
+}
+
+func TestUserInvite(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/user/invite/wrong-token")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	_, _, body := ts.get(t, "/user/invite/valid-token")
+	assert.StringContains(t, body, `<input type="email" name="email" value="bob@example.com" disabled>`)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		userName string
+		password string
+		wantCode int
+	}{
+		{
+			name:     "Short password",
+			userName: "Bob",
+			password: "pa$$",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Valid submission",
+			userName: "Bob",
+			password: "validPa$$word",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/user/invite/valid-token", form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("Search", func(t *testing.T) {
+		code, _, body := ts.get(t, "/admin/users?q=bob")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "<td>bob@example.com</td>")
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		field        string
+		value        string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Make editor",
+			urlPath:      "/admin/users/role/2",
+			field:        "role",
+			value:        "editor",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin/users",
+		},
+		{
+			name:     "Invalid role",
+			urlPath:  "/admin/users/role/2",
+			field:    "role",
+			value:    "owner",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Disable",
+			urlPath:      "/admin/users/status/2",
+			field:        "disabled",
+			value:        "true",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin/users",
+		},
+		{
+			name:         "Reset password",
+			urlPath:      "/admin/users/reset/2",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin/users",
+		},
+		{
+			name:         "Delete",
+			urlPath:      "/admin/users/delete/2",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin/users",
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/admin/users/delete/99",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			if tt.field != "" {
+				form.Add(tt.field, tt.value)
+			}
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	t.Run("Audit", func(t *testing.T) {
+		events, err := app.auditEvents.Search("bob@example.com", models.AuditUserDisable, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].ActorEmail, "alice@example.com")
+
+		events, err = app.auditEvents.Search("bob@example.com", models.AuditUserDelete, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].Detail, "deleted by an admin")
+	})
+
+	t.Run("Own account", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		ts.postForm(t, "/admin/users/delete/1", form)
+
+		// The refusal is shown as a flash message on the users page.
+		_, _, body := ts.get(t, "/admin/users")
+		assert.StringContains(t, body, "You can&#39;t change your own account here")
+	})
+}
+
+func TestUserPasswordForgotPost(t *testing.T) {
+	app := newTestApplication(t)
+	sender := &recordingSender{}
+	app.mailer = sender
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{
+			name:     "Existing account",
+			email:    "alice@example.com",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Unknown account",
+			email:    "nobody@example.com",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Invalid email",
+			email:    "alice@example.",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/user/password/forgot", form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// Only the existing account is sent a reset link.
+	app.wg.Wait()
+	assert.Equal(t, len(sender.recipients), 1)
+	assert.StringContains(t, sender.bodies[0], "/user/password/reset/valid-token")
+}
+
+func TestUserPasswordForgotPostMailFailure(t *testing.T) {
+	app := newTestApplication(t)
+	app.mailer = failingSender{}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	// A mail server that is down gives the same answer as an unknown account.
+	code, headers, _ := ts.postForm(t, "/user/password/forgot", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	app.wg.Wait()
+}
+
+func TestUserPasswordResetPost(t *testing.T) {
+	app := newTestApplication(t)
+	app.passwordPolicy.Breached = newBreachedPasswords(t, "Breached-Pa$$word")
+
+	// Alice is logged in on one device and resets her password on another.
+	device := newTestServer(t, app.routes())
+	defer device.Close()
+	device.login(t, "alice@example.com", "pa$$word")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/user/password/reset/invalid-token")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/password/forgot")
+	})
+
+	code, _, body := ts.get(t, "/user/password/reset/valid-token")
+	assert.Equal(t, code, http.StatusOK)
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		password     string
+		confirmation string
+		wantCode     int
+	}{
+		{
+			name:         "Passwords do not match",
+			password:     "newPa$$word",
+			confirmation: "otherPa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Short password",
+			password:     "pa$$",
+			confirmation: "pa$$",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Weak password",
+			password:     "password123",
+			confirmation: "password123",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Contains name",
+			password:     "Alice-Pa$$word-7",
+			confirmation: "Alice-Pa$$word-7",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Breached password",
+			password:     "Breached-Pa$$word",
+			confirmation: "Breached-Pa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Valid submission",
+			password:     "newPa$$word",
+			confirmation: "newPa$$word",
+			wantCode:     http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("newPassword", tt.password)
+			form.Add("newPasswordConfirmation", tt.confirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/user/password/reset/valid-token", form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	t.Run("Other sessions logged out", func(t *testing.T) {
+		code, headers, _ := device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	app := newTestApplication(t)
+	sender := &recordingSender{}
+	app.mailer = sender
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Carol is an editor who has not verified their email address yet.
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	t.Run("Limited until verified", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippet/create")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Please verify your email address")
+	})
+
+	t.Run("Resend", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/account/verify/resend", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Verification email sent to carol@example.com!")
+		assert.Equal(t, len(sender.recipients), 1)
+		assert.StringContains(t, sender.bodies[0], "/user/verify/valid-token")
+	})
+
+	t.Run("Resend too soon", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		ts.postForm(t, "/account/verify/resend", form)
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Please wait a minute before requesting another email")
+		assert.Equal(t, len(sender.recipients), 1)
+	})
+
+	t.Run("Daily limit", func(t *testing.T) {
+		verifications := app.emailVerifications.(*mocks.EmailVerificationModel)
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		// Space the emails out so that only the daily limit applies.
+		for len(sender.recipients) < VerificationDailyLimit {
+			verifications.Age(2 * time.Minute)
+			ts.postForm(t, "/account/verify/resend", form)
+		}
+
+		verifications.Age(2 * time.Minute)
+		ts.postForm(t, "/account/verify/resend", form)
+
+		_, _, body := ts.get(t, "/account/view")
+		assert.StringContains(t, body, "You have requested too many verification emails today")
+		assert.Equal(t, len(sender.recipients), VerificationDailyLimit)
+
+		verifications.Age(24 * time.Hour)
+		ts.postForm(t, "/account/verify/resend", form)
+
+		_, _, body = ts.get(t, "/account/view")
+		assert.StringContains(t, body, "Verification email sent to carol@example.com!")
+		assert.Equal(t, len(sender.recipients), VerificationDailyLimit+1)
+	})
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantLocation string
+	}{
+		{
+			name:         "Valid token",
+			urlPath:      "/user/verify/valid-token",
+			wantLocation: "/account/view",
+		},
+		{
+			name:         "Invalid token",
+			urlPath:      "/user/verify/invalid-token",
+			wantLocation: "/user/login",
+		},
+		{
+			// Another user took the pending email address before it was verified.
+			name:         "Email address taken",
+			urlPath:      "/user/verify/taken-token",
+			wantLocation: "/user/login",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestUserLoginTwoFactor(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{
+			name:     "Authenticator code",
+			code:     "123456",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Recovery code",
+			code:     "abcde-fghij",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Wrong code",
+			code:     "654321",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Empty code",
+			code:     "",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// Dave uses two-factor authentication, so the password alone does not log them in.
+			_, _, body := ts.get(t, "/user/login")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", "dave@example.com")
+			form.Add("password", "pa$$word")
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/user/login/2fa")
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ = ts.postForm(t, "/user/login/2fa", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			wantAccountCode := http.StatusSeeOther
+			if tt.wantCode == http.StatusSeeOther {
+				wantAccountCode = http.StatusOK
+			}
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, wantAccountCode)
+		})
+	}
+}
+
+func TestAccountTwoFactorEnablePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/view")
+	assert.StringContains(t, body, `<img class="qr" src="/account/2fa/qr"`)
+
+	matches := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no two-factor secret found in body")
+	}
+	secret := matches[1]
+
+	code, headers, _ := ts.get(t, "/account/2fa/qr")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+
+	validCode, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Wrong code",
+			code:     "000000",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Code is incorrect",
+		},
+		{
+			name:     "Valid code",
+			code:     validCode,
+			wantCode: http.StatusOK,
+			wantBody: "<li><code>abcde-fghij</code></li>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestAccountTwoFactorDisablePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "dave@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("code", "123456")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	form = url.Values{}
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, headers, _ := ts.postForm(t, "/account/2fa/disable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	events, err := app.auditEvents.Search("dave@example.com", models.AuditTwoFactorDisable, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 1)
+}
+
+func TestAccountTwoFactorDisableThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Dave uses two-factor authentication, so logging in takes a code as well as the password.
+	csrfToken := ts.login(t, "dave@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("code", "123456")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	disable := func(password string) (int, string) {
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/account/2fa/disable", form)
+		return code, body
+	}
+
+	for i := 0; i < models.AccountFreeFailures; i++ {
+		code, body := disable("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Password is incorrect")
+	}
+
+	// The next failure locks the account, and then even the right password is refused.
+	code, body := disable("wrong")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	code, body = disable("pa$$word")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	events, err := app.auditEvents.Search("dave@example.com", models.AuditLoginFailed, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), models.AccountFreeFailures+2)
+	assert.Equal(t, events[0].Detail, "two-factor disable: locked out")
+}
+
+func TestAccountTwoFactorUnavailable(t *testing.T) {
+	app := newTestApplication(t)
+	app.twoFactor.(*mocks.TwoFactorModel).Unavailable = true
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	// Requiring admins to use two-factor authentication doesn't lock them out while nobody can turn it on.
+	form := url.Values{}
+	form.Add("requireAdminTwoFactor", "true")
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/admin/settings", form)
+
+	code, _, body := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Two-factor authentication isn't available on this server yet")
+	if strings.Contains(body, `src="/account/2fa/qr"`) {
+		t.Error("QR code shown while two-factor authentication is unavailable")
+	}
+
+	code, _, _ = ts.get(t, "/account/2fa/qr")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	form = url.Values{}
+	form.Add("code", "123456")
+	form.Add("csrf_token", csrfToken)
+
+	code, headers, _ := ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestRequireAdminTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("requireAdminTwoFactor", "true")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/admin/settings", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	events, err := app.auditEvents.Search("", models.AuditSettingChange, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Detail, "require_admin_2fa: false to true")
+
+	// Alice is already logged in, but has to set it up before doing anything else too.
+	code, headers, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/logout", form)
+
+	// Alice is an admin without two-factor authentication, so they have to set it up after logging in.
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken = extractCSRFToken(t, body)
+
+	form = url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	_, headers, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	code, headers, _ = ts.get(t, "/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	code, _, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestUserLoginThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	login := func(password string) (int, string) {
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/user/login", form)
+		return code, body
+	}
+
+	for i := 0; i < models.AccountFreeFailures; i++ {
+		code, body := login("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Email or password is incorrect")
+	}
+
+	// The next failure locks the account.
+	code, body := login("wrong")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	// While the account is locked, even the right password is refused.
+	code, body = login("pa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.StringContains(t, body, "Too many failed login attempts")
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Alice is logged in on two devices.
+	device := newTestServer(t, app.routes())
+	defer device.Close()
+	device.login(t, "alice@example.com", "pa$$word")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "This device")
+	assert.StringContains(t, body, `action="/account/sessions/revoke/1"`)
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	t.Run("Last seen recorded once a minute", func(t *testing.T) {
+		sessions, err := app.userSessions.List(1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lastSeen := sessions[1].LastSeen
+
+		ts.get(t, "/")
+
+		sessions, err = app.userSessions.List(1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, sessions[1].LastSeen, lastSeen)
+	})
+
+	t.Run("Session of another user", func(t *testing.T) {
+		code, _, _ := ts.postForm(t, "/account/sessions/revoke/99", form)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Revoke other device", func(t *testing.T) {
+		code, headers, _ := ts.postForm(t, "/account/sessions/revoke/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/account/sessions")
+
+		code, _, _ = device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Sign out everywhere", func(t *testing.T) {
+		device.login(t, "alice@example.com", "pa$$word")
+
+		code, headers, _ := ts.postForm(t, "/account/sessions/revoke-all", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		code, _, _ = device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestPasswordChangeSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("Password update", func(t *testing.T) {
+		device := newTestServer(t, app.routes())
+		defer device.Close()
+		device.login(t, "alice@example.com", "pa$$word")
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+		oldToken := ts.cookie(t, app.sessionManager.Cookie.Name)
+
+		form := url.Values{}
+		form.Add("currentPassword", "pa$$word")
+		form.Add("newPassword", "new-pa$$word")
+		form.Add("newPasswordConfirmation", "new-pa$$word")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/account/password/update", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// The current session stays logged in with a new token, while the other device is logged out.
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+
+		if ts.cookie(t, app.sessionManager.Cookie.Name) == oldToken {
+			t.Error("session token was not renewed")
+		}
+
+		code, headers, _ := device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	t.Run("Admin-forced reset", func(t *testing.T) {
+		sender := &recordingSender{}
+		app.mailer = sender
+
+		device := newTestServer(t, app.routes())
+		defer device.Close()
+		device.login(t, "bob@example.com", "pa$$word")
+
+		code, _, _ := device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ = ts.postForm(t, "/admin/users/reset/2", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		assert.Equal(t, len(sender.recipients), 1)
+		assert.Equal(t, sender.recipients[0], "bob@example.com")
+		assert.StringContains(t, sender.bodies[0], "/user/password/reset/other-token")
+
+		code, headers, _ := device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		// The old password no longer works.
+		device.login(t, "bob@example.com", "pa$$word")
+
+		code, headers, _ = device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	t.Run("Admin-forced reset with the mail server down", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.mailer = failingSender{}
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		// The reset has happened, so the admin is told that the link wasn't sent instead of getting an error.
+		code, headers, _ := ts.postForm(t, "/admin/users/reset/2", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/admin/users")
+
+		_, _, body := ts.get(t, "/admin/users")
+		assert.StringContains(t, body, "we couldn&#39;t email them a link")
+
+		events, err := app.auditEvents.Search("bob@example.com", models.AuditPasswordReset, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(events), 1)
+	})
+
+	t.Run("Audit log unavailable", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		device := newTestServer(t, app.routes())
+		defer device.Close()
+		device.login(t, "alice@example.com", "pa$$word")
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+		app.auditEvents.(*mocks.AuditEventModel).Fail = true
+
+		form := url.Values{}
+		form.Add("currentPassword", "pa$$word")
+		form.Add("newPassword", "new-pa$$word")
+		form.Add("newPasswordConfirmation", "new-pa$$word")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/account/password/update", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// The other device is logged out even though the password change couldn't be recorded.
+		code, headers, _ := device.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+}
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+
+	// expireSession deletes the session of a test server client from the store, as if it had expired.
+	expireSession := func(ts *testServer) {
+		err := app.sessionManager.Store.Delete(ts.cookie(t, app.sessionManager.Cookie.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// setRememberToken gives a test server client a remember token cookie.
+	setRememberToken := func(ts *testServer, token string) {
+		u, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: RememberCookieName, Value: token}})
+	}
+
+	loginRemembered := func(ts *testServer, remember bool) {
+		_, _, body := ts.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("remember", strconv.FormatBool(remember))
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+
+	t.Run("Not remembered", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		loginRemembered(ts, false)
+		assert.Equal(t, ts.cookie(t, RememberCookieName), "")
+
+		expireSession(ts)
+
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Remembered", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		loginRemembered(ts, true)
+		firstToken := ts.cookie(t, RememberCookieName)
+
+		expireSession(ts)
+
+		// The session is brought back from the remember token, which is swapped for a new one.
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+
+		if ts.cookie(t, RememberCookieName) == firstToken {
+			t.Error("remember token was not rotated")
+		}
+
+		// Someone replays the first token after the grace period, when it has long been used.
+		app.rememberTokens.(*mocks.RememberTokenModel).Age(models.RememberGracePeriod)
+
+		thief := newTestServer(t, app.routes())
+		defer thief.Close()
+		setRememberToken(thief, firstToken)
+
+		code, _, _ = thief.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// Both the thief and the user are logged out, and the user's remember token no longer works.
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Parallel requests", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		loginRemembered(ts, true)
+		firstToken := ts.cookie(t, RememberCookieName)
+
+		expireSession(ts)
+
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		secondToken := ts.cookie(t, RememberCookieName)
+
+		// Another request the browser sent at the same time still carries the first token, and is let in without
+		// being given another one.
+		parallel := newTestServer(t, app.routes())
+		defer parallel.Close()
+		setRememberToken(parallel, firstToken)
+
+		code, _, _ = parallel.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, parallel.cookie(t, RememberCookieName), firstToken)
+
+		// Once the second token was used, the first one counts as replayed, even within the grace period.
+		expireSession(ts)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		if ts.cookie(t, RememberCookieName) == secondToken {
+			t.Error("remember token was not rotated")
+		}
+
+		thief := newTestServer(t, app.routes())
+		defer thief.Close()
+		setRememberToken(thief, firstToken)
+
+		code, _, _ = thief.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Logout forgets", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		loginRemembered(ts, true)
+
+		_, _, body := ts.get(t, "/")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		ts.postForm(t, "/user/logout", form)
+
+		assert.Equal(t, ts.cookie(t, RememberCookieName), "")
+
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestUserLoginOIDC(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         oidctest.User
+		wantLocation string
+		wantAccount  int
+		wantAdmin    int
+	}{
+		{
+			name: "New admin",
+			user: oidctest.User{Subject: "erin", Name: "Erin", Email: "erin@example.com", EmailVerified: true,
+				Groups: []string{"staff", "snippetbox-admins"}},
+			wantLocation: "/snippet/create",
+			wantAccount:  http.StatusOK,
+			wantAdmin:    http.StatusOK,
+		},
+		{
+			name: "New viewer",
+			user: oidctest.User{Subject: "frank", Name: "Frank", Email: "frank@example.com", EmailVerified: true,
+				Groups: []string{"staff"}},
+			wantLocation: "/",
+			wantAccount:  http.StatusOK,
+			wantAdmin:    http.StatusForbidden,
+		},
+		{
+			name: "Existing user promoted",
+			user: oidctest.User{Subject: "bob", Name: "Bob", Email: "bob@example.com", EmailVerified: true,
+				Groups: []string{"snippetbox-admins"}},
+			wantLocation: "/snippet/create",
+			wantAccount:  http.StatusOK,
+			wantAdmin:    http.StatusOK,
+		},
+		{
+			// Alice is the only admin, and the identity provider doesn't make them one.
+			name: "Only admin not demoted",
+			user: oidctest.User{Subject: "alice", Name: "Alice", Email: "alice@example.com", EmailVerified: true,
+				Groups: []string{"staff"}},
+			wantLocation: "/user/login",
+			wantAccount:  http.StatusSeeOther,
+			wantAdmin:    http.StatusSeeOther,
+		},
+		{
+			// Dave uses two-factor authentication, which single sign-on doesn't stand in for.
+			name: "Two-factor authentication",
+			user: oidctest.User{Subject: "dave", Name: "Dave", Email: "dave@example.com", EmailVerified: true,
+				Groups: []string{"staff"}},
+			wantLocation: "/user/login/2fa",
+			wantAccount:  http.StatusSeeOther,
+			wantAdmin:    http.StatusSeeOther,
+		},
+		{
+			// Carol hasn't verified their email address, so anyone could have signed up with it.
+			name: "Existing user unverified",
+			user: oidctest.User{Subject: "carol", Name: "Carol", Email: "carol@example.com", EmailVerified: true,
+				Groups: []string{"staff"}},
+			wantLocation: "/user/login",
+			wantAccount:  http.StatusSeeOther,
+			wantAdmin:    http.StatusSeeOther,
+		},
+		{
+			name:         "Unverified email",
+			user:         oidctest.User{Subject: "grace", Name: "Grace", Email: "grace@example.com"},
+			wantLocation: "/user/login",
+			wantAccount:  http.StatusSeeOther,
+			wantAdmin:    http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			idp, idpServer, err := oidctest.NewServer("snippetbox", "secret", tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idpServer.Close()
+
+			app.oidc, err = oidc.New(context.Background(), oidc.Config{
+				Issuer:       idp.Issuer,
+				ClientID:     "snippetbox",
+				ClientSecret: "secret",
+				RedirectURL:  ts.URL + "/user/login/oidc/callback",
+				RoleClaim:    "groups",
+				Roles:        []auth.RoleMapping{{Value: "snippetbox-admins", Role: models.RoleAdmin}},
+				DefaultRole:  models.RoleViewer,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, `<a href="/user/login/oidc">`)
+
+			// The identity provider logs the user in straight away and sends them back with a code.
+			code, headers, _ := ts.get(t, "/user/login/oidc")
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			rs, err := ts.Client().Get(headers.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			callback, err := url.Parse(rs.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, headers, _ = ts.get(t, callback.RequestURI())
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, tt.wantAccount)
+
+			code, _, _ = ts.get(t, "/admin/users")
+			assert.Equal(t, code, tt.wantAdmin)
+
+			// The code and state can't be used again.
+			code, _, _ = ts.get(t, callback.RequestURI())
+			assert.Equal(t, code, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("Not configured", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, _, _ := ts.get(t, "/user/login/oidc")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestUserLoginLDAP(t *testing.T) {
+	ldapURL := newTestLDAPServer(t, []ldapEntry{
+		{
+			dn:       "cn=service,dc=example,dc=org",
+			password: "service-secret",
+		},
+		{
+			dn:       "uid=erin,ou=people,dc=example,dc=org",
+			password: "erin-secret",
+			attributes: map[string][]string{
+				"cn":   {"Erin"},
+				"mail": {"erin@example.com"},
+				"memberOf": {
+					"cn=staff,ou=groups,dc=example,dc=org",
+					"cn=snippetbox-admins,ou=groups,dc=example,dc=org",
+				},
+			},
+		},
+		{
+			dn:       "uid=frank,ou=people,dc=example,dc=org",
+			password: "frank-secret",
+			attributes: map[string][]string{
+				"cn":       {"Frank"},
+				"mail":     {"frank@example.com"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=org"},
+			},
+		},
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			password: "alice-secret",
+			attributes: map[string][]string{
+				"cn":       {"Alice"},
+				"mail":     {"alice@example.com"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=org"},
+			},
+		},
+	})
+
+	newLDAPApplication := func(t *testing.T) *application {
+		app := newTestApplication(t)
+		app.authenticator = &auth.LDAP{
+			URL:            ldapURL,
+			BindDN:         "cn=service,dc=example,dc=org",
+			BindPassword:   "service-secret",
+			BaseDN:         "dc=example,dc=org",
+			UserFilter:     "(mail=%s)",
+			NameAttribute:  "cn",
+			EmailAttribute: "mail",
+			GroupAttribute: "memberOf",
+			Roles:          []auth.RoleMapping{{Value: "snippetbox-admins", Role: models.RoleAdmin}},
+			DefaultRole:    models.RoleViewer,
+			Timeout:        5 * time.Second,
+			Users:          app.users,
+		}
+		return app
+	}
+
+	tests := []struct {
+		name         string
+		email        string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantAdmin    int
+	}{
+		{
+			name:         "New admin",
+			email:        "erin@example.com",
+			password:     "erin-secret",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+			wantAdmin:    http.StatusOK,
+		},
+		{
+			name:         "New viewer",
+			email:        "frank@example.com",
+			password:     "frank-secret",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+			wantAdmin:    http.StatusForbidden,
+		},
+		{
+			name:      "Wrong password",
+			email:     "erin@example.com",
+			password:  "pa$$word",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantAdmin: http.StatusSeeOther,
+		},
+		{
+			name:      "Empty password",
+			email:     "erin@example.com",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantAdmin: http.StatusSeeOther,
+		},
+		{
+			// Local accounts can't log in once the directory checks passwords.
+			name:      "Not in directory",
+			email:     "bob@example.com",
+			password:  "pa$$word",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantAdmin: http.StatusSeeOther,
+		},
+		{
+			// Alice is the only admin, and the directory doesn't put them in the admin group.
+			name:      "Only admin not demoted",
+			email:     "alice@example.com",
+			password:  "alice-secret",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantAdmin: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newLDAPApplication(t)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, headers, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			code, _, _ = ts.get(t, "/admin/users")
+			assert.Equal(t, code, tt.wantAdmin)
+		})
+	}
+
+	t.Run("No local passwords", func(t *testing.T) {
+		app := newLDAPApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+		assert.Equal(t, strings.Contains(body, "/user/password/forgot"), false)
+
+		code, _, _ := ts.get(t, "/user/password/forgot")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, _, _ = ts.get(t, "/user/password/reset/valid-token")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		form := url.Values{}
+		form.Add("email", "erin@example.com")
+		form.Add("password", "erin-secret")
+		form.Add("csrf_token", csrfToken)
+		ts.postForm(t, "/user/login", form)
+
+		code, _, body = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, "/account/password/update"), false)
+
+		code, _, _ = ts.get(t, "/account/password/update")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, _, body = ts.get(t, "/admin/users")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, "/admin/users/reset/"), false)
+
+		form = url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ = ts.postForm(t, "/admin/users/reset/2", form)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, headers, body := ts.get(t, "/account/export")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "application/json")
+	assert.StringContains(t, headers.Get("Content-Disposition"), "attachment")
+
+	var export accountExport
+	if err := json.Unmarshal([]byte(body), &export); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, export.Profile.Email, "alice@example.com")
+	assert.Equal(t, export.Profile.Bio, "Writes haiku about ponds.")
+	assert.Equal(t, export.Profile.Avatar.ContentType, "image/png")
+	assert.Equal(t, len(export.Profile.Avatar.Data) > 0, true)
+	assert.Equal(t, len(export.Snippets), 1)
+	assert.Equal(t, export.Snippets[0].Title, "An old silent pond")
+	assert.Equal(t, len(export.Reviews), 1)
+	assert.Equal(t, export.Reviews[0].Detail, models.VerdictApproved)
+	assert.Equal(t, export.Reviews[0].Created.IsZero(), false)
+	assert.Equal(t, len(export.Edits), 1)
+	assert.Equal(t, len(export.Comments), 2)
+	assert.Equal(t, export.Comments[0].Body, "A **frog** jumps into the pond")
+	assert.Equal(t, len(export.Stars), 1)
+	assert.Equal(t, export.Stars[0].SnippetTitle, "An old silent pond")
+	assert.Equal(t, len(export.ReviewRequestsMade), 1)
+	assert.Equal(t, len(export.ReviewRequestsReceived), 1)
+
+	// The view counters are not the user's data and stay out of the export.
+	assert.Equal(t, strings.Contains(body, `"reviews": 1`), false)
+}
+
+func TestAccountDeletePost(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		password     string
+		snippets     string
+		reassignTo   string
+		wantCode     int
+		wantLoggedIn bool
+	}{
+		{
+			name:         "Wrong password",
+			email:        "bob@example.com",
+			password:     "wrong",
+			snippets:     SnippetsDelete,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantLoggedIn: true,
+		},
+		{
+			name:         "Missing reassign email",
+			email:        "bob@example.com",
+			password:     "pa$$word",
+			snippets:     SnippetsReassign,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantLoggedIn: true,
+		},
+		{
+			name:         "Reassign to unknown user",
+			email:        "bob@example.com",
+			password:     "pa$$word",
+			snippets:     SnippetsReassign,
+			reassignTo:   "nobody@example.com",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantLoggedIn: true,
+		},
+		{
+			name:         "Reassign to self",
+			email:        "bob@example.com",
+			password:     "pa$$word",
+			snippets:     SnippetsReassign,
+			reassignTo:   "bob@example.com",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantLoggedIn: true,
+		},
+		{
+			name:         "Last admin",
+			email:        "alice@example.com",
+			password:     "pa$$word",
+			snippets:     SnippetsDelete,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantLoggedIn: true,
+		},
+		{
+			name:     "Delete snippets",
+			email:    "bob@example.com",
+			password: "pa$$word",
+			snippets: SnippetsDelete,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:       "Reassign snippets",
+			email:      "bob@example.com",
+			password:   "pa$$word",
+			snippets:   SnippetsReassign,
+			reassignTo: "alice@example.com",
+			wantCode:   http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.email, "pa$$word")
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("snippets", tt.snippets)
+			form.Add("reassignTo", tt.reassignTo)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/account/delete", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			// A deleted user's session ends.
+			code, _, _ = ts.get(t, "/account/view")
+			if tt.wantLoggedIn {
+				assert.Equal(t, code, http.StatusOK)
+			} else {
+				assert.Equal(t, code, http.StatusSeeOther)
+			}
+
+			events, err := app.auditEvents.Search("", models.AuditUserDelete, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantLoggedIn {
+				assert.Equal(t, len(events), 0)
+			} else {
+				assert.Equal(t, len(events), 1)
+				assert.Equal(t, events[0].Detail, "deleted by the user")
+			}
+		})
+	}
+}
+
+func TestAccountDeleteThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "bob@example.com", "pa$$word")
+
+	deleteAccount := func(password string) (int, string) {
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("snippets", SnippetsDelete)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/account/delete", form)
+		return code, body
+	}
+
+	for i := 0; i < models.AccountFreeFailures; i++ {
+		code, body := deleteAccount("wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Password is incorrect")
+	}
+
+	// The next failure locks the account, and then even the right password is refused.
+	code, body := deleteAccount("wrong")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	code, body = deleteAccount("pa$$word")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	events, err := app.auditEvents.Search("bob@example.com", models.AuditLoginFailed, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), models.AccountFreeFailures+2)
+	assert.Equal(t, events[0].Detail, "account deletion: locked out")
+}
+
+func TestAccountProfilePost(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name      string
+		userName  string
+		email     string
+		bio       string
+		avatar    []byte
+		wantCode  int
+		wantEmail bool
+	}{
+		{
+			name:     "Valid submission",
+			userName: "Alice",
+			email:    "alice@example.com",
+			bio:      "Writes haiku about frogs.",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:      "New email address",
+			userName:  "Alice",
+			email:     "alice@example.org",
+			wantCode:  http.StatusSeeOther,
+			wantEmail: true,
+		},
+		{
+			name:     "Duplicate email",
+			userName: "Alice",
+			email:    "dupe@example.com",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Empty name",
+			email:    "alice@example.com",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Bio too long",
+			userName: "Alice",
+			email:    "alice@example.com",
+			bio:      strings.Repeat("a", BioMaxChars+1),
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Avatar",
+			userName: "Alice",
+			email:    "alice@example.com",
+			avatar:   png,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Avatar not an image",
+			userName: "Alice",
+			email:    "alice@example.com",
+			avatar:   []byte("<script>alert(1)</script>"),
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Avatar too large",
+			userName: "Alice",
+			email:    "alice@example.com",
+			avatar:   append(png, make([]byte, AvatarMaxBytes)...),
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			// The body is cut off before the CSRF check reads all of it.
+			name:     "Form too large",
+			userName: "Alice",
+			email:    "alice@example.com",
+			avatar:   append(png, make([]byte, ProfileMaxBytes)...),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			sender := &recordingSender{}
+			app.mailer = sender
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.email)
+			form.Add("bio", tt.bio)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postMultipart(t, "/account/profile", form, "avatar", tt.avatar)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantEmail {
+				assert.Equal(t, len(sender.recipients), 1)
+				assert.Equal(t, sender.recipients[0], tt.email)
+				assert.StringContains(t, sender.bodies[0], "/user/verify/pending-token")
+			} else {
+				assert.Equal(t, len(sender.recipients), 0)
+			}
+		})
+	}
+}
+
+func TestUserView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "User with snippets and reviews",
+			urlPath:  "/user/view/1",
+			wantCode: http.StatusOK,
+			wantBody: []string{"Alice", "Writes haiku about ponds.", "An old silent pond", "Approved",
+				`<img class="avatar" src="/user/avatar/1"`},
+		},
+		{
+			name:     "User without snippets",
+			urlPath:  "/user/view/2",
+			wantCode: http.StatusOK,
+			wantBody: []string{"Bob", "No snippets yet.", "No reviews yet."},
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/user/view/99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/user/view/alice",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+
+	t.Run("Avatar", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/user/avatar/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("Content-Type"), "image/png")
+
+		code, _, _ = ts.get(t, "/user/avatar/2")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestAccountTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		tokName  string
+		scopes   []string
+		expires  string
+		wantCode int
+	}{
+		{
+			name:     "Valid submission",
+			tokName:  "CI",
+			scopes:   []string{"read", "write"},
+			expires:  "30",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Never expires",
+			tokName:  "Backup",
+			scopes:   []string{"read"},
+			expires:  "0",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Empty name",
+			scopes:   []string{"read"},
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "No scopes",
+			tokName:  "CI",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Invalid scope",
+			tokName:  "CI",
+			scopes:   []string{"admin"},
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Invalid expiry",
+			tokName:  "CI",
+			scopes:   []string{"read"},
+			expires:  "3",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokName)
+			for _, scope := range tt.scopes {
+				form.Add("scopes", scope)
+			}
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/account/tokens", form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	t.Run("Token shown once", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("name", "Deploy")
+		form.Add("scopes", "read")
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+
+		ts.postForm(t, "/account/tokens", form)
+
+		_, _, body := ts.get(t, "/account/tokens")
+		assert.StringContains(t, body, "Your new token is <code>sbx_token-3</code>")
+
+		_, _, body = ts.get(t, "/account/tokens")
+		if strings.Contains(body, "Your new token is") {
+			t.Errorf("token shown again")
+		}
+		assert.StringContains(t, body, "Deploy")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/account/tokens/revoke/3", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.requestWithToken(t, http.MethodGet, "/", "sbx_token-3", nil)
+		assert.Equal(t, code, http.StatusUnauthorized)
+
+		code, _, _ = ts.postForm(t, "/account/tokens/revoke/3", form)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestTeams(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Alice is an admin who maintains Platform, which Bob, a reviewer, is a member of.
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("List", func(t *testing.T) {
+		code, _, body := ts.get(t, "/teams")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, `<a href="/team/view/1">Platform</a>`)
+		assert.StringContains(t, body, "Maintainer")
+		assert.StringContains(t, body, "Create team")
+	})
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := ts.get(t, "/team/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "Deploy checklist")
+		assert.StringContains(t, body, `<a href="/user/view/2">Bob</a>`)
+		assert.StringContains(t, body, "Save member")
+
+		code, _, _ = ts.get(t, "/team/view/9")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	createTests := []struct {
+		name      string
+		teamName  string
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "Create",
+			teamName: "Data",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:      "Duplicate name",
+			teamName:  "Platform",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "A team with this name already exists",
+		},
+		{
+			name:      "Empty name",
+			teamName:  " ",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range createTests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.teamName)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/teams", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantError != "" {
+				assert.StringContains(t, body, tt.wantError)
+			}
+		})
+	}
+
+	memberTests := []struct {
+		name      string
+		email     string
+		role      string
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "Add member",
+			email:    "carol@example.com",
+			role:     "member",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:      "Unknown user",
+			email:     "nobody@example.com",
+			role:      "member",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "No user has this email address",
+		},
+		{
+			name:      "Invalid role",
+			email:     "carol@example.com",
+			role:      "owner",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "This field must be a valid team role",
+		},
+		{
+			name:      "Last maintainer",
+			email:     "alice@example.com",
+			role:      "member",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantError: "A team needs at least one maintainer",
+		},
+	}
+
+	for _, tt := range memberTests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("role", tt.role)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/team/member/add/1", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantError != "" {
+				assert.StringContains(t, body, tt.wantError)
+			}
+		})
+	}
+
+	t.Run("Remove member", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("userID", "2")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/team/member/remove/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		form.Set("userID", "4")
+		code, _, _ = ts.postForm(t, "/team/member/remove/1", form)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Create team snippet", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("title", "Rollback")
+		form.Add("content", "Revert the tag.")
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/team/snippet/create/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// Alice can create snippets, but only members can create them for a team.
+		code, _, _ = ts.get(t, "/team/snippet/create/2")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+}
+
+func TestTeamSnippetPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		wantEdit   int
+		wantDelete int
+	}{
+		{
+			// Dave is made an editor, who can edit and delete snippets, but isn't in Platform, which owns snippet 3.
+			name:       "Editor outside the team",
+			email:      "dave@example.com",
+			wantEdit:   http.StatusForbidden,
+			wantDelete: http.StatusForbidden,
+		},
+		{
+			name:       "Team member",
+			email:      "bob@example.com",
+			wantEdit:   http.StatusOK,
+			wantDelete: http.StatusForbidden,
+		},
+		{
+			name:       "Admin",
+			email:      "alice@example.com",
+			wantEdit:   http.StatusOK,
+			wantDelete: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			err := app.users.SetRole(4, models.RoleEditor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			csrfToken := ts.login(t, tt.email, "pa$$word")
+
+			// Dave uses two-factor authentication.
+			if tt.email == "dave@example.com" {
+				form := url.Values{}
+				form.Add("code", "123456")
+				form.Add("csrf_token", csrfToken)
+				ts.postForm(t, "/user/login/2fa", form)
+			}
+
+			code, _, _ := ts.get(t, "/snippet/edit/3")
+			assert.Equal(t, code, tt.wantEdit)
+
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ = ts.postForm(t, "/snippet/delete/3", form)
+			assert.Equal(t, code, tt.wantDelete)
+		})
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "wrong password")
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("role", models.RoleEditor)
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/admin/users/role/2", form)
+
+	t.Run("Viewer", func(t *testing.T) {
+		code, _, body := ts.get(t, "/admin/audit")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "incorrect email or password")
+		assert.StringContains(t, body, "<td>password</td>")
+		assert.StringContains(t, body, "reviewer to editor")
+		assert.StringContains(t, body, "bob@example.com")
+	})
+
+	t.Run("Filter by action", func(t *testing.T) {
+		_, _, body := ts.get(t, "/admin/audit?action=role.change")
+
+		assert.StringContains(t, body, "reviewer to editor")
+		if strings.Contains(body, "incorrect email or password") {
+			t.Errorf("failed login shown when filtering role changes")
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		_, _, body := ts.get(t, "/admin/audit?q=bob")
+
+		assert.StringContains(t, body, "reviewer to editor")
+		if strings.Contains(body, "incorrect email or password") {
+			t.Errorf("failed login for alice shown when searching for bob")
+		}
+	})
+
+	t.Run("CSV export", func(t *testing.T) {
+		code, header, body := ts.get(t, "/admin/audit/export?action=login.failed")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/csv; charset=utf-8")
+		assert.StringContains(t, header.Get("Content-Disposition"), "attachment")
+
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		assert.Equal(t, len(lines), 2)
+		assert.StringContains(t, lines[0], "time,action,actor_id,actor_email,acting_admin_id,acting_admin_email")
+		assert.StringContains(t, lines[1], "login.failed,0,,0,,0,alice@example.com,incorrect email or password")
+	})
+
+	t.Run("CSV export escapes formulas", func(t *testing.T) {
+		other := newTestServer(t, app.routes())
+		defer other.Close()
+		other.login(t, "=cmd@example.com", "wrong password")
+
+		_, _, body := ts.get(t, "/admin/audit/export?q=cmd")
+
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		assert.Equal(t, len(lines), 2)
+		assert.StringContains(t, lines[1], ",'=cmd@example.com,")
+	})
+
+	t.Run("Forbidden for non-admins", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.login(t, "bob@example.com", "pa$$word")
+
+		code, _, _ := ts.get(t, "/admin/audit")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, _ = ts.get(t, "/admin/audit/export")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+}
+
+func TestAdminUserImpersonate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	t.Run("Own account", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/admin/users/impersonate/1", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/admin/users")
+
+		_, _, body := ts.get(t, "/")
+		if strings.Contains(body, "You're viewing Snippetbox as") {
+			t.Errorf("admin impersonating themselves")
+		}
+	})
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/admin/users/impersonate/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+
+	t.Run("Banner", func(t *testing.T) {
+		_, _, body := ts.get(t, "/")
+
+		assert.StringContains(t, body, "You're viewing Snippetbox as Bob")
+		assert.StringContains(t, body, `action="/user/impersonate/stop"`)
+		if strings.Contains(body, `href="/admin/users"`) {
+			t.Errorf("admin links shown while viewing as a reviewer")
+		}
+	})
+
+	t.Run("Restricted", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+
+		for _, path := range []string{"/account/password/update", "/account/tokens", "/account/export", "/admin/users"} {
+			code, header, _ := ts.get(t, path)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/")
+		}
+
+		form := url.Values{}
+		form.Add("currentPassword", "pa$$word")
+		form.Add("newPassword", "correct horse battery staple")
+		form.Add("newPasswordConfirmation", "correct horse battery staple")
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/account/password/update", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+
+		_, _, body := ts.get(t, "/")
+		assert.StringContains(t, body, "You can&#39;t do that while viewing as another user")
+	})
+
+	t.Run("Snippet events", func(t *testing.T) {
+		snippets := app.snippets.(*mocks.SnippetModel)
+		reviews := app.reviews.(*mocks.ReviewModel)
+
+		form := url.Values{}
+		form.Add("title", "O snail")
+		form.Add("content", "Climb Mount Fuji")
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/snippet/edit/3", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, snippets.ActingAdminID, 1)
+
+		form = url.Values{}
+		form.Add("verdict", models.VerdictApproved)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ = ts.postForm(t, "/snippet/view/3", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, reviews.ActingAdminID, 1)
+	})
+
+	t.Run("Unattributed changes", func(t *testing.T) {
+		// None of these record who acted besides the user, so they are refused rather than put down to Bob.
+		paths := []string{
+			"/snippet/create",
+			"/team/snippet/create/1",
+			"/snippet/delete/3",
+			"/snippet/comment/1",
+			"/comment/edit/2",
+			"/comment/delete/2",
+			"/snippet/star/1",
+			"/snippet/request/1",
+			"/teams",
+			"/team/member/add/1",
+			"/team/member/remove/1",
+		}
+
+		for _, path := range paths {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, path, form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/")
+
+			_, _, body := ts.get(t, "/")
+			assert.StringContains(t, body, "You can&#39;t do that while viewing as another user")
+		}
+
+		_, err := app.comments.Get(2)
+		if err != nil {
+			t.Errorf("comment deleted while viewing as another user: %v", err)
+		}
+	})
+
+	t.Run("Audit events", func(t *testing.T) {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Any event recorded while Alice views the site as Bob names her too.
+		ctx, err := app.sessionManager.Load(r.Context(), ts.cookie(t, app.sessionManager.Cookie.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = r.WithContext(ctx)
+
+		err = app.audit(r, models.AuditEvent{ActorID: 2, Action: models.AuditPasswordChange})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events, err := app.auditEvents.Search("", models.AuditPasswordChange, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(events), 1)
+		assert.Equal(t, events[0].ActorID, 2)
+		assert.Equal(t, events[0].ActingAdminID, 1)
+		assert.Equal(t, events[0].ActingAdminEmail, "alice@example.com")
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/user/impersonate/stop", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/admin/users")
+
+		code, _, body := ts.get(t, "/admin/users")
+		assert.Equal(t, code, http.StatusOK)
+		assert.StringContains(t, body, "You&#39;ve stopped viewing as Bob")
+		if strings.Contains(body, `action="/user/impersonate/stop"`) {
+			t.Errorf("banner shown after stopping")
+		}
+	})
+
+	t.Run("Session index", func(t *testing.T) {
+		// Starting and stopping renewed the token, which replaced the session in the index rather than adding one.
+		sessions, err := app.userSessions.List(1, ts.cookie(t, app.sessionManager.Cookie.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, len(sessions), 1)
+		assert.Equal(t, sessions[0].Current, true)
+	})
+
+	t.Run("Audited", func(t *testing.T) {
+		for _, action := range []string{models.AuditImpersonationStart, models.AuditImpersonationStop} {
+			events, err := app.auditEvents.Search("", action, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, len(events), 1)
+			assert.Equal(t, events[0].ActorID, 1)
+			assert.Equal(t, events[0].ActingAdminID, 0)
+			assert.Equal(t, events[0].TargetID, 2)
+		}
+	})
+
+	t.Run("Forbidden for non-admins", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		csrfToken := ts.login(t, "bob@example.com", "pa$$word")
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/admin/users/impersonate/3", form)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+}
+
+func TestImpersonationEnds(t *testing.T) {
+	// impersonate logs Alice in and starts viewing the site as Bob.
+	impersonate := func(t *testing.T, app *application) (*testServer, string) {
+		ts := newTestServer(t, app.routes())
+
+		csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/admin/users/impersonate/2", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		return ts, csrfToken
+	}
+
+	t.Run("Target disabled", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts, _ := impersonate(t, app)
+		defer ts.Close()
+
+		err := app.users.SetDisabled(2, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Bob's session has ended, and Alice logs in again in the same browser.
+		code, _, _ := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		code, _, body := ts.get(t, "/admin/users")
+		assert.Equal(t, code, http.StatusOK)
+		if strings.Contains(body, "You're viewing Snippetbox as") {
+			t.Errorf("still viewing as another user after logging in again")
+		}
+	})
+
+	t.Run("Admin demoted", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts, csrfToken := impersonate(t, app)
+		defer ts.Close()
+
+		err := app.users.SetRole(1, models.RoleReviewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/user/impersonate/stop", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Admin disabled", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts, csrfToken := impersonate(t, app)
+		defer ts.Close()
+
+		err := app.users.SetDisabled(1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+
+		code, header, _ := ts.postForm(t, "/user/impersonate/stop", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
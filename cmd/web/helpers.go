@@ -2,17 +2,37 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
+	"github.com/mabego/snippetbox-mysql/internal/auth"
+	"github.com/mabego/snippetbox-mysql/internal/models"
+	"github.com/mabego/snippetbox-mysql/internal/validator"
 )
 
-var ErrNoTmpl = errors.New("template does not exist")
+var (
+	ErrNoTmpl         = errors.New("template does not exist")
+	errAvatarTooLarge = errors.New("avatar too large")
+	errAvatarType     = errors.New("avatar not an image")
+)
+
+// avatarTypes are the image types an avatar can have. They are checked against the content of the file rather than
+// the type the browser sent.
+var avatarTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
 
 // serverError helper writes an error message and a stack trace to the errorLog,
 // then sends a generic 500 Internal Server Error response to the user.
@@ -68,14 +88,28 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
 	return &templateData{
-		IsAuthenticated: app.isAuthenticated(r),
-		IsAuthorized:    app.isAuthorized(r),
-		CurrentYear:     time.Now().Year(),
-		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
-		CSRFToken:       nosurf.Token(r),
+		IsAuthenticated:    app.isAuthenticated(r),
+		Permissions:        app.permissions(r),
+		IsUnverified:       app.isUnverified(r),
+		SSOEnabled:         app.oidc != nil,
+		LocalPasswords:     app.localPasswords(),
+		TwoFactorAvailable: app.twoFactor.Available(),
+		Roles:              models.Roles,
+		UserID:             app.authenticatedUserID(r),
+		Impersonating:      app.sessionManager.GetString(r.Context(), "impersonatingName"),
+		CurrentYear:        time.Now().Year(),
+		Flash:              app.sessionManager.PopString(r.Context(), "flash"),
+		CSRFToken:          nosurf.Token(r),
 	}
 }
 
+// localPasswords reports whether users log in with passwords kept in the database, rather than ones another
+// backend checks.
+func (app *application) localPasswords() bool {
+	_, ok := app.authenticator.(*auth.Database)
+	return ok
+}
+
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	err := r.ParseForm()
 	if err != nil {
@@ -97,6 +131,47 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// readAvatar returns the avatar image uploaded with a profile form and its content type, or nil if there is none.
+// It returns errAvatarTooLarge or errAvatarType if the file is not a small enough image.
+func readAvatar(r *http.Request) ([]byte, string, error) {
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	defer file.Close()
+
+	avatar, err := io.ReadAll(io.LimitReader(file, AvatarMaxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(avatar) > AvatarMaxBytes {
+		return nil, "", errAvatarTooLarge
+	}
+
+	contentType := http.DetectContentType(avatar)
+	if !slices.Contains(avatarTypes, contentType) {
+		return nil, "", errAvatarType
+	}
+
+	return avatar, contentType, nil
+}
+
+// checkPassword adds a field error for key if a new password breaks the password policy. Personal holds the
+// user's name and email address.
+func (app *application) checkPassword(v *validator.Validator, key, password string, personal ...string) error {
+	problem, err := app.passwordPolicy.Check(password, personal...)
+	if err != nil {
+		return err
+	}
+
+	v.CheckField(problem == "", key, problem)
+	return nil
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
 	if !ok {
@@ -106,11 +181,449 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
-func (app *application) isAuthorized(r *http.Request) bool {
-	isAuthorized, ok := r.Context().Value(isAuthorizedContextKey).(bool)
+// authenticatedUserID returns the ID of the user the authenticate or authenticateToken middleware logged in, or 0
+// for a visitor who is not logged in.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// isUnverified reports whether the current user is logged in but has not verified their email address.
+func (app *application) isUnverified(r *http.Request) bool {
+	isUnverified, ok := r.Context().Value(isUnverifiedContextKey).(bool)
 	if !ok {
 		return false
 	}
 
-	return isAuthorized
+	return isUnverified
+}
+
+// permissions returns the permissions the authorize middleware granted to the current user. It is nil for a
+// visitor who is not logged in.
+func (app *application) permissions(r *http.Request) map[string]bool {
+	permissions, ok := r.Context().Value(permissionsContextKey).(map[string]bool)
+	if !ok {
+		return nil
+	}
+
+	return permissions
+}
+
+// withSnippet loads the snippet in a /snippet/ URL into the request context, where rolePermissions and the handler
+// find it without reading it again. A request for a snippet that doesn't exist is returned as it is.
+func (app *application) withSnippet(r *http.Request) (*http.Request, error) {
+	if !strings.HasPrefix(r.URL.Path, "/snippet/") {
+		return r, nil
+	}
+
+	id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil || id < 1 {
+		return r, nil
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), snippetContextKey, snippet)), nil
+}
+
+// snippet returns the snippet with an ID, reusing the one withSnippet loaded for the request if there is one.
+func (app *application) snippet(r *http.Request, id int) (*models.Snippet, error) {
+	snippet, ok := r.Context().Value(snippetContextKey).(*models.Snippet)
+	if ok && snippet.ID == id {
+		return snippet, nil
+	}
+
+	return app.snippets.Get(id)
+}
+
+// rolePermissions returns the permissions of a user with a role for the current request. A verified user also has
+// the permissions of their role in the team the request is about: the team in a /team/ URL, or the team that owns
+// the snippet withSnippet loaded for a /snippet/ URL. A team's snippets belong to the team, so only admins can edit
+// or delete them by their own role; anyone else needs the team to let them.
+func (app *application) rolePermissions(r *http.Request, userID int, role string, verified bool) (map[string]bool,
+	error) {
+	permissions := models.Permissions(role)
+
+	snippet, isSnippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+	if isSnippet && snippet.TeamID != 0 && role != models.RoleAdmin {
+		delete(permissions, models.PermSnippetEdit)
+		delete(permissions, models.PermSnippetDelete)
+	}
+
+	if !verified {
+		return permissions, nil
+	}
+
+	var teamID int
+
+	if isSnippet {
+		teamID = snippet.TeamID
+	} else if strings.HasPrefix(r.URL.Path, "/team/") {
+		id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+		if err != nil || id < 1 {
+			return permissions, nil
+		}
+		teamID = id
+	}
+
+	if teamID == 0 {
+		return permissions, nil
+	}
+
+	teamRole, err := app.teams.Role(teamID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return permissions, nil
+		}
+		return nil, err
+	}
+
+	maps.Copy(permissions, models.TeamPermissions(teamRole))
+
+	return permissions, nil
+}
+
+// can reports whether the current user has the named permission.
+func (app *application) can(r *http.Request, permission string) bool {
+	return app.permissions(r)[permission]
+}
+
+// startSession logs a user in to the current session under a new token. It records in the session whether the user
+// still has to choose a new password or set up two-factor authentication, and which family of remember tokens, if
+// any, the session belongs to.
+func (app *application) startSession(r *http.Request, user *models.User, rememberFamily string) error {
+	// RenewToken changes the current session ID when the authentication state changes for the user
+	// with the login operation.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+	app.sessionManager.Remove(r.Context(), "twoFactorRemember")
+
+	// A session that was viewing the site as another user when that user was logged out still holds the admin.
+	app.sessionManager.Remove(r.Context(), "impersonatorID")
+	app.sessionManager.Remove(r.Context(), "impersonatingName")
+
+	// Add the ID of the current user to the session, so that they are now logged in.
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
+
+	if rememberFamily != "" {
+		app.sessionManager.Put(r.Context(), "rememberFamily", rememberFamily)
+	}
+
+	return app.trackSession(r, user.ID)
+}
+
+// twoFactorSetupRequired reports whether a user is an admin who has to set up two-factor authentication before
+// they can do anything else. Nobody has to while the server can't turn it on.
+func (app *application) twoFactorSetupRequired(user *models.User) (bool, error) {
+	if user.Role != models.RoleAdmin || user.TwoFactorEnabled || !app.twoFactor.Available() {
+		return false, nil
+	}
+
+	return app.settings.Bool(models.SettingRequireAdminTwoFactor)
+}
+
+// trackSession adds the current session to the index of the sessions each user is logged in to, and records when
+// and where it was last used.
+func (app *application) trackSession(r *http.Request, userID int) error {
+	token := app.sessionManager.Token(r.Context())
+	deadline := app.sessionManager.Deadline(r.Context())
+
+	family := app.sessionManager.GetString(r.Context(), "rememberFamily")
+
+	err := app.userSessions.Touch(token, userID, clientIP(r), r.UserAgent(), family, deadline)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "sessionTouched", time.Now().Unix())
+	return nil
+}
+
+// revokeSession deletes a session from the session store. The current session is saved again at the end of the
+// request, so rather than delete it, log it out and give it a new token.
+func (app *application) revokeSession(ctx context.Context, token string) error {
+	if token != app.sessionManager.Token(ctx) {
+		return app.sessionManager.Store.Delete(token)
+	}
+
+	if err := app.sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(ctx, "authenticatedUserID")
+	app.sessionManager.Remove(ctx, "rememberFamily")
+	app.sessionManager.Remove(ctx, "impersonatorID")
+	app.sessionManager.Remove(ctx, "impersonatingName")
+
+	return nil
+}
+
+// destroyUserSessions deletes every session in which the user userID is logged in, including the current one.
+func (app *application) destroyUserSessions(ctx context.Context, userID int) error {
+	tokens, err := app.userSessions.DeleteAll(userID)
+	if err != nil {
+		return fmt.Errorf("destroy sessions: %w", err)
+	}
+
+	// Remember tokens would otherwise log the user straight back in.
+	err = app.rememberTokens.DeleteAll(userID, "")
+	if err != nil {
+		return fmt.Errorf("destroy sessions: %w", err)
+	}
+
+	for _, token := range tokens {
+		if err := app.revokeSession(ctx, token); err != nil {
+			return fmt.Errorf("destroy sessions: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// destroyOtherSessions deletes every session in which the user userID is logged in except the current one, which
+// keeps the user logged in with a new token.
+func (app *application) destroyOtherSessions(r *http.Request, userID int) error {
+	tokens, err := app.userSessions.DeleteAll(userID)
+	if err != nil {
+		return fmt.Errorf("destroy other sessions: %w", err)
+	}
+
+	err = app.rememberTokens.DeleteAll(userID, app.sessionManager.GetString(r.Context(), "rememberFamily"))
+	if err != nil {
+		return fmt.Errorf("destroy other sessions: %w", err)
+	}
+
+	current := app.sessionManager.Token(r.Context())
+
+	for _, token := range tokens {
+		if token == current {
+			continue
+		}
+
+		if err := app.sessionManager.Store.Delete(token); err != nil {
+			return fmt.Errorf("destroy other sessions: %w", err)
+		}
+	}
+
+	if err := app.sessionManager.RenewToken(r.Context()); err != nil {
+		return fmt.Errorf("destroy other sessions: %w", err)
+	}
+
+	return app.trackSession(r, userID)
+}
+
+// setRememberCookie stores a remember token in the browser for RememberTTL.
+func setRememberCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RememberCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(RememberTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// bearerToken returns the API token in a request's "Authorization: Bearer" header, and whether there is one.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// hasBearerToken reports whether a request is made with an API token rather than a session.
+func hasBearerToken(r *http.Request) bool {
+	_, ok := bearerToken(r)
+	return ok
+}
+
+// tokenScope returns the scope an API token needs for a request, or an empty string if API tokens cannot be used
+// for it. Tokens are for scripting snippets and reviews, so they cannot log in or manage accounts and teams.
+func tokenScope(r *http.Request) string {
+	path := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(path, "/account/"), strings.HasPrefix(path, "/admin/"):
+		return ""
+	case strings.HasPrefix(path, "/user/") && !strings.HasPrefix(path, "/user/view/") &&
+		!strings.HasPrefix(path, "/user/avatar/"):
+		return ""
+	case strings.HasPrefix(path, "/team/member/"), path == "/teams" && r.Method == http.MethodPost:
+		return ""
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return models.ScopeRead
+	case strings.HasPrefix(path, "/snippet/view/"):
+		return models.ScopeReview
+	default:
+		return models.ScopeWrite
+	}
+}
+
+// clientIP returns the IP address a request came from, without the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// impersonatorID returns the ID of the admin who is viewing the site as the logged-in user, or 0 if the user is
+// logged in as themselves.
+func (app *application) impersonatorID(r *http.Request) int {
+	return app.sessionManager.GetInt(r.Context(), "impersonatorID")
+}
+
+// canManageUsers reports whether a user still exists, is enabled and verified, and has a role that can manage users.
+func (app *application) canManageUsers(userID int) (bool, error) {
+	exists, err := app.users.Exists(userID)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	role, verified, err := app.users.Role(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return verified && models.Permissions(role)[models.PermUserManage], nil
+}
+
+// audit records a security event in the audit log, along with the IP address and browser the request came from.
+// The actor is the authenticated user unless the event names one, such as the user who has just logged in.
+func (app *application) audit(r *http.Request, event models.AuditEvent) error {
+	if event.ActorID == 0 && event.ActorEmail == "" {
+		event.ActorID = app.authenticatedUserID(r)
+	}
+
+	// An admin viewing the site as the actor is recorded along with them.
+	if impersonator := app.impersonatorID(r); impersonator != event.ActorID {
+		event.ActingAdminID = impersonator
+	}
+
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+
+	return app.auditEvents.Insert(&event)
+}
+
+// auditAfter records an event for an action that has already taken effect, such as logging a user out everywhere.
+// A failure to record it is only logged, since it must not stop the action or hide that it happened.
+func (app *application) auditAfter(r *http.Request, event models.AuditEvent) {
+	if err := app.audit(r, event); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// confirmPassword checks the password a logged-in user gave to confirm an action, such as "account deletion", and
+// adds an error to the password field of form if it is wrong. It is throttled and audited like the login form, so
+// that a stolen session can't be used to guess the password.
+func (app *application) confirmPassword(r *http.Request, form *validator.Validator, user *models.User, password,
+	action string) error {
+	ip := clientIP(r)
+
+	lockedUntil, err := app.loginThrottle.LockedUntil(ip, user.Email)
+	if err != nil {
+		return err
+	}
+
+	if !lockedUntil.IsZero() {
+		form.AddFieldError("password", lockoutMessage(lockedUntil))
+
+		return app.audit(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetID: user.ID,
+			Detail: action + ": locked out"})
+	}
+
+	_, err = app.authenticator.Authenticate(user.Email, password)
+	if err == nil {
+		return app.loginThrottle.Reset(user.Email)
+	}
+	if !errors.Is(err, models.ErrInvalidCredentials) {
+		return err
+	}
+
+	lockedUntil, err = app.loginThrottle.Fail(ip, user.Email)
+	if err != nil {
+		return err
+	}
+
+	if lockedUntil.IsZero() {
+		form.AddFieldError("password", "Password is incorrect")
+	} else {
+		form.AddFieldError("password", lockoutMessage(lockedUntil))
+	}
+
+	return app.audit(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetID: user.ID,
+		Detail: action + ": incorrect password"})
+}
+
+// background runs fn in a goroutine, off the request path, and logs a panic instead of crashing the server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Print(fmt.Errorf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// csvText escapes a CSV cell that a spreadsheet would take for a formula, by starting it with an apostrophe.
+func csvText(s string) string {
+	if s != "" && strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return "'" + s
+	}
+
+	return s
+}
+
+// lockoutMessage tells a user how long they have to wait before they can try to log in again.
+func lockoutMessage(until time.Time) string {
+	wait := time.Until(until)
+	if wait <= time.Minute {
+		return fmt.Sprintf("Too many failed login attempts. Please try again in %d seconds",
+			int(math.Ceil(wait.Seconds())))
+	}
+
+	return fmt.Sprintf("Too many failed login attempts. Please try again in %d minutes", int(math.Ceil(wait.Minutes())))
 }
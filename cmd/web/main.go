@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
@@ -20,7 +24,11 @@ import (
 	"github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/mabego/snippetbox-mysql/internal/auth"
+	"github.com/mabego/snippetbox-mysql/internal/mailer"
 	"github.com/mabego/snippetbox-mysql/internal/models"
+	"github.com/mabego/snippetbox-mysql/internal/oidc"
+	"github.com/mabego/snippetbox-mysql/internal/validator"
 	"github.com/mabego/snippetbox-mysql/migrations"
 )
 
@@ -28,10 +36,13 @@ const (
 	AppPort         = ":4000"
 	DBPort          = "3306"
 	IdleTimeout     = time.Minute
+	LDAPTimeout     = 10 * time.Second
 	Path            = "sql"
 	ReadTimeout     = 5 * time.Second
 	SessionLifetime = 12 * time.Hour
-	WriteTimeout    = 10 * time.Second
+	// SessionTouchInterval is how often the last-seen time of a session in use is recorded.
+	SessionTouchInterval = time.Minute
+	WriteTimeout         = 10 * time.Second
 )
 
 // config holds sensitive data from the environment variable "DSN"
@@ -43,15 +54,37 @@ type config struct {
 }
 
 type application struct {
-	debug          bool
-	errorLog       *log.Logger
-	infoLog        *log.Logger
-	snippets       models.SnippetModelInterface
-	users          models.UserModelInterface
-	reviews        models.ReviewModelInterface
-	templateCache  map[string]*template.Template
-	formDecoder    *form.Decoder
-	sessionManager *scs.SessionManager
+	debug              bool
+	baseURL            string
+	errorLog           *log.Logger
+	infoLog            *log.Logger
+	snippets           models.SnippetModelInterface
+	users              models.UserModelInterface
+	authenticator      auth.Authenticator
+	passwordPolicy     *validator.PasswordPolicy
+	reviews            models.ReviewModelInterface
+	reviewRequests     models.ReviewRequestModelInterface
+	snippetEvents      models.SnippetEventModelInterface
+	comments           models.CommentModelInterface
+	stars              models.StarModelInterface
+	invitations        models.InvitationModelInterface
+	passwordResets     models.PasswordResetModelInterface
+	emailVerifications models.EmailVerificationModelInterface
+	twoFactor          models.TwoFactorModelInterface
+	settings           models.SettingModelInterface
+	loginThrottle      models.LoginThrottleModelInterface
+	userSessions       models.UserSessionModelInterface
+	rememberTokens     models.RememberTokenModelInterface
+	apiTokens          models.APITokenModelInterface
+	teams              models.TeamModelInterface
+	auditEvents        models.AuditEventModelInterface
+	oidc               *oidc.Provider
+	statsCache         *statsCache
+	templateCache      map[string]*template.Template
+	formDecoder        *form.Decoder
+	sessionManager     *scs.SessionManager
+	mailer             mailer.Sender
+	wg                 sync.WaitGroup
 }
 
 func main() {
@@ -65,12 +98,45 @@ func main() {
 		errorLog.Fatal("error loading env: ", err)
 	}
 
+	// Each migration file holds a single statement, so the DSN does not need multiStatements.
 	_dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", cfg.Username, cfg.Password, cfg.Host, DBPort,
 		cfg.Dbname)
 
 	addr := flag.String("addr", AppPort, "HTTP network address")
 	dsn := flag.String("dsn", _dsn, "Data source name")
 	debug := flag.Bool("debug", false, "Enable debug mode in the browser")
+	baseURL := flag.String("base-url", "http://localhost"+AppPort, "Base URL for links in email")
+	mailDir := flag.String("mail-dir", "", "Directory to write email to as files instead of logging it")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL; enables single sign-on")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcRoleClaim := flag.String("oidc-role-claim", "groups", "ID token claim to map to roles")
+	oidcRoles := flag.String("oidc-roles", "", "Comma-separated claim value=role mappings, such as admins=admin")
+	oidcDefaultRole := flag.String("oidc-default-role", models.RoleViewer, "Role of users no mapping matches")
+	authBackend := flag.String("auth", "database", "Where to check passwords: database or ldap")
+	ldapURL := flag.String("ldap-url", "", "LDAP server URL, such as ldaps://ldap.example.com")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "DN to look users up as; anonymous if empty")
+	ldapBaseDN := flag.String("ldap-base-dn", "", "DN to look for users under")
+	ldapUserFilter := flag.String("ldap-user-filter", "(mail=%s)", "Filter that finds a user by email address")
+	ldapNameAttr := flag.String("ldap-name-attr", "cn", "Attribute holding a user's name")
+	ldapEmailAttr := flag.String("ldap-email-attr", "mail", "Attribute holding a user's email address")
+	ldapGroupAttr := flag.String("ldap-group-attr", "memberOf", "Attribute holding a user's group DNs")
+	ldapRoles := flag.String("ldap-roles", "", "Comma-separated group=role mappings, such as admins=admin")
+	ldapDefaultRole := flag.String("ldap-default-role", models.RoleViewer, "Role of users no mapping matches")
+	hashAlgorithm := flag.String("password-hash", models.DefaultPasswordHasher.Algorithm,
+		"Algorithm to hash new passwords with: argon2id or bcrypt")
+	bcryptCost := flag.Int("bcrypt-cost", models.DefaultPasswordHasher.BcryptCost, "bcrypt work factor")
+	argon2Memory := flag.Uint("argon2-memory", uint(models.DefaultPasswordHasher.Argon2Memory),
+		"argon2id memory in KiB")
+	argon2Time := flag.Uint("argon2-time", uint(models.DefaultPasswordHasher.Argon2Time), "argon2id passes")
+	argon2Threads := flag.Uint("argon2-threads", uint(models.DefaultPasswordHasher.Argon2Threads),
+		"argon2id parallelism")
+	argon2Concurrency := flag.Int("argon2-concurrency", models.DefaultPasswordHasher.Argon2Concurrency,
+		"Most argon2id hashes to work out at once, each taking -argon2-memory; 0 for no limit")
+	minPasswordLength := flag.Int("min-password-length", MinChars, "Minimum length of new passwords")
+	minPasswordStrength := flag.Int("min-password-strength", validator.StrengthFair,
+		"Minimum strength score of new passwords, from 0 (very weak) to 4 (very strong)")
+	breachedPasswords := flag.String("breached-passwords", "",
+		"Directory of breached password SHA-1 hash files named by 5-character prefix, as from Have I Been Pwned")
 
 	flag.Parse()
 
@@ -100,18 +166,150 @@ func main() {
 	sessionManager.Store = mysqlstore.New(db)
 	sessionManager.Lifetime = SessionLifetime
 
+	var sender mailer.Sender = &mailer.LogSender{Logger: infoLog}
+	if *mailDir != "" {
+		sender = &mailer.FileSender{Dir: *mailDir}
+	}
+
+	// The client secret comes from the environment, like the database password.
+	var provider *oidc.Provider
+	if *oidcIssuer != "" {
+		provider, err = newOIDCProvider(oidc.Config{
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  *baseURL + "/user/login/oidc/callback",
+			RoleClaim:    *oidcRoleClaim,
+			DefaultRole:  *oidcDefaultRole,
+		}, *oidcRoles)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	if *argon2Threads > math.MaxUint8 || *argon2Memory > math.MaxUint32 || *argon2Time > math.MaxUint32 {
+		errorLog.Fatal("argon2id parameters out of range")
+	}
+
+	passwordPolicy := &validator.PasswordPolicy{
+		MinLength:   *minPasswordLength,
+		MinStrength: *minPasswordStrength,
+	}
+	if *breachedPasswords != "" {
+		// A missing directory would otherwise let every breached password through without a word.
+		info, err := os.Stat(*breachedPasswords)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		if !info.IsDir() {
+			errorLog.Fatalf("breached passwords: %s is not a directory", *breachedPasswords)
+		}
+
+		passwordPolicy.Breached = &validator.BreachedPasswords{Dir: *breachedPasswords}
+	}
+
+	// Hashes made with another algorithm or parameters are upgraded when their users next log in.
+	passwords := &models.PasswordHasher{
+		Algorithm:         *hashAlgorithm,
+		BcryptCost:        *bcryptCost,
+		Argon2Memory:      uint32(*argon2Memory),
+		Argon2Time:        uint32(*argon2Time),
+		Argon2Threads:     uint8(*argon2Threads),
+		Argon2Concurrency: *argon2Concurrency,
+	}
+	if err := passwords.Validate(); err != nil {
+		errorLog.Fatal(err)
+	}
+
+	users := &models.UserModel{DB: db, Passwords: passwords}
+
+	// TOTP secrets are encrypted with a key from the environment, like the database password, so that a copy of
+	// the database alone doesn't give them away. Generate one with "openssl rand -hex 32". Without a key, users
+	// can't turn on two-factor authentication, and the server only refuses to start if some already have.
+	twoFactor := &models.TwoFactorModel{DB: db}
+
+	if key := os.Getenv("TOTP_SECRET_KEY"); key != "" {
+		totpKey, err := hex.DecodeString(key)
+		if err != nil {
+			errorLog.Fatalf("TOTP_SECRET_KEY: %s", err)
+		}
+		twoFactor.Secrets, err = models.NewSecretBox(totpKey)
+		if err != nil {
+			errorLog.Fatalf("TOTP_SECRET_KEY: %s", err)
+		}
+	} else {
+		sealed, err := twoFactor.HasSealedSecrets()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		if sealed {
+			errorLog.Fatal("TOTP_SECRET_KEY is not set, but users have encrypted TOTP secrets that need it")
+		}
+		infoLog.Print("TOTP_SECRET_KEY is not set, so two-factor authentication can't be turned on")
+	}
+
+	// The bind password comes from the environment, like the database password.
+	var authenticator auth.Authenticator
+	switch *authBackend {
+	case "database":
+		authenticator = &auth.Database{Users: users}
+	case "ldap":
+		authenticator, err = newLDAPAuthenticator(&auth.LDAP{
+			URL:            *ldapURL,
+			BindDN:         *ldapBindDN,
+			BindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:         *ldapBaseDN,
+			UserFilter:     *ldapUserFilter,
+			NameAttribute:  *ldapNameAttr,
+			EmailAttribute: *ldapEmailAttr,
+			GroupAttribute: *ldapGroupAttr,
+			DefaultRole:    *ldapDefaultRole,
+			Timeout:        LDAPTimeout,
+			Users:          users,
+		}, *ldapRoles)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	default:
+		errorLog.Fatalf("unknown auth backend %q", *authBackend)
+	}
+
 	app := &application{
-		debug:          *debug,
-		errorLog:       errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
-		reviews:        &models.ReviewModel{DB: db},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		debug:              *debug,
+		baseURL:            *baseURL,
+		errorLog:           errorLog,
+		infoLog:            infoLog,
+		snippets:           &models.SnippetModel{DB: db},
+		users:              users,
+		authenticator:      authenticator,
+		passwordPolicy:     passwordPolicy,
+		reviews:            &models.ReviewModel{DB: db},
+		reviewRequests:     &models.ReviewRequestModel{DB: db},
+		snippetEvents:      &models.SnippetEventModel{DB: db},
+		comments:           &models.CommentModel{DB: db},
+		stars:              &models.StarModel{DB: db},
+		invitations:        &models.InvitationModel{DB: db, Passwords: passwords},
+		passwordResets:     &models.PasswordResetModel{DB: db, Passwords: passwords},
+		emailVerifications: &models.EmailVerificationModel{DB: db},
+		twoFactor:          twoFactor,
+		settings:           &models.SettingModel{DB: db},
+		loginThrottle:      &models.LoginThrottleModel{DB: db},
+		userSessions:       &models.UserSessionModel{DB: db},
+		rememberTokens:     &models.RememberTokenModel{DB: db},
+		apiTokens:          &models.APITokenModel{DB: db},
+		teams:              &models.TeamModel{DB: db},
+		auditEvents:        &models.AuditEventModel{DB: db},
+		oidc:               provider,
+		statsCache:         newStatsCache(StatsCacheTTL),
+		templateCache:      templateCache,
+		formDecoder:        formDecoder,
+		sessionManager:     sessionManager,
+		mailer:             sender,
 	}
 
+	// Send review reminders and escalations in the background.
+	go app.reviewScheduler(ReminderInterval)
+
 	srv := &http.Server{
 		Addr:         *addr,
 		Handler:      app.routes(),
@@ -125,6 +323,54 @@ func main() {
 	errorLog.Fatal(srv.ListenAndServe())
 }
 
+// newOIDCProvider checks the role mappings and connects to the identity provider.
+func newOIDCProvider(cfg oidc.Config, roles string) (*oidc.Provider, error) {
+	mappings, err := auth.ParseRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mapping := range mappings {
+		if !models.ValidRole(mapping.Role) {
+			return nil, fmt.Errorf("oidc: unknown role %q", mapping.Role)
+		}
+	}
+
+	if !models.ValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("oidc: unknown role %q", cfg.DefaultRole)
+	}
+
+	cfg.Roles = mappings
+
+	return oidc.New(context.Background(), cfg)
+}
+
+// newLDAPAuthenticator checks the role mappings and sets them on the authenticator.
+func newLDAPAuthenticator(l *auth.LDAP, roles string) (*auth.LDAP, error) {
+	if l.URL == "" || l.BaseDN == "" {
+		return nil, errors.New("ldap: -ldap-url and -ldap-base-dn are required")
+	}
+
+	mappings, err := auth.ParseRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mapping := range mappings {
+		if !models.ValidRole(mapping.Role) {
+			return nil, fmt.Errorf("ldap: unknown role %q", mapping.Role)
+		}
+	}
+
+	if !models.ValidRole(l.DefaultRole) {
+		return nil, fmt.Errorf("ldap: unknown role %q", l.DefaultRole)
+	}
+
+	l.Roles = mappings
+
+	return l, nil
+}
+
 // openDB wraps sql.Open and returns a sql.DB connection pool for a given data source name
 func openDB(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("mysql", dsn)
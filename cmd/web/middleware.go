@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/mabego/snippetbox-mysql/internal/models"
 )
 
 var ErrRecovered = errors.New("recovered")
@@ -66,20 +69,122 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) requireAuthorization(next http.Handler) http.Handler {
+// requirePermission returns middleware that only lets users with the named permission through. Visitors who are
+// not logged in are sent to the login page, and logged-in users without the permission get a 403 response.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return app.requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.can(r, permission) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// requireTwoFactorSetup keeps an admin who must use two-factor authentication on the account page until they set
+// it up. Logging out is still allowed. The requirement is checked on every request, so that admins who are already
+// logged in when it is turned on have to set it up too. It doesn't apply to API tokens or to an admin viewing the
+// site as another user.
+func (app *application) requireTwoFactorSetup(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !app.isAuthorized(r) {
-			app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.Path)
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		_, bearer := bearerToken(r)
+		if !app.isAuthenticated(r) || bearer || app.impersonatorID(r) != 0 {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		w.Header().Add("Cache-Control", "no-store")
+		switch r.URL.Path {
+		case "/account/view", "/account/2fa/qr", "/account/2fa/enable", "/user/logout":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		required, err := app.twoFactorSetupRequired(user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if required {
+			app.sessionManager.Put(r.Context(), "flash",
+				"Two-factor authentication is required for admins. Please set it up to continue")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireLocalPasswords serves a 404 for the pages that change or reset local passwords when another backend, such
+// as an LDAP directory, checks passwords, since the new password would never be used.
+func (app *application) requireLocalPasswords(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.localPasswords() {
+			app.notFound(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// restrictImpersonation keeps an admin who is viewing the site as another user away from that user's account
+// settings, such as their password, two-factor authentication and API tokens, and from the admin pages. They can
+// still see the user's account page and favorites, and browse the rest of the site, but the only changes they can
+// make are edits and reviews, which record the admin as well as the user. Anything else, such as creating or
+// deleting a snippet, commenting or managing a team, would be put down to the user alone.
+func (app *application) restrictImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isAuthenticated(r) && app.impersonatorID(r) != 0 && impersonationRestricted(r) {
+			app.sessionManager.Put(r.Context(), "flash", "You can't do that while viewing as another user")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// impersonationRestricted reports whether a request is one that restrictImpersonation turns away.
+func impersonationRestricted(r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/account/view" || r.URL.Path == "/account/favorites"):
+		return false
+	case strings.HasPrefix(r.URL.Path, "/account/"), strings.HasPrefix(r.URL.Path, "/admin/"):
+		return true
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return false
+	// A review is posted to the snippet's page.
+	case strings.HasPrefix(r.URL.Path, "/snippet/edit/"), strings.HasPrefix(r.URL.Path, "/snippet/view/"):
+		return false
+	case r.URL.Path == "/user/impersonate/stop", r.URL.Path == "/user/logout":
+		return false
+	default:
+		return true
+	}
+}
+
+// limitBody caps the size of request bodies at n bytes. It must come before noSurf, which parses the form of every
+// POST request to find the CSRF token, and a body that is too large then fails that check.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -88,9 +193,95 @@ func noSurf(next http.Handler) http.Handler {
 		HttpOnly: true,
 	})
 
+	// A browser cannot be tricked into sending an API token, so requests made with one need no CSRF token. Those with
+	// an invalid token are turned away by authenticateToken.
+	csrfHandler.ExemptFunc(hasBearerToken)
+
 	return csrfHandler
 }
 
+// remember logs a user back in with their remember token once their session has expired. Each token is swapped for
+// a new one when it is used. A token that is used twice was copied, so every session of its user is logged out.
+func (app *application) remember(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.sessionManager.GetInt(r.Context(), "authenticatedUserID") != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(RememberCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, family, token, err := app.rememberTokens.Use(cookie.Value)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrNoRecord):
+				clearRememberCookie(w)
+				next.ServeHTTP(w, r)
+			case errors.Is(err, models.ErrTokenReused):
+				app.infoLog.Printf("remember token reused for user %d, logging out all of their sessions", userID)
+				clearRememberCookie(w)
+
+				if err := app.destroyUserSessions(r.Context(), userID); err != nil {
+					app.serverError(w, err)
+					return
+				}
+
+				app.auditAfter(r, models.AuditEvent{Action: models.AuditLoginFailed, TargetID: userID,
+					Detail: "remember token reused"})
+
+				next.ServeHTTP(w, r)
+			default:
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		user, err := app.users.Get(userID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+
+		// A disabled user, or one whose password an admin has reset, is not let back in, and loses their remember
+		// token.
+		if user == nil || user.Disabled || user.PasswordResetRequired {
+			if err := app.rememberTokens.DeleteFamily(family); err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			clearRememberCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A token used again by a request sent alongside the one that swapped it has no next token, and the browser
+		// keeps the one it got from that request.
+		if token != "" {
+			setRememberCookie(w, token)
+		}
+
+		err = app.startSession(r, user, family)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.audit(r, models.AuditEvent{ActorID: user.ID, Action: models.AuditLogin, TargetID: user.ID,
+			Detail: "remember token"})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// GetInt will return 0 if no authenticatedUserID value is in the session,
@@ -110,7 +301,18 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// When a matching ID is found, create a copy of the request with an isAuthenticatedContextKey
 		// value of true in the request context and assign it to r.
 		if exists {
+			// The last-seen time is only recorded once a minute or so, rather than on every request.
+			touched := time.Unix(app.sessionManager.GetInt64(r.Context(), "sessionTouched"), 0)
+			if time.Since(touched) > SessionTouchInterval {
+				err = app.trackSession(r, id)
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+			}
+
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
 			r = r.WithContext(ctx)
 		}
 
@@ -129,17 +331,113 @@ func (app *application) authorize(next http.Handler) http.Handler {
 			return
 		}
 
-		owner, err := app.users.Authorize(id)
+		// The role is read on every request, so a change made by an admin applies straight away.
+		role, verified, err := app.users.Role(id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				next.ServeHTTP(w, r)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		// Until a user verifies their email address, they only have the permissions of a viewer.
+		ctx := r.Context()
+		if !verified {
+			role = models.RoleViewer
+			ctx = context.WithValue(ctx, isUnverifiedContextKey, true)
+		}
+
+		r, err = app.withSnippet(r.WithContext(ctx))
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
-		if owner {
-			ctx := context.WithValue(r.Context(), isAuthorizedContextKey, true)
-			r = r.WithContext(ctx)
+		permissions, err := app.rolePermissions(r, id, role, verified)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), permissionsContextKey, permissions))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateToken logs a script in with the API token in an "Authorization: Bearer" header instead of a session.
+// It sets the same context values as authenticate and authorize, with the user's permissions limited to the
+// token's scopes. A missing, expired or revoked token gets a 401 response, and a token without the scope a request
+// needs gets a 403 response.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiToken, err := app.apiTokens.Authenticate(token)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.clientError(w, http.StatusUnauthorized)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		exists, err := app.users.Exists(apiToken.UserID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		// The tokens of a disabled user stop working along with their sessions.
+		if !exists {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+
+		scope := tokenScope(r)
+		if scope == "" || !apiToken.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			app.clientError(w, http.StatusForbidden)
+			return
 		}
 
+		role, verified, err := app.users.Role(apiToken.UserID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !verified {
+			role = models.RoleViewer
+		}
+
+		r, err = app.withSnippet(r)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		permissions, err := app.rolePermissions(r, apiToken.UserID, role, verified)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, apiToken.UserID)
+		ctx = context.WithValue(ctx, isUnverifiedContextKey, !verified)
+		ctx = context.WithValue(ctx, permissionsContextKey, apiToken.Limit(permissions))
+		r = r.WithContext(ctx)
+
 		next.ServeHTTP(w, r)
 	})
 }
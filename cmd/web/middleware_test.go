@@ -5,9 +5,14 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mabego/snippetbox-mysql/internal/assert"
+	"github.com/mabego/snippetbox-mysql/internal/models"
+	"github.com/mabego/snippetbox-mysql/internal/models/mocks"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -61,3 +66,299 @@ func TestSecureHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestRequirePermission(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/snippet/create")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	// Bob is a reviewer, who can submit reviews and comment but not create snippets or manage users.
+	csrfToken := ts.login(t, "bob@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Create snippet",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/create",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Delete snippet",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/delete/1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Manage users",
+			method:   http.MethodGet,
+			urlPath:  "/admin/users",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Submit review",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code int
+
+			if tt.method == http.MethodGet {
+				code, _, _ = ts.get(t, tt.urlPath)
+			} else {
+				form := url.Values{}
+				form.Add("csrf_token", csrfToken)
+				form.Add("verdict", "approved")
+				code, _, _ = ts.postForm(t, tt.urlPath, form)
+			}
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	t.Run("Templates hide forbidden links", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, "Post comment")
+
+		if strings.Contains(body, "/snippet/create") || strings.Contains(body, "Delete snippet") {
+			t.Errorf("reviewer sees links they do not have permission to use")
+		}
+	})
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	newToken := func(userID int, expires time.Time, scopes ...string) string {
+		token, err := app.apiTokens.Insert(userID, "ci", scopes, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// Alice is an admin and Bob a reviewer.
+	read := newToken(1, time.Time{}, models.ScopeRead)
+	write := newToken(1, time.Time{}, models.ScopeRead, models.ScopeWrite)
+	review := newToken(2, time.Time{}, models.ScopeReview)
+	expired := newToken(1, time.Now().Add(-time.Hour), models.ScopeRead)
+
+	snippet := url.Values{}
+	snippet.Add("title", "O snail")
+	snippet.Add("content", "Climb Mount Fuji")
+	snippet.Add("expires", "7")
+
+	verdict := url.Values{}
+	verdict.Add("verdict", models.VerdictApproved)
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		form     url.Values
+		wantCode int
+	}{
+		{
+			name:     "Read",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/1",
+			token:    read,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Write without CSRF token",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/create",
+			token:    write,
+			form:     snippet,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Write without write scope",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/create",
+			token:    read,
+			form:     snippet,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Submit review",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/view/1",
+			token:    review,
+			form:     verdict,
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Submit review for a missing snippet",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/view/2",
+			token:    review,
+			form:     verdict,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Submit review without review scope",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/view/1",
+			token:    write,
+			form:     verdict,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Account pages",
+			method:   http.MethodGet,
+			urlPath:  "/account/view",
+			token:    write,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Admin pages",
+			method:   http.MethodGet,
+			urlPath:  "/admin/users",
+			token:    write,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Expired token",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/1",
+			token:    expired,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Unknown token",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/1",
+			token:    "sbx_unknown",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.requestWithToken(t, tt.method, tt.urlPath, tt.token, tt.form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusUnauthorized || code == http.StatusForbidden {
+				assert.StringContains(t, headers.Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+
+	t.Run("Permissions limited to the user's role", func(t *testing.T) {
+		// Bob cannot create snippets, so neither can his token, whatever its scopes.
+		token := newToken(2, time.Time{}, models.ScopeRead, models.ScopeWrite)
+
+		code, _, _ := ts.requestWithToken(t, http.MethodPost, "/snippet/create", token, snippet)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+}
+
+func TestAuthorizeTeams(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Bob and Dave are reviewers, who can't edit snippets or manage teams. Bob is a member of Platform, which owns
+	// snippet 3, and Dave maintains Security.
+	csrfToken := ts.login(t, "bob@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Member edits a team snippet",
+			urlPath:  "/snippet/edit/3",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Member edits another snippet",
+			urlPath:  "/snippet/edit/1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Member creates a team snippet",
+			urlPath:  "/team/snippet/create/1",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Member deletes a team snippet",
+			urlPath:  "/snippet/delete/3",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code int
+
+			if strings.Contains(tt.urlPath, "/delete/") {
+				code, _, _ = ts.postForm(t, tt.urlPath, url.Values{"csrf_token": {csrfToken}})
+			} else {
+				code, _, _ = ts.get(t, tt.urlPath)
+			}
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	t.Run("Snippet read once", func(t *testing.T) {
+		snippets := app.snippets.(*mocks.SnippetModel)
+		snippets.Gets = 0
+
+		code, _, _ := ts.get(t, "/snippet/edit/3")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, snippets.Gets, 1)
+	})
+
+	t.Run("Member can't manage members", func(t *testing.T) {
+		_, _, body := ts.get(t, "/team/view/1")
+		if strings.Contains(body, "Save member") {
+			t.Errorf("member sees the member form")
+		}
+	})
+
+	// Dave uses two-factor authentication, so they are logged in with an API token instead.
+	token, err := app.apiTokens.Insert(4, "ci", []string{models.ScopeRead, models.ScopeWrite}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Non-member", func(t *testing.T) {
+		code, _, _ := ts.requestWithToken(t, http.MethodGet, "/snippet/edit/3", token, nil)
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, _ = ts.requestWithToken(t, http.MethodGet, "/team/snippet/create/1", token, nil)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Maintainer", func(t *testing.T) {
+		_, _, body := ts.requestWithToken(t, http.MethodGet, "/team/view/2", token, nil)
+		assert.StringContains(t, body, "Create team snippet")
+
+		// No scope lets a token manage a team.
+		if strings.Contains(body, "Save member") {
+			t.Errorf("API token sees the member form")
+		}
+	})
+}
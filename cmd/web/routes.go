@@ -5,6 +5,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
+	"github.com/mabego/snippetbox-mysql/internal/models"
 	"github.com/mabego/snippetbox-mysql/ui"
 )
 
@@ -24,13 +25,29 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
 	// An unprotected middleware chain using alice, specific to 'dynamic' application routes.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate, app.authorize)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.remember, app.authenticate, app.authorize,
+		app.authenticateToken, app.requireTwoFactorSetup, app.restrictImpersonation)
 
 	// 'dynamic' middleware chain routes
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodGet, "/user/invite/:token", dynamic.ThenFunc(app.userInvite))
+	router.Handler(http.MethodPost, "/user/invite/:token", dynamic.ThenFunc(app.userInvitePost))
+	router.Handler(http.MethodGet, "/user/login/oidc", dynamic.ThenFunc(app.userLoginOIDC))
+	router.Handler(http.MethodGet, "/user/login/oidc/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/verify/:token", dynamic.ThenFunc(app.userVerify))
+
+	// Local passwords can only be reset or changed while the database checks them.
+	local := dynamic.Append(app.requireLocalPasswords)
+
+	router.Handler(http.MethodGet, "/user/password/forgot", local.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", local.ThenFunc(app.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", local.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", local.ThenFunc(app.userPasswordResetPost))
 
 	// A protected (authenticated-only) and dynamic middleware chain.
 	protected := dynamic.Append(app.requireAuthentication)
@@ -39,18 +56,89 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/", protected.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/about", protected.ThenFunc(app.aboutView))
 	router.Handler(http.MethodGet, "/snippet/view/:id", protected.ThenFunc(app.snippetView))
-	router.Handler(http.MethodPost, "/snippet/view/:id", protected.ThenFunc(app.reviewUpdatePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodPost, "/user/impersonate/stop", protected.ThenFunc(app.impersonateStopPost))
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.starPost))
 	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
-	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
-	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodGet, "/account/favorites", protected.ThenFunc(app.accountFavorites))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodPost, "/account/sessions/revoke/:id", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-all", protected.ThenFunc(app.accountSessionsRevokeAllPost))
+	router.Handler(http.MethodPost, "/account/verify/resend", protected.ThenFunc(app.accountVerifyResendPost))
+	router.Handler(http.MethodGet, "/account/2fa/qr", protected.ThenFunc(app.accountTwoFactorQR))
+	router.Handler(http.MethodPost, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+	router.Handler(http.MethodGet, "/account/profile", protected.ThenFunc(app.accountProfile))
+	router.Handler(http.MethodPost, "/account/profile",
+		alice.New(limitBody(ProfileMaxBytes)).Extend(protected).ThenFunc(app.accountProfilePost))
+	router.Handler(http.MethodGet, "/user/view/:id", protected.ThenFunc(app.userView))
+	router.Handler(http.MethodGet, "/user/avatar/:id", protected.ThenFunc(app.userAvatar))
+	router.Handler(http.MethodGet, "/teams", protected.ThenFunc(app.teamList))
+	router.Handler(http.MethodGet, "/team/view/:id", protected.ThenFunc(app.teamView))
+	router.Handler(http.MethodGet, "/account/tokens", protected.ThenFunc(app.accountTokens))
+	router.Handler(http.MethodPost, "/account/tokens", protected.ThenFunc(app.accountTokenCreatePost))
+	router.Handler(http.MethodPost, "/account/tokens/revoke/:id", protected.ThenFunc(app.accountTokenRevokePost))
+	router.Handler(http.MethodGet, "/account/export", protected.ThenFunc(app.accountExportView))
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.accountDelete))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodGet, "/account/password/update",
+		protected.Append(app.requireLocalPasswords).ThenFunc(app.accountPasswordUpdate))
+	router.Handler(http.MethodPost, "/account/password/update",
+		protected.Append(app.requireLocalPasswords).ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodGet, "/review/queue", protected.ThenFunc(app.reviewQueue))
+	router.Handler(http.MethodGet, "/comment/edit/:id", protected.ThenFunc(app.commentEdit))
+	router.Handler(http.MethodPost, "/comment/edit/:id", protected.ThenFunc(app.commentEditPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
+
+	// Permission middleware chains. Each one requires authentication and then the named permission.
+	can := func(permission string) alice.Chain {
+		return dynamic.Append(app.requirePermission(permission))
+	}
+
+	// Permission middleware chain routes
+	router.Handler(http.MethodGet, "/snippet/create", can(models.PermSnippetCreate).ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create",
+		can(models.PermSnippetCreate).ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", can(models.PermSnippetEdit).ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", can(models.PermSnippetEdit).ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/delete/:id",
+		can(models.PermSnippetDelete).ThenFunc(app.snippetDeletePost))
+	router.Handler(http.MethodPost, "/snippet/view/:id", can(models.PermReviewSubmit).ThenFunc(app.reviewUpdatePost))
+	router.Handler(http.MethodPost, "/snippet/request/:id",
+		can(models.PermReviewRequest).ThenFunc(app.reviewRequestPost))
+	router.Handler(http.MethodGet, "/review/stats", can(models.PermReviewStats).ThenFunc(app.reviewStats))
+	router.Handler(http.MethodPost, "/snippet/comment/:id",
+		can(models.PermCommentCreate).ThenFunc(app.commentCreatePost))
+
+	router.Handler(http.MethodPost, "/teams", can(models.PermTeamCreate).ThenFunc(app.teamCreatePost))
+	router.Handler(http.MethodPost, "/team/member/add/:id",
+		can(models.PermTeamManage).ThenFunc(app.teamMemberAddPost))
+	router.Handler(http.MethodPost, "/team/member/remove/:id",
+		can(models.PermTeamManage).ThenFunc(app.teamMemberRemovePost))
+	router.Handler(http.MethodGet, "/team/snippet/create/:id",
+		can(models.PermSnippetCreate).ThenFunc(app.teamSnippetCreate))
+	router.Handler(http.MethodPost, "/team/snippet/create/:id",
+		can(models.PermSnippetCreate).ThenFunc(app.teamSnippetCreatePost))
+
+	manage := can(models.PermUserManage)
+
+	router.Handler(http.MethodGet, "/admin/users", manage.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/role/:id", manage.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodPost, "/admin/users/status/:id", manage.ThenFunc(app.adminUserStatusPost))
+	router.Handler(http.MethodPost, "/admin/users/reset/:id",
+		manage.Append(app.requireLocalPasswords).ThenFunc(app.adminUserResetPost))
+	router.Handler(http.MethodPost, "/admin/users/delete/:id", manage.ThenFunc(app.adminUserDeletePost))
+	router.Handler(http.MethodPost, "/admin/users/impersonate/:id", manage.ThenFunc(app.adminUserImpersonatePost))
+	router.Handler(http.MethodPost, "/admin/settings", manage.ThenFunc(app.adminSettingsPost))
+	router.Handler(http.MethodGet, "/admin/invitations", manage.ThenFunc(app.invitationList))
+	router.Handler(http.MethodPost, "/admin/invitations", manage.ThenFunc(app.invitationCreatePost))
+	router.Handler(http.MethodPost, "/admin/invitations/resend/:id", manage.ThenFunc(app.invitationResendPost))
+	router.Handler(http.MethodPost, "/admin/invitations/revoke/:id", manage.ThenFunc(app.invitationRevokePost))
 
-	// An authorized-only and dynamic middleware chain.
-	owner := dynamic.Append(app.requireAuthorization)
+	audit := can(models.PermAuditView)
 
-	// 'owner' middleware chain routes
-	router.Handler(http.MethodGet, "/snippet/create", owner.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", owner.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/admin/audit", audit.ThenFunc(app.adminAudit))
+	router.Handler(http.MethodGet, "/admin/audit/export", audit.ThenFunc(app.adminAuditExport))
 
 	// A middleware chain using alice containing the 'standard' middleware used for every application request.
 	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
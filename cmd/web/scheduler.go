@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ReminderInterval = 5 * time.Minute
+	ReminderLeadTime = 24 * time.Hour
+)
+
+// reviewScheduler checks review requests on every tick of the interval for the lifetime of the process.
+// Start it in its own goroutine.
+func (app *application) reviewScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.sendReviewReminders(); err != nil {
+			app.errorLog.Print(err)
+		}
+	}
+}
+
+// sendReviewReminders reminds reviewers of requests that fall due within ReminderLeadTime and escalates overdue
+// requests to the user who made them. A message that fails to send is logged and its claim is cleared, so that the
+// next tick retries it while the rest of the run carries on.
+func (app *application) sendReviewReminders() error {
+	dueSoon, err := app.reviewRequests.DueSoon(ReminderLeadTime)
+	if err != nil {
+		return fmt.Errorf("review reminders: %w", err)
+	}
+
+	for _, rr := range dueSoon {
+		// Claim the reminder first so that only one container sends it.
+		claimed, err := app.reviewRequests.MarkReminded(rr.ID)
+		if err != nil {
+			return fmt.Errorf("review reminders: %w", err)
+		}
+		if !claimed {
+			continue
+		}
+
+		subject := fmt.Sprintf("Review due %s: %s", humanDate(rr.Due), rr.SnippetTitle)
+		body := fmt.Sprintf("%s asked you to review %q by %s.\n%s", rr.RequesterName, rr.SnippetTitle,
+			humanDate(rr.Due), app.snippetURL(rr.SnippetID))
+
+		if err := app.mailer.Send(rr.ReviewerEmail, subject, body); err != nil {
+			app.errorLog.Printf("review reminders: %v", err)
+
+			if err := app.reviewRequests.ClearReminded(rr.ID); err != nil {
+				app.errorLog.Printf("review reminders: %v", err)
+			}
+		}
+	}
+
+	overdue, err := app.reviewRequests.Overdue()
+	if err != nil {
+		return fmt.Errorf("review escalations: %w", err)
+	}
+
+	for _, rr := range overdue {
+		claimed, err := app.reviewRequests.MarkEscalated(rr.ID)
+		if err != nil {
+			return fmt.Errorf("review escalations: %w", err)
+		}
+		if !claimed {
+			continue
+		}
+
+		subject := fmt.Sprintf("Review overdue: %s", rr.SnippetTitle)
+		body := fmt.Sprintf("%s has not reviewed %q, which was due %s.\n%s", rr.ReviewerName, rr.SnippetTitle,
+			humanDate(rr.Due), app.snippetURL(rr.SnippetID))
+
+		if err := app.mailer.Send(rr.RequesterEmail, subject, body); err != nil {
+			app.errorLog.Printf("review escalations: %v", err)
+
+			if err := app.reviewRequests.ClearEscalated(rr.ID); err != nil {
+				app.errorLog.Printf("review escalations: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// snippetURL returns the absolute URL of a snippet for use in notifications.
+func (app *application) snippetURL(id int) string {
+	return fmt.Sprintf("%s/snippet/view/%d", app.baseURL, id)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestSendReviewReminders(t *testing.T) {
+	app := newTestApplication(t)
+
+	sender := &recordingSender{}
+	app.mailer = sender
+
+	err := app.sendReviewReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mock model returns two requests that are due soon and one that is overdue.
+	assert.Equal(t, len(sender.recipients), 3)
+	assert.StringContains(t, sender.subjects[0], "Review due")
+	assert.StringContains(t, sender.subjects[1], "Review due")
+	assert.StringContains(t, sender.subjects[2], "Review overdue")
+
+	// A second run sends nothing, because every request has been claimed.
+	err = app.sendReviewReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(sender.recipients), 3)
+}
+
+func TestSendReviewRemindersFailure(t *testing.T) {
+	app := newTestApplication(t)
+	app.mailer = failingSender{}
+
+	// Every send fails, but the run carries on through the remaining reminders and the escalation.
+	err := app.sendReviewReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed messages are released, so the next run sends all of them once the mail server is back.
+	sender := &recordingSender{}
+	app.mailer = sender
+
+	err = app.sendReviewReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(sender.recipients), 3)
+	assert.StringContains(t, sender.subjects[2], "Review overdue")
+}
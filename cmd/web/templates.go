@@ -1,27 +1,81 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mabego/snippetbox-mysql/internal/models"
 	"github.com/mabego/snippetbox-mysql/ui"
+	"github.com/yuin/goldmark"
 )
 
 // templateData holds dynamic data to pass to HTML templates.
 type templateData struct {
-	IsAuthenticated bool
-	IsAuthorized    bool
-	CurrentYear     int
-	Flash           string
-	CSRFToken       string
-	Snippet         *models.Snippet
-	Review          *models.Review
-	User            *models.User
-	Snippets        []*models.Snippet
-	Form            any
+	IsAuthenticated       bool
+	IsUnverified          bool
+	SSOEnabled            bool
+	LocalPasswords        bool
+	Permissions           map[string]bool
+	Roles                 []string
+	UserID                int
+	Impersonating         string
+	CurrentYear           int
+	Flash                 string
+	CSRFToken             string
+	Snippet               *models.Snippet
+	Review                *models.Review
+	ReviewRequests        []*models.ReviewRequest
+	ReviewerStats         []*models.ReviewerStats
+	SnippetEvents         []*models.SnippetEvent
+	Comment               *models.Comment
+	Comments              []*models.Comment
+	Invitation            *models.Invitation
+	Invitations           []*models.Invitation
+	PasswordReset         *models.PasswordReset
+	RecoveryCodes         []string
+	RecoveryCodesLeft     int
+	RequireAdminTwoFactor bool
+	TwoFactorSecret       string
+	TwoFactorAvailable    bool
+	Token                 string
+	Window                string
+	User                  *models.User
+	Users                 []*models.User
+	Search                string
+	Sessions              []*models.UserSession
+	Team                  *models.Team
+	Teams                 []*models.Team
+	TeamMembers           []*models.TeamMember
+	TeamRoles             []string
+	APITokens             []*models.APIToken
+	Scopes                []string
+	AuditEvents           []*models.AuditEvent
+	AuditActions          []string
+	Action                string
+	Snippets              []*models.Snippet
+	Sort                  string
+	Starred               bool
+	Form                  any
+	CommentForm           any
+}
+
+// Can reports whether the current user has the named permission, so that templates only show the links and forms
+// the user can use.
+func (td *templateData) Can(permission string) bool {
+	return td.Permissions[permission]
+}
+
+// title capitalizes the first letter of a word such as a role name.
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
 }
 
 func humanDate(t time.Time) string {
@@ -31,7 +85,93 @@ func humanDate(t time.Time) string {
 	return t.Format("Jan 02 2006 at 15:04")
 }
 
-var functions = template.FuncMap{"humanDate": humanDate}
+// humanDuration formats a duration in days, hours and minutes, leaving out the larger units when they are zero.
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// markdown renders user-written Markdown as HTML. Goldmark leaves out raw HTML and dangerous link URLs by default,
+// so the result is safe to insert into a page.
+func markdown(source string) template.HTML {
+	var buf bytes.Buffer
+
+	if err := goldmark.Convert([]byte(source), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+
+	return template.HTML(buf.String())
+}
+
+// device names the browser and operating system in a User-Agent header, such as "Firefox on Linux". It returns the
+// header as it is if it names neither.
+func device(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	systems := []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser, system := "", ""
+
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	case userAgent == "":
+		return "Unknown device"
+	default:
+		return userAgent
+	}
+}
+
+var functions = template.FuncMap{
+	"device":        device,
+	"humanDate":     humanDate,
+	"humanDuration": humanDuration,
+	"markdown":      markdown,
+	"title":         title,
+}
 
 func newTemplateCache() (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
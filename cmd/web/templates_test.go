@@ -33,3 +33,110 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{
+			name: "Minutes",
+			d:    42 * time.Minute,
+			want: "42m",
+		},
+		{
+			name: "Hours",
+			d:    3*time.Hour + 5*time.Minute,
+			want: "3h 5m",
+		},
+		{
+			name: "Days",
+			d:    26*time.Hour + 20*time.Second,
+			want: "1d 2h 0m",
+		},
+		{
+			name: "Zero",
+			d:    0,
+			want: "0m",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, humanDuration(tt.d), tt.want)
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "Emphasis",
+			source: "A **frog** jumps",
+			want:   "<p>A <strong>frog</strong> jumps</p>",
+		},
+		{
+			name:   "Raw HTML",
+			source: "<script>alert(1)</script>",
+			want:   "<!-- raw HTML omitted -->",
+		},
+		{
+			name:   "Dangerous link",
+			source: "[pond](javascript:alert(1))",
+			want:   `<a href="">pond</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.StringContains(t, string(markdown(tt.source)), tt.want)
+		})
+	}
+}
+
+func TestDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name:      "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+			want:      "Firefox on Linux",
+		},
+		{
+			name: "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+				"Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0",
+			want: "Edge on Windows",
+		},
+		{
+			name: "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 18_0 like Mac OS X) AppleWebKit/605.1.15 " +
+				"(KHTML, like Gecko) Version/18.0 Mobile/15E148 Safari/604.1",
+			want: "Safari on iPhone",
+		},
+		{
+			name:      "Unknown",
+			userAgent: "curl/8.5.0",
+			want:      "curl/8.5.0",
+		},
+		{
+			name:      "Empty",
+			userAgent: "",
+			want:      "Unknown device",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, device(tt.userAgent), tt.want)
+		})
+	}
+}
@@ -2,19 +2,32 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"html"
 	"io"
 	"log"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"github.com/jimlambrt/gldap"
+	"github.com/mabego/snippetbox-mysql/internal/auth"
+	"github.com/mabego/snippetbox-mysql/internal/mailer"
 	"github.com/mabego/snippetbox-mysql/internal/models/mocks"
+	"github.com/mabego/snippetbox-mysql/internal/validator"
 )
 
 // csrfTokenRX captures the CSRF token value from the user signup page.
@@ -49,18 +62,60 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = SessionLifetime
 	sessionManager.Cookie.Secure = true
 
+	users := &mocks.UserModel{}
+
 	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
-		snippets:       &mocks.SnippetModel{},
-		users:          &mocks.UserModel{},
-		reviews:        &mocks.ReviewModel{},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		errorLog:           log.New(io.Discard, "", 0),
+		infoLog:            log.New(io.Discard, "", 0),
+		snippets:           &mocks.SnippetModel{},
+		users:              users,
+		authenticator:      &auth.Database{Users: users},
+		passwordPolicy:     &validator.PasswordPolicy{MinLength: MinChars, MinStrength: validator.StrengthFair},
+		reviews:            &mocks.ReviewModel{},
+		reviewRequests:     &mocks.ReviewRequestModel{},
+		snippetEvents:      &mocks.SnippetEventModel{},
+		comments:           &mocks.CommentModel{},
+		stars:              &mocks.StarModel{},
+		invitations:        &mocks.InvitationModel{},
+		passwordResets:     &mocks.PasswordResetModel{},
+		emailVerifications: &mocks.EmailVerificationModel{},
+		twoFactor:          &mocks.TwoFactorModel{},
+		settings:           &mocks.SettingModel{},
+		loginThrottle:      &mocks.LoginThrottleModel{},
+		userSessions:       &mocks.UserSessionModel{},
+		rememberTokens:     &mocks.RememberTokenModel{},
+		apiTokens:          &mocks.APITokenModel{},
+		teams:              &mocks.TeamModel{},
+		auditEvents:        &mocks.AuditEventModel{},
+		statsCache:         newStatsCache(StatsCacheTTL),
+		templateCache:      templateCache,
+		formDecoder:        formDecoder,
+		sessionManager:     sessionManager,
+		mailer:             &mailer.LogSender{Logger: log.New(io.Discard, "", 0)},
 	}
 }
 
+// recordingSender keeps the messages it is asked to send instead of delivering them.
+type recordingSender struct {
+	recipients []string
+	subjects   []string
+	bodies     []string
+}
+
+func (s *recordingSender) Send(to, subject, body string) error {
+	s.recipients = append(s.recipients, to)
+	s.subjects = append(s.subjects, subject)
+	s.bodies = append(s.bodies, body)
+	return nil
+}
+
+// failingSender fails to send every message, like a mail server that is down.
+type failingSender struct{}
+
+func (s failingSender) Send(string, string, string) error {
+	return errors.New("mail server unavailable")
+}
+
 // A custom testServer type that embeds an httptest.Server instance.
 type testServer struct {
 	*httptest.Server
@@ -129,3 +184,231 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// postMultipart sends a multipart form like postForm, with a file upload named field if file is not nil.
+func (ts *testServer) postMultipart(t *testing.T, urlPath string, form url.Values, field string,
+	file []byte) (int, http.Header, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for key, values := range form {
+		for _, value := range values {
+			if err := mw.WriteField(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if file != nil {
+		fw, err := mw.CreateFormFile(field, "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ts.Client().Post(ts.URL+urlPath, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// requestWithToken sends a request with an "Authorization: Bearer" header and no session cookie, as a script would.
+func (ts *testServer) requestWithToken(t *testing.T, method, urlPath, token string, form url.Values) (int, http.Header,
+	string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := *ts.Client()
+	client.Jar = nil
+
+	rs, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// login makes a GET /user/login request to extract a CSRF token, then logs in with the given credentials so that
+// subsequent requests from the test server client are authenticated. It returns the CSRF token for later forms.
+func (ts *testServer) login(t *testing.T, email, password string) string {
+	t.Helper()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/login", form)
+
+	return csrfToken
+}
+
+// cookie returns the value of the named cookie the test server client holds, or an empty string if it holds none.
+func (ts *testServer) cookie(t *testing.T, name string) string {
+	t.Helper()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+// newBreachedPasswords writes a breached password list in the Have I Been Pwned layout holding the passwords.
+func newBreachedPasswords(t *testing.T, passwords ...string) *validator.BreachedPasswords {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+		f, err := os.OpenFile(filepath.Join(dir, hash[:5]), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.WriteString(hash[5:] + ":42\r\n")
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &validator.BreachedPasswords{Dir: dir}
+}
+
+// ldapEntry is a user in the test LDAP directory.
+type ldapEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// newTestLDAPServer starts an LDAP server on a free local port that lets the entries bind with their passwords and
+// finds them with simple (attribute=value) filters. It returns the server's URL.
+func newTestLDAPServer(t *testing.T, entries []ldapEntry) string {
+	t.Helper()
+
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mux.Bind(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+		defer func() { _ = w.Write(resp) }()
+
+		msg, err := r.GetSimpleBindMessage()
+		if err != nil {
+			return
+		}
+
+		for _, e := range entries {
+			if e.dn == msg.UserName && e.password == string(msg.Password) {
+				resp.SetResultCode(gldap.ResultSuccess)
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mux.Search(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+		defer func() { _ = w.Write(resp) }()
+
+		msg, err := r.GetSearchMessage()
+		if err != nil {
+			resp.SetResultCode(gldap.ResultOperationsError)
+			return
+		}
+
+		attribute, value, _ := strings.Cut(strings.Trim(msg.Filter, "()"), "=")
+
+		for _, e := range entries {
+			for _, v := range e.attributes[attribute] {
+				if strings.EqualFold(v, value) {
+					_ = w.Write(r.NewSearchResponseEntry(e.dn, gldap.WithAttributes(e.attributes)))
+					break
+				}
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Router(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find a free port for the server to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	go func() { _ = server.Run(addr) }()
+	t.Cleanup(func() { _ = server.Stop() })
+
+	for i := 0; !server.Ready(); i++ {
+		if i == 100 {
+			t.Fatal("ldap server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "ldap://" + addr
+}
@@ -0,0 +1,27 @@
+// Package auth checks the passwords users log in with against a backend: the users table or an LDAP directory.
+package auth
+
+import "github.com/mabego/snippetbox-mysql/internal/models"
+
+// Authenticator checks an email address and password and returns the ID of the user they belong to. It returns
+// models.ErrInvalidCredentials if they don't match and models.ErrAccountDisabled if the user is disabled. A backend
+// that decides roles returns models.ErrLastAdmin rather than demote the only admin, and one that provisions users
+// returns models.ErrDuplicateEmail if an unverified user has the email address.
+type Authenticator interface {
+	Authenticate(email, password string) (int, error)
+}
+
+// Provisioner returns the ID of the user with an email address that an external backend vouches for, creating the
+// user if there is none yet. It returns models.ErrDuplicateEmail if an unverified user has the address.
+type Provisioner interface {
+	Provision(name, email, role string) (int, error)
+}
+
+// Database checks passwords against the hashes in the users table.
+type Database struct {
+	Users models.UserModelInterface
+}
+
+func (d *Database) Authenticate(email, password string) (int, error) {
+	return d.Users.Authenticate(email, password)
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// LDAP checks passwords by binding to an LDAP directory as the user. The user's groups give them their role, and
+// they are provisioned in the users table on their first login.
+type LDAP struct {
+	// URL of the directory, such as ldaps://ldap.example.com.
+	URL string
+	// BindDN and BindPassword are a service account to look users up with. Leave them empty to look users up
+	// anonymously.
+	BindDN       string
+	BindPassword string
+	// BaseDN is where to look for users, and UserFilter finds a user by their email address, which replaces %s.
+	BaseDN     string
+	UserFilter string
+	// NameAttribute, EmailAttribute and GroupAttribute name the attributes that hold a user's name, email address
+	// and the DNs of their groups.
+	NameAttribute  string
+	EmailAttribute string
+	GroupAttribute string
+	// Roles maps groups, by their DN or common name, to roles. Users in none of the groups get DefaultRole.
+	Roles       []RoleMapping
+	DefaultRole string
+	Timeout     time.Duration
+	Users       Provisioner
+}
+
+func (l *LDAP) Authenticate(email, password string) (int, error) {
+	// An empty password makes an unauthenticated bind, which many directories accept.
+	if password == "" {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	conn, err := ldap.DialURL(l.URL)
+	if err != nil {
+		return 0, fmt.Errorf("ldap dial: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(l.Timeout)
+
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			return 0, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	request := ldap.NewSearchRequest(l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0,
+		int(l.Timeout.Seconds()), false, fmt.Sprintf(l.UserFilter, ldap.EscapeFilter(email)),
+		[]string{l.NameAttribute, l.EmailAttribute, l.GroupAttribute}, nil)
+
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return 0, fmt.Errorf("ldap search: %w", err)
+	}
+
+	// The email address has to name exactly one user.
+	if result == nil || len(result.Entries) != 1 {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	name := entry.GetAttributeValue(l.NameAttribute)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	if mail := entry.GetAttributeValue(l.EmailAttribute); mail != "" {
+		email = mail
+	}
+
+	role := MapRole(groupNames(entry.GetAttributeValues(l.GroupAttribute)), l.Roles, l.DefaultRole)
+
+	id, err := l.Users.Provision(name, email, role)
+	if err != nil && !errors.Is(err, models.ErrAccountDisabled) && !errors.Is(err, models.ErrLastAdmin) &&
+		!errors.Is(err, models.ErrDuplicateEmail) {
+		return 0, fmt.Errorf("ldap provision: %w", err)
+	}
+
+	return id, err
+}
+
+// groupNames returns each group DN along with the value of its first component, such as "snippetbox-admins" for
+// "cn=snippetbox-admins,ou=groups,dc=example,dc=org", so that roles can be mapped with either.
+func groupNames(dns []string) []string {
+	names := make([]string, 0, 2*len(dns))
+
+	for _, dn := range dns {
+		names = append(names, dn)
+
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+			continue
+		}
+
+		names = append(names, parsed.RDNs[0].Attributes[0].Value)
+	}
+
+	return names
+}
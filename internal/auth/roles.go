@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoleMapping gives users with the group or claim value Value the role Role.
+type RoleMapping struct {
+	Value string
+	Role  string
+}
+
+// ParseRoles parses role mappings written as a comma-separated list of value=role pairs, such as
+// "snippetbox-admins=admin,snippetbox-editors=editor".
+func ParseRoles(s string) ([]RoleMapping, error) {
+	var mappings []RoleMapping
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		value, role, ok := strings.Cut(pair, "=")
+		if !ok || value == "" || role == "" {
+			return nil, fmt.Errorf("auth: invalid role mapping %q", pair)
+		}
+
+		mappings = append(mappings, RoleMapping{Value: value, Role: role})
+	}
+
+	return mappings, nil
+}
+
+// MapRole returns the role of the first mapping that matches one of values, or defaultRole if none does.
+func MapRole(values []string, mappings []RoleMapping, defaultRole string) string {
+	for _, mapping := range mappings {
+		for _, value := range values {
+			if value == mapping.Value {
+				return mapping.Role
+			}
+		}
+	}
+
+	return defaultRole
+}
@@ -0,0 +1,70 @@
+// Package mailer sends email to users through a pluggable Sender. The senders in this package deliver mail
+// locally, to a logger or to files, for development and for deployments without a mail server.
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sender delivers a plain-text email message.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// LogSender writes messages to a logger instead of delivering them.
+type LogSender struct {
+	Logger *log.Logger
+}
+
+func (s *LogSender) Send(to, subject, body string) error {
+	s.Logger.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// FileSender writes each message to its own file in Dir, named after the time it was sent and the recipient, so
+// that links in the messages can be followed during development.
+type FileSender struct {
+	Dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (s *FileSender) Send(to, subject, body string) error {
+	s.mu.Lock()
+	s.n++
+	n := s.n
+	s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	// The counter keeps the names of messages sent within the same second unique.
+	name := fmt.Sprintf("%s-%d-%s.eml", now.Format("20060102T150405"), n, sanitize(to))
+
+	message := fmt.Sprintf("To: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n", to, subject,
+		now.Format(time.RFC1123Z), body)
+
+	if err := os.WriteFile(filepath.Join(s.Dir, name), []byte(message), 0o600); err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+
+	return nil
+}
+
+// sanitize replaces the characters of an email address that do not belong in a file name.
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		default:
+			return '_'
+		}
+	}, address)
+}
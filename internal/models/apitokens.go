@@ -0,0 +1,204 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes an API token can have. Read lets a token view pages, write lets it change snippets and comments, and review
+// lets it submit reviews. A token never has more permissions than its user.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeReview = "review"
+)
+
+// Scopes lists every API token scope.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeReview}
+
+// scopePermissions maps each scope to the permissions it lets a token use.
+var scopePermissions = map[string][]string{
+	ScopeRead: {},
+	ScopeWrite: {
+		PermSnippetCreate, PermSnippetEdit, PermSnippetDelete, PermReviewRequest, PermCommentCreate,
+	},
+	ScopeReview: {PermReviewSubmit},
+}
+
+// APITokenPrefix starts every API token, so that a leaked token is easy to recognize.
+const APITokenPrefix = "sbx_"
+
+type APITokenModelInterface interface {
+	Insert(userID int, name string, scopes []string, expires time.Time) (string, error)
+	Authenticate(token string) (*APIToken, error)
+	List(userID int) ([]*APIToken, error)
+	Revoke(userID, id int) error
+}
+
+// APIToken lets a script act as its user without the user's password. Expires and LastUsed are zero if the token
+// never expires or has not been used.
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// HasScope reports whether the token has the named scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Limit removes the permissions that the token's scopes do not let it use, and returns what is left.
+func (t *APIToken) Limit(permissions map[string]bool) map[string]bool {
+	for permission := range permissions {
+		allowed := false
+		for _, scope := range t.Scopes {
+			if slices.Contains(scopePermissions[scope], permission) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			delete(permissions, permission)
+		}
+	}
+
+	return permissions
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	_, ok := scopePermissions[scope]
+	return ok
+}
+
+// APITokenModel wraps a database connection pool
+type APITokenModel struct {
+	DB *sql.DB
+}
+
+// Insert creates an API token for a user and returns it. Only its hash is stored, so this is the one time the token
+// can be shown. A zero expires means the token never expires.
+func (m *APITokenModel) Insert(userID int, name string, scopes []string, expires time.Time) (string, error) {
+	token, _, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	// The prefix is part of the token, so the hash is of the whole token.
+	token = APITokenPrefix + token
+
+	var expiresAt sql.NullTime
+	if !expires.IsZero() {
+		expiresAt = sql.NullTime{Time: expires.UTC(), Valid: true}
+	}
+
+	statement := `INSERT INTO api_tokens (userID, name, token_hash, scopes, created, expires)
+	VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	_, err = m.DB.Exec(statement, userID, name, hashToken(token), strings.Join(scopes, ","), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Authenticate returns the API token matching token and records that it was used. It returns ErrNoRecord if there
+// is no such token or it has expired.
+func (m *APITokenModel) Authenticate(token string) (*APIToken, error) {
+	query := `SELECT id, userID, name, scopes, created, expires, last_used FROM api_tokens
+	WHERE token_hash = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP())`
+
+	t, err := scanAPIToken(m.DB.QueryRow(query, hashToken(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// List returns the API tokens of a user, including expired ones, newest first.
+func (m *APITokenModel) List(userID int) ([]*APIToken, error) {
+	query := `SELECT id, userID, name, scopes, created, expires, last_used FROM api_tokens
+	WHERE userID = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tokens []*APIToken
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke deletes an API token of a user. It returns ErrNoRecord if the user has no such token.
+func (m *APITokenModel) Revoke(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND userID = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// scanAPIToken reads an API token from a row of id, userID, name, scopes, created, expires and last_used.
+func scanAPIToken(row interface{ Scan(...any) error }) (*APIToken, error) {
+	t := &APIToken{}
+
+	var scopes string
+	var expires, lastUsed sql.NullTime
+
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &expires, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	t.Expires = expires.Time
+	t.LastUsed = lastUsed.Time
+
+	return t, nil
+}
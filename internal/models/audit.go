@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin            = "login"
+	AuditLoginFailed      = "login.failed"
+	AuditSignup           = "signup"
+	AuditPasswordChange   = "password.change"
+	AuditPasswordReset    = "password.reset"
+	AuditRoleChange       = "role.change"
+	AuditUserDisable      = "user.disable"
+	AuditUserEnable       = "user.enable"
+	AuditUserDelete       = "user.delete"
+	AuditTwoFactorDisable = "2fa.disable"
+	AuditSettingChange    = "setting.change"
+	// An admin viewing the site as another user, who is the target.
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationStop  = "impersonation.stop"
+)
+
+// AuditActions lists every action in the audit log, for filtering it.
+var AuditActions = []string{
+	AuditLogin, AuditLoginFailed, AuditSignup, AuditPasswordChange, AuditPasswordReset, AuditRoleChange,
+	AuditUserDisable, AuditUserEnable, AuditUserDelete, AuditTwoFactorDisable, AuditSettingChange,
+	AuditImpersonationStart, AuditImpersonationStop,
+}
+
+type AuditEventModelInterface interface {
+	Insert(event *AuditEvent) error
+	Search(search, action string, limit int) ([]*AuditEvent, error)
+}
+
+// AuditEvent records a security-related action: who did it, to whose account, and from where. Actor and target
+// are kept by ID and email address without a foreign key, so that events outlive the users they name. ActorID is
+// 0 when nobody was logged in, such as for a failed login. ActingAdminID is the admin who was viewing the site as
+// the actor when the event happened, or 0.
+type AuditEvent struct {
+	ID               int
+	ActorID          int
+	ActorEmail       string
+	ActingAdminID    int
+	ActingAdminEmail string
+	Action           string
+	TargetID         int
+	TargetEmail      string
+	Detail           string
+	IP               string
+	UserAgent        string
+	Created          time.Time
+}
+
+// AuditEventModel wraps a database connection pool
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+// Insert appends an event to the audit log. The email addresses of an actor, acting admin and target given by ID
+// are looked up, falling back to those in the event. The table refuses updates and deletes, so the log can only
+// grow.
+func (m *AuditEventModel) Insert(e *AuditEvent) error {
+	statement := `INSERT INTO audit_events (actorID, actor_email, actingAdminID, acting_admin_email, action, targetID,
+		target_email, detail, ip, user_agent, created)
+	VALUES (?, COALESCE((SELECT email FROM users WHERE id = ?), ?), ?,
+		COALESCE((SELECT email FROM users WHERE id = ?), ?), ?, ?,
+		COALESCE((SELECT email FROM users WHERE id = ?), ?), ?, ?, ?, UTC_TIMESTAMP())`
+
+	// Email addresses and details can come straight from a form, such as a failed login, so anything longer than
+	// its column is cut short rather than refused.
+	_, err := m.DB.Exec(statement, nullID(e.ActorID), e.ActorID, truncate(e.ActorEmail, 255),
+		nullID(e.ActingAdminID), e.ActingAdminID, truncate(e.ActingAdminEmail, 255), e.Action, nullID(e.TargetID),
+		e.TargetID, truncate(e.TargetEmail, 255), truncate(e.Detail, 255), e.IP, truncate(e.UserAgent, 255))
+	return err
+}
+
+// Search returns up to limit events, newest first, whose actor, acting admin or target email address, IP address
+// or detail contains search. An empty action matches every action.
+func (m *AuditEventModel) Search(search, action string, limit int) ([]*AuditEvent, error) {
+	pattern := "%" + escapeLike(search) + "%"
+
+	query := `SELECT id, COALESCE(actorID, 0), actor_email, COALESCE(actingAdminID, 0), acting_admin_email, action,
+		COALESCE(targetID, 0), target_email, detail, ip, user_agent, created
+	FROM audit_events
+	WHERE (actor_email LIKE ? OR acting_admin_email LIKE ? OR target_email LIKE ? OR ip LIKE ? OR detail LIKE ?)
+		AND (? = '' OR action = ?)
+	ORDER BY created DESC, id DESC
+	LIMIT ?`
+
+	rows, err := m.DB.Query(query, pattern, pattern, pattern, pattern, pattern, action, action, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+
+	for rows.Next() {
+		e := &AuditEvent{}
+		err = rows.Scan(&e.ID, &e.ActorID, &e.ActorEmail, &e.ActingAdminID, &e.ActingAdminEmail, &e.Action,
+			&e.TargetID, &e.TargetEmail, &e.Detail, &e.IP, &e.UserAgent, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// nullID stores an ID of 0 as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestAuditEventModelInsertLongValues(t *testing.T) {
+	db := newTestDB(t)
+	m := AuditEventModel{DB: db}
+
+	// Anyone can try to log in with an email address longer than the column that records it.
+	email := strings.Repeat("a", 300) + "@example.com"
+
+	err := m.Insert(&AuditEvent{Action: AuditLoginFailed, TargetEmail: email, Detail: strings.Repeat("é", 300),
+		IP: "192.0.2.1", UserAgent: "Firefox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := m.Search("", AuditLoginFailed, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].TargetEmail, email[:255])
+	assert.Equal(t, events[0].Detail, strings.Repeat("é", 255))
+}
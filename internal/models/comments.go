@@ -0,0 +1,255 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CommentMaxDepth is the deepest level at which replies are indented.
+const CommentMaxDepth = 4
+
+// CommentDeletedBody replaces the body of a comment that its author deleted after others replied to it.
+const CommentDeletedBody = "[deleted]"
+
+type CommentModelInterface interface {
+	Insert(snippetID, userID, parentID int, body string) (int, error)
+	Get(id int) (*Comment, error)
+	ForSnippet(snippetID int) ([]*Comment, error)
+	ForUser(userID int) ([]*Comment, error)
+	Update(id int, body string) error
+	Delete(id int) error
+	Retract(id int) error
+}
+
+// Comment is a discussion comment on a snippet with a Markdown body. Replies have the ID of the comment they
+// answer as their ParentID; top-level comments have a ParentID of 0.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	ParentID  int
+	Depth     int
+	Body      string
+	Created   time.Time
+	Updated   time.Time
+}
+
+// CommentModel wraps a database connection pool
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a comment to a snippet. A non-zero parentID makes the comment a reply, and it returns ErrNoRecord if
+// the parent is not a comment on the same snippet.
+func (m *CommentModel) Insert(snippetID, userID, parentID int, body string) (int, error) {
+	var result sql.Result
+	var err error
+
+	if parentID == 0 {
+		statement := `INSERT INTO comments (snippetID, userID, body, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+		result, err = m.DB.Exec(statement, snippetID, userID, body)
+	} else {
+		statement := `
+			INSERT INTO comments (snippetID, userID, parentID, body, created)
+			SELECT snippetID, ?, id, ?, UTC_TIMESTAMP()
+			FROM comments
+			WHERE id = ? AND snippetID = ?`
+
+		result, err = m.DB.Exec(statement, userID, body, parentID, snippetID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, ErrNoRecord
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *CommentModel) Get(id int) (*Comment, error) {
+	c := &Comment{}
+	var parentID sql.NullInt64
+	var updated sql.NullTime
+
+	query := `
+		SELECT comments.id, comments.snippetID, comments.userID, users.name, comments.parentID, comments.body,
+			comments.created, comments.updated
+		FROM comments
+		JOIN users ON users.id = comments.userID
+		WHERE comments.id = ?`
+
+	err := m.DB.QueryRow(query, id).Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &parentID, &c.Body,
+		&c.Created, &updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	c.ParentID = int(parentID.Int64)
+	c.Updated = updated.Time
+
+	return c, nil
+}
+
+// ForSnippet returns the comments on a snippet in thread order: each comment is followed by its replies, oldest
+// first, with Depth set to its level in the thread.
+func (m *CommentModel) ForSnippet(snippetID int) ([]*Comment, error) {
+	query := `
+		SELECT comments.id, comments.snippetID, comments.userID, users.name, comments.parentID, comments.body,
+			comments.created, comments.updated
+		FROM comments
+		JOIN users ON users.id = comments.userID
+		WHERE comments.snippetID = ?
+		ORDER BY comments.created, comments.id`
+
+	rows, err := m.DB.Query(query, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Group the comments by their parent so the threads can be walked from the top-level comments down.
+	replies := make(map[int][]*Comment)
+
+	for rows.Next() {
+		c := &Comment{}
+		var parentID sql.NullInt64
+		var updated sql.NullTime
+
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &parentID, &c.Body, &c.Created, &updated)
+		if err != nil {
+			return nil, err
+		}
+
+		c.ParentID = int(parentID.Int64)
+		c.Updated = updated.Time
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var comments []*Comment
+
+	var walk func(parentID, depth int)
+	walk = func(parentID, depth int) {
+		for _, c := range replies[parentID] {
+			c.Depth = min(depth, CommentMaxDepth)
+			comments = append(comments, c)
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+
+	return comments, nil
+}
+
+// ForUser returns the comments a user wrote on any snippet, oldest first, without thread order or depth.
+func (m *CommentModel) ForUser(userID int) ([]*Comment, error) {
+	query := `
+		SELECT comments.id, comments.snippetID, comments.userID, users.name, comments.parentID, comments.body,
+			comments.created, comments.updated
+		FROM comments
+		JOIN users ON users.id = comments.userID
+		WHERE comments.userID = ?
+		ORDER BY comments.created, comments.id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+
+	for rows.Next() {
+		c := &Comment{}
+		var parentID sql.NullInt64
+		var updated sql.NullTime
+
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &parentID, &c.Body, &c.Created, &updated)
+		if err != nil {
+			return nil, err
+		}
+
+		c.ParentID = int(parentID.Int64)
+		c.Updated = updated.Time
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (m *CommentModel) Update(id int, body string) error {
+	statement := `UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ?`
+
+	_, err := m.DB.Exec(statement, body, id)
+	return err
+}
+
+// Delete removes a comment together with all of its replies. It is for moderators; authors use Retract.
+func (m *CommentModel) Delete(id int) error {
+	statement := `DELETE FROM comments WHERE id = ?`
+
+	_, err := m.DB.Exec(statement, id)
+	return err
+}
+
+// Retract removes a comment for its author. A comment with replies is kept with its body replaced by
+// CommentDeletedBody, so that the replies, which may be other users', stay in the thread.
+func (m *CommentModel) Retract(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Lock the comment so that nobody can reply to it between counting its replies and removing it.
+	var replies bool
+
+	query := `SELECT EXISTS (SELECT true FROM comments replies WHERE replies.parentID = comments.id)
+		FROM comments WHERE id = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, id).Scan(&replies)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if replies {
+		statement := `UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ?`
+		_, err = tx.Exec(statement, CommentDeletedBody, id)
+	} else {
+		statement := `DELETE FROM comments WHERE id = ?`
+		_, err = tx.Exec(statement, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
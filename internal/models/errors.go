@@ -3,7 +3,16 @@ package models
 import "errors"
 
 var (
+	ErrAccountDisabled    = errors.New("models: account disabled")
+	ErrAlreadyRequested   = errors.New("models: review already requested")
+	ErrCannotReview       = errors.New("models: user cannot review")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateTeamName  = errors.New("models: duplicate team name")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
+	ErrLastAdmin          = errors.New("models: last admin")
+	ErrLastMaintainer     = errors.New("models: last team maintainer")
 	ErrNoRecord           = errors.New("models: no matching record found")
+	ErrNoSecretKey        = errors.New("models: no secret key")
+	ErrSelfReview         = errors.New("models: review requested from the requester")
+	ErrTokenReused        = errors.New("models: token already used")
 )
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Kinds of snippet events shown on the snippet timeline.
+const (
+	EventReview = "review"
+	EventEdit   = "edit"
+	EventExpiry = "expiry"
+)
+
+type SnippetEventModelInterface interface {
+	Timeline(snippetID int) ([]*SnippetEvent, error)
+	RecentReviews(userID, limit int) ([]*SnippetEvent, error)
+	ForUser(userID int) ([]*SnippetEvent, error)
+}
+
+// SnippetEvent records something that happened to a snippet and who did it. Events are only ever inserted, by the
+// models that change snippets and reviews, in the same transaction as the change itself. They are kept when a
+// snippet or user is removed so that the history stays available for audits. ActingAdminID is the admin who did it
+// while viewing the site as the user, or 0.
+type SnippetEvent struct {
+	ID              int
+	SnippetID       int
+	SnippetTitle    string
+	UserID          int
+	UserName        string
+	ActingAdminID   int
+	ActingAdminName string
+	Kind            string
+	Detail          string
+	Created         time.Time
+}
+
+// SnippetEventModel wraps a database connection pool
+type SnippetEventModel struct {
+	DB *sql.DB
+}
+
+// insertSnippetEvent adds an event to the snippet timeline as part of the transaction tx.
+func insertSnippetEvent(tx *sql.Tx, snippetID, userID, actingAdminID int, kind, detail string) error {
+	statement := `INSERT INTO snippet_events (snippetID, userID, actingAdminID, kind, detail, created)
+		VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := tx.Exec(statement, snippetID, userID, nullID(actingAdminID), kind, detail)
+	return err
+}
+
+// Timeline returns the events for a snippet in chronological order.
+func (m *SnippetEventModel) Timeline(snippetID int) ([]*SnippetEvent, error) {
+	query := `
+		SELECT e.id, e.snippetID, COALESCE(e.userID, 0), COALESCE(users.name, 'Deleted user'),
+			COALESCE(e.actingAdminID, 0), COALESCE(admins.name, ''), e.kind, e.detail, e.created
+		FROM snippet_events e
+		LEFT JOIN users ON users.id = e.userID
+		LEFT JOIN users admins ON admins.id = e.actingAdminID
+		WHERE e.snippetID = ?
+		ORDER BY e.created, e.id`
+
+	rows, err := m.DB.Query(query, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*SnippetEvent
+
+	for rows.Next() {
+		e := &SnippetEvent{}
+		err = rows.Scan(&e.ID, &e.SnippetID, &e.UserID, &e.UserName, &e.ActingAdminID, &e.ActingAdminName, &e.Kind,
+			&e.Detail, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// RecentReviews returns up to limit of the reviews a user submitted, newest first, with the titles of snippets that
+// still exist.
+func (m *SnippetEventModel) RecentReviews(userID, limit int) ([]*SnippetEvent, error) {
+	query := `
+		SELECT e.id, e.snippetID, COALESCE(snippets.title, ''), e.userID, users.name, e.kind, e.detail, e.created
+		FROM snippet_events e
+		JOIN users ON users.id = e.userID
+		LEFT JOIN snippets ON snippets.id = e.snippetID AND snippets.expires > UTC_TIMESTAMP()
+		WHERE e.userID = ? AND e.kind = ?
+		ORDER BY e.created DESC, e.id DESC
+		LIMIT ?`
+
+	rows, err := m.DB.Query(query, userID, EventReview, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*SnippetEvent
+
+	for rows.Next() {
+		e := &SnippetEvent{}
+		err = rows.Scan(&e.ID, &e.SnippetID, &e.SnippetTitle, &e.UserID, &e.UserName, &e.Kind, &e.Detail,
+			&e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ForUser returns every event a user caused, oldest first, with the titles of snippets that still exist.
+func (m *SnippetEventModel) ForUser(userID int) ([]*SnippetEvent, error) {
+	query := `
+		SELECT e.id, e.snippetID, COALESCE(snippets.title, ''), e.userID, users.name, COALESCE(e.actingAdminID, 0),
+			COALESCE(admins.name, ''), e.kind, e.detail, e.created
+		FROM snippet_events e
+		JOIN users ON users.id = e.userID
+		LEFT JOIN users admins ON admins.id = e.actingAdminID
+		LEFT JOIN snippets ON snippets.id = e.snippetID
+		WHERE e.userID = ?
+		ORDER BY e.created, e.id`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*SnippetEvent
+
+	for rows.Next() {
+		e := &SnippetEvent{}
+		err = rows.Scan(&e.ID, &e.SnippetID, &e.SnippetTitle, &e.UserID, &e.UserName, &e.ActingAdminID,
+			&e.ActingAdminName, &e.Kind, &e.Detail, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
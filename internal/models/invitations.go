@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type InvitationModelInterface interface {
+	Insert(email, role string, createdBy int, ttl time.Duration) (string, error)
+	Get(token string) (*Invitation, error)
+	Pending() ([]*Invitation, error)
+	Resend(id int, ttl time.Duration) (*Invitation, string, error)
+	Revoke(id int) error
+	AcceptWithUser(id int, name, password string) error
+}
+
+// Invitation lets the person at Email sign up with a role through a single-use link.
+type Invitation struct {
+	ID            int
+	Email         string
+	Role          string
+	CreatedBy     int
+	CreatedByName string
+	Created       time.Time
+	Expires       time.Time
+}
+
+// Expired reports whether the invitation link can no longer be used.
+func (i *Invitation) Expired() bool {
+	return time.Now().After(i.Expires)
+}
+
+// InvitationModel wraps a database connection pool
+type InvitationModel struct {
+	DB        *sql.DB
+	Passwords *PasswordHasher
+}
+
+// Insert creates an invitation that expires after ttl and returns the token for the invitation link.
+func (m *InvitationModel) Insert(email, role string, createdBy int, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	statement := `INSERT INTO invitations (email, role, token_hash, createdBy, created, expires)
+		VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(statement, email, role, tokenHash, createdBy, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Get returns the pending, unexpired invitation for a token, or ErrNoRecord.
+func (m *InvitationModel) Get(token string) (*Invitation, error) {
+	i := &Invitation{}
+
+	query := `
+		SELECT invitations.id, invitations.email, invitations.role, invitations.createdBy, users.name,
+			invitations.created, invitations.expires
+		FROM invitations
+		JOIN users ON users.id = invitations.createdBy
+		WHERE invitations.token_hash = ? AND invitations.accepted IS NULL
+		AND invitations.expires > UTC_TIMESTAMP()`
+
+	err := m.DB.QueryRow(query, hashToken(token)).Scan(&i.ID, &i.Email, &i.Role, &i.CreatedBy, &i.CreatedByName,
+		&i.Created, &i.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Pending returns the invitations that have not been accepted, including expired ones that can be resent.
+func (m *InvitationModel) Pending() ([]*Invitation, error) {
+	query := `
+		SELECT invitations.id, invitations.email, invitations.role, invitations.createdBy, users.name,
+			invitations.created, invitations.expires
+		FROM invitations
+		JOIN users ON users.id = invitations.createdBy
+		WHERE invitations.accepted IS NULL
+		ORDER BY invitations.created DESC`
+
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+
+	for rows.Next() {
+		i := &Invitation{}
+		err = rows.Scan(&i.ID, &i.Email, &i.Role, &i.CreatedBy, &i.CreatedByName, &i.Created, &i.Expires)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Resend replaces the token of a pending invitation and extends its expiry by ttl from now, so that only the
+// newest link works. It returns the invitation and the new token.
+func (m *InvitationModel) Resend(id int, ttl time.Duration) (*Invitation, string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	statement := `UPDATE invitations SET token_hash = ?, expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		WHERE id = ? AND accepted IS NULL`
+
+	result, err := m.DB.Exec(statement, tokenHash, int(ttl.Seconds()), id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, "", err
+	}
+	if rows == 0 {
+		return nil, "", ErrNoRecord
+	}
+
+	i, err := m.Get(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return i, token, nil
+}
+
+// Revoke removes a pending invitation so that its link stops working.
+func (m *InvitationModel) Revoke(id int) error {
+	statement := `DELETE FROM invitations WHERE id = ? AND accepted IS NULL`
+
+	_, err := m.DB.Exec(statement, id)
+	return err
+}
+
+// AcceptWithUser marks an invitation as used and creates the invitee's account with its email address and role, in
+// one transaction. The invitation link proves that the invitee reads mail at the invited address, so the account's
+// email address starts out verified. It returns ErrNoRecord if the invitation was already accepted, and
+// ErrDuplicateEmail if an account already has the address, leaving the invitation usable.
+func (m *InvitationModel) AcceptWithUser(id int, name, password string) error {
+	hashedPassword, err := passwordHasher(m.Passwords).Hash(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	statement := `UPDATE invitations SET accepted = UTC_TIMESTAMP() WHERE id = ? AND accepted IS NULL`
+
+	result, err := tx.Exec(statement, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if rows == 0 {
+		tx.Rollback()
+		return ErrNoRecord
+	}
+
+	statement = `INSERT INTO users (name, email, hashed_password, created, role, email_verified_at)
+		SELECT ?, email, ?, UTC_TIMESTAMP(), role, UTC_TIMESTAMP() FROM invitations WHERE id = ?`
+
+	if _, err := tx.Exec(statement, name, hashedPassword, id); err != nil {
+		tx.Rollback()
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return ErrDuplicateEmail
+			}
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestInvitationModelAcceptWithUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping database test")
+	}
+
+	db := newTestDB(t)
+
+	users := &UserModel{DB: db, Passwords: testHasher(AlgorithmArgon2id)}
+
+	err := users.Insert("Alice", "alice@example.com", "pa$$word", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var aliceID int
+	if err := db.QueryRow(`SELECT id FROM users WHERE email = ?`, "alice@example.com").Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &InvitationModel{DB: db, Passwords: testHasher(AlgorithmArgon2id)}
+
+	invite := func(email string) *Invitation {
+		token, err := m.Insert(email, RoleReviewer, aliceID, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		invitation, err := m.Get(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return invitation
+	}
+
+	t.Run("Valid invitation", func(t *testing.T) {
+		invitation := invite("bob@example.com")
+
+		err := m.AcceptWithUser(invitation.ID, "Bob", "pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var role string
+		var verified bool
+		err = db.QueryRow(`SELECT role, email_verified_at IS NOT NULL FROM users WHERE email = ?`,
+			"bob@example.com").Scan(&role, &verified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, role, RoleReviewer)
+		assert.Equal(t, verified, true)
+
+		if _, err := users.Authenticate("bob@example.com", "pa$$word"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The invitation can only be used once.
+		err = m.AcceptWithUser(invitation.ID, "Bob", "pa$$word")
+		assert.Equal(t, err, ErrNoRecord)
+	})
+
+	t.Run("Duplicate email", func(t *testing.T) {
+		invitation := invite("alice@example.com")
+
+		err := m.AcceptWithUser(invitation.ID, "Alice", "pa$$word")
+		assert.Equal(t, err, ErrDuplicateEmail)
+
+		// The failed signup leaves the invitation unaccepted.
+		var accepted bool
+		err = db.QueryRow(`SELECT accepted IS NOT NULL FROM invitations WHERE id = ?`, invitation.ID).Scan(&accepted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, accepted, false)
+	})
+}
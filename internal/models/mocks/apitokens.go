@@ -0,0 +1,70 @@
+package mocks
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// APITokenModel keeps API tokens in memory, so that a token can be created and then used in the same test.
+type APITokenModel struct {
+	tokens map[string]*models.APIToken
+	nextID int
+}
+
+func (m *APITokenModel) Insert(userID int, name string, scopes []string, expires time.Time) (string, error) {
+	if m.tokens == nil {
+		m.tokens = make(map[string]*models.APIToken)
+	}
+
+	m.nextID++
+	token := fmt.Sprintf("%stoken-%d", models.APITokenPrefix, m.nextID)
+	m.tokens[token] = &models.APIToken{
+		ID:      m.nextID,
+		UserID:  userID,
+		Name:    name,
+		Scopes:  scopes,
+		Created: time.Now(),
+		Expires: expires,
+	}
+
+	return token, nil
+}
+
+func (m *APITokenModel) Authenticate(token string) (*models.APIToken, error) {
+	t, ok := m.tokens[token]
+	if !ok || (!t.Expires.IsZero() && t.Expires.Before(time.Now())) {
+		return nil, models.ErrNoRecord
+	}
+
+	t.LastUsed = time.Now()
+
+	return t, nil
+}
+
+func (m *APITokenModel) List(userID int) ([]*models.APIToken, error) {
+	var tokens []*models.APIToken
+
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+
+	return tokens, nil
+}
+
+func (m *APITokenModel) Revoke(userID, id int) error {
+	for token, t := range m.tokens {
+		if t.ID == id && t.UserID == userID {
+			delete(m.tokens, token)
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
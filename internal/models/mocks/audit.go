@@ -0,0 +1,64 @@
+package mocks
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// AuditEventModel keeps the audit log in memory, so that tests can check which events a request recorded. Setting
+// Fail makes Insert fail as if the database were unavailable.
+type AuditEventModel struct {
+	Fail   bool
+	events []*models.AuditEvent
+}
+
+func (m *AuditEventModel) Insert(e *models.AuditEvent) error {
+	if m.Fail {
+		return errors.New("mocks: audit log unavailable")
+	}
+
+	event := *e
+	event.ID = len(m.events) + 1
+	event.Created = time.Now()
+
+	for _, u := range newMockUsers() {
+		if u.ID == event.ActorID {
+			event.ActorEmail = u.Email
+		}
+		if u.ID == event.ActingAdminID {
+			event.ActingAdminEmail = u.Email
+		}
+		if u.ID == event.TargetID {
+			event.TargetEmail = u.Email
+		}
+	}
+
+	m.events = append(m.events, &event)
+
+	return nil
+}
+
+func (m *AuditEventModel) Search(search, action string, limit int) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.events[i]
+
+		if action != "" && e.Action != action {
+			continue
+		}
+
+		if !strings.Contains(e.ActorEmail, search) && !strings.Contains(e.ActingAdminEmail, search) &&
+			!strings.Contains(e.TargetEmail, search) && !strings.Contains(e.IP, search) &&
+			!strings.Contains(e.Detail, search) {
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,138 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// CommentModel serves the mock comments less any that were deleted, so that tests can check what a deletion left
+// behind.
+type CommentModel struct {
+	deleted   map[int]bool
+	retracted map[int]bool
+}
+
+// newMockComments creates a thread of comments on the mock snippet. The first reply is written by a user other than
+// the mock user, who answers it in turn.
+func newMockComments() []*models.Comment {
+	return []*models.Comment{
+		{
+			ID:        1,
+			SnippetID: 1,
+			UserID:    1,
+			UserName:  "Alice",
+			Body:      "A **frog** jumps into the pond",
+			Created:   time.Now(),
+		},
+		{
+			ID:        2,
+			SnippetID: 1,
+			UserID:    2,
+			UserName:  "Bob",
+			ParentID:  1,
+			Depth:     1,
+			Body:      "Splash! Silence again.",
+			Created:   time.Now(),
+		},
+		{
+			ID:        3,
+			SnippetID: 1,
+			UserID:    1,
+			UserName:  "Alice",
+			ParentID:  2,
+			Depth:     2,
+			Body:      "The ripples fade",
+			Created:   time.Now(),
+		},
+	}
+}
+
+// comments returns the mock comments that have not been deleted, with the bodies of retracted comments replaced.
+func (m *CommentModel) comments() []*models.Comment {
+	var comments []*models.Comment
+
+	for _, c := range newMockComments() {
+		if m.deleted[c.ID] {
+			continue
+		}
+		if m.retracted[c.ID] {
+			c.Body = models.CommentDeletedBody
+		}
+		comments = append(comments, c)
+	}
+
+	return comments
+}
+
+func (m *CommentModel) Insert(snippetID, _, parentID int, _ string) (int, error) {
+	if snippetID != 1 || (parentID != 0 && parentID != 1 && parentID != 2 && parentID != 3) {
+		return 0, models.ErrNoRecord
+	}
+
+	return 4, nil
+}
+
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	for _, c := range m.comments() {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	if snippetID == 1 {
+		return m.comments(), nil
+	}
+
+	return nil, nil
+}
+
+func (m *CommentModel) ForUser(userID int) ([]*models.Comment, error) {
+	var comments []*models.Comment
+
+	for _, c := range m.comments() {
+		if c.UserID == userID {
+			c.Depth = 0
+			comments = append(comments, c)
+		}
+	}
+
+	return comments, nil
+}
+
+func (m *CommentModel) Update(int, string) error { return nil }
+
+// Delete removes a comment and, as the database cascades, its replies.
+func (m *CommentModel) Delete(id int) error {
+	if m.deleted == nil {
+		m.deleted = make(map[int]bool)
+	}
+
+	// Replies follow the comments they answer, so a single pass reaches the whole thread.
+	for _, c := range newMockComments() {
+		if c.ID == id || m.deleted[c.ParentID] {
+			m.deleted[c.ID] = true
+		}
+	}
+
+	return nil
+}
+
+// Retract keeps a comment with replies as a placeholder and deletes any other comment.
+func (m *CommentModel) Retract(id int) error {
+	for _, c := range m.comments() {
+		if c.ParentID == id {
+			if m.retracted == nil {
+				m.retracted = make(map[int]bool)
+			}
+			m.retracted[id] = true
+			return nil
+		}
+	}
+
+	return m.Delete(id)
+}
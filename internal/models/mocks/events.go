@@ -0,0 +1,71 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+type SnippetEventModel struct{}
+
+func (m *SnippetEventModel) Timeline(snippetID int) ([]*models.SnippetEvent, error) {
+	if snippetID != 1 {
+		return nil, nil
+	}
+
+	events := []*models.SnippetEvent{
+		{
+			ID:        1,
+			SnippetID: 1,
+			UserID:    1,
+			UserName:  "Alice",
+			Kind:      models.EventEdit,
+			Created:   time.Now().Add(-time.Hour),
+		},
+		{
+			ID:        2,
+			SnippetID: 1,
+			UserID:    1,
+			UserName:  "Alice",
+			Kind:      models.EventReview,
+			Detail:    models.VerdictApproved,
+			Created:   time.Now(),
+		},
+	}
+
+	return events, nil
+}
+
+func (m *SnippetEventModel) RecentReviews(userID, _ int) ([]*models.SnippetEvent, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+
+	events := []*models.SnippetEvent{
+		{
+			ID:           2,
+			SnippetID:    1,
+			SnippetTitle: "An old silent pond",
+			UserID:       1,
+			UserName:     "Alice",
+			Kind:         models.EventReview,
+			Detail:       models.VerdictApproved,
+			Created:      time.Now(),
+		},
+	}
+
+	return events, nil
+}
+
+func (m *SnippetEventModel) ForUser(userID int) ([]*models.SnippetEvent, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+
+	events, _ := m.Timeline(1)
+	for _, e := range events {
+		e.SnippetTitle = "An old silent pond"
+	}
+
+	return events, nil
+}
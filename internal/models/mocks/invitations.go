@@ -0,0 +1,56 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+type InvitationModel struct{}
+
+// newMockInvitation creates an instance of the Invitation struct with mock data.
+func newMockInvitation() *models.Invitation {
+	return &models.Invitation{
+		ID:            1,
+		Email:         "bob@example.com",
+		Role:          models.RoleReviewer,
+		CreatedBy:     1,
+		CreatedByName: "Alice",
+		Created:       time.Now(),
+		Expires:       time.Now().Add(24 * time.Hour),
+	}
+}
+
+func (m *InvitationModel) Insert(string, string, int, time.Duration) (string, error) {
+	return "valid-token", nil
+}
+
+func (m *InvitationModel) Get(token string) (*models.Invitation, error) {
+	if token == "valid-token" {
+		return newMockInvitation(), nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *InvitationModel) Pending() ([]*models.Invitation, error) {
+	return []*models.Invitation{newMockInvitation()}, nil
+}
+
+func (m *InvitationModel) Resend(id int, _ time.Duration) (*models.Invitation, string, error) {
+	if id == 1 {
+		return newMockInvitation(), "valid-token", nil
+	}
+
+	return nil, "", models.ErrNoRecord
+}
+
+func (m *InvitationModel) Revoke(int) error { return nil }
+
+func (m *InvitationModel) AcceptWithUser(id int, _, _ string) error {
+	if id == 1 {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
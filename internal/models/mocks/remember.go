@@ -0,0 +1,88 @@
+package mocks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+type rememberToken struct {
+	userID int
+	family string
+	used   time.Time
+	next   string
+}
+
+// RememberTokenModel keeps remember tokens in memory, so that rotation and replays can be tested end to end.
+type RememberTokenModel struct {
+	tokens map[string]*rememberToken
+	next   int
+}
+
+func (m *RememberTokenModel) newToken(userID int, family string) string {
+	if m.tokens == nil {
+		m.tokens = make(map[string]*rememberToken)
+	}
+
+	m.next++
+	token := fmt.Sprintf("remember-token-%d", m.next)
+	m.tokens[token] = &rememberToken{userID: userID, family: family}
+
+	return token
+}
+
+func (m *RememberTokenModel) Insert(userID int, _ time.Duration) (string, string, error) {
+	family := fmt.Sprintf("family-%d", m.next+1)
+	return family, m.newToken(userID, family), nil
+}
+
+func (m *RememberTokenModel) Use(token string) (int, string, string, error) {
+	t, ok := m.tokens[token]
+	if !ok {
+		return 0, "", "", models.ErrNoRecord
+	}
+
+	if !t.used.IsZero() {
+		next, ok := m.tokens[t.next]
+		if ok && next.used.IsZero() && time.Since(t.used) < models.RememberGracePeriod {
+			return t.userID, t.family, "", nil
+		}
+
+		_ = m.DeleteFamily(t.family)
+		return t.userID, t.family, "", models.ErrTokenReused
+	}
+
+	t.used = time.Now()
+	t.next = m.newToken(t.userID, t.family)
+	return t.userID, t.family, t.next, nil
+}
+
+// Age makes every used token look as if it was used d ago.
+func (m *RememberTokenModel) Age(d time.Duration) {
+	for _, t := range m.tokens {
+		if !t.used.IsZero() {
+			t.used = t.used.Add(-d)
+		}
+	}
+}
+
+func (m *RememberTokenModel) DeleteFamily(family string) error {
+	for token, t := range m.tokens {
+		if t.family == family {
+			delete(m.tokens, token)
+		}
+	}
+
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAll(userID int, except string) error {
+	for token, t := range m.tokens {
+		if t.userID == userID && t.family != except {
+			delete(m.tokens, token)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// ReviewRequestModel keeps the reminders and escalations it has claimed in memory, so that a claim cleared after a
+// failed send is offered again.
+type ReviewRequestModel struct {
+	reminded  map[int]bool
+	escalated map[int]bool
+}
+
+// newMockReviewRequest creates an instance of the ReviewRequest struct with mock data that is overdue.
+func newMockReviewRequest() *models.ReviewRequest {
+	return &models.ReviewRequest{
+		ID:             1,
+		SnippetID:      1,
+		SnippetTitle:   "An old silent pond",
+		RequesterID:    1,
+		RequesterName:  "Alice",
+		RequesterEmail: "alice@example.com",
+		ReviewerID:     1,
+		ReviewerName:   "Alice",
+		ReviewerEmail:  "alice@example.com",
+		Created:        time.Now().Add(-48 * time.Hour),
+		Due:            time.Now().Add(-24 * time.Hour),
+	}
+}
+
+// Insert asks a mock user to review the mock snippet or the Platform team's snippet. Dave already has an open
+// request for the mock snippet.
+func (m *ReviewRequestModel) Insert(snippetID, requesterID int, reviewerEmail string, _ time.Time) (int, error) {
+	if snippetID != 1 && snippetID != 3 {
+		return 0, models.ErrNoRecord
+	}
+
+	for _, u := range newMockUsers() {
+		if u.Email != reviewerEmail {
+			continue
+		}
+
+		switch {
+		case u.ID == requesterID:
+			return 0, models.ErrSelfReview
+		case !models.Permissions(u.Role)[models.PermReviewSubmit]:
+			return 0, models.ErrCannotReview
+		case snippetID == 1 && u.ID == 4:
+			return 0, models.ErrAlreadyRequested
+		}
+
+		return 1, nil
+	}
+
+	return 0, models.ErrNoRecord
+}
+
+// InsertTeam asks the other members of a team the requester belongs to, or of the Platform team for its snippet.
+func (m *ReviewRequestModel) InsertTeam(snippetID, requesterID, teamID int, _ time.Time) (int, error) {
+	if snippetID != 1 && snippetID != 3 {
+		return 0, models.ErrNoRecord
+	}
+
+	member := snippetID == 3 && teamID == 1
+	count := 0
+
+	for _, tm := range mockTeamMembers {
+		if tm.TeamID != teamID {
+			continue
+		}
+
+		if tm.UserID == requesterID {
+			member = true
+		} else {
+			count++
+		}
+	}
+
+	if !member || count == 0 {
+		return 0, models.ErrNoRecord
+	}
+
+	return count, nil
+}
+
+func (m *ReviewRequestModel) Complete(_, _ int, _ string) error { return nil }
+
+func (m *ReviewRequestModel) ForSnippet(snippetID int) ([]*models.ReviewRequest, error) {
+	if snippetID == 1 {
+		return []*models.ReviewRequest{newMockReviewRequest()}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *ReviewRequestModel) Queue(reviewerID int) ([]*models.ReviewRequest, error) {
+	if reviewerID == 1 {
+		return []*models.ReviewRequest{newMockReviewRequest()}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *ReviewRequestModel) ForUser(userID int) ([]*models.ReviewRequest, error) {
+	if userID == 1 {
+		return []*models.ReviewRequest{newMockReviewRequest()}, nil
+	}
+
+	return nil, nil
+}
+
+// DueSoon returns two requests that fall due within the hour, less those already reminded.
+func (m *ReviewRequestModel) DueSoon(time.Duration) ([]*models.ReviewRequest, error) {
+	var requests []*models.ReviewRequest
+
+	for _, id := range []int{2, 3} {
+		if m.reminded[id] {
+			continue
+		}
+
+		rr := newMockReviewRequest()
+		rr.ID = id
+		rr.Due = time.Now().Add(time.Hour)
+		requests = append(requests, rr)
+	}
+
+	return requests, nil
+}
+
+func (m *ReviewRequestModel) Overdue() ([]*models.ReviewRequest, error) {
+	if m.escalated[1] {
+		return nil, nil
+	}
+
+	return []*models.ReviewRequest{newMockReviewRequest()}, nil
+}
+
+func (m *ReviewRequestModel) MarkReminded(id int) (bool, error) {
+	return claimOnce(&m.reminded, id), nil
+}
+
+func (m *ReviewRequestModel) MarkEscalated(id int) (bool, error) {
+	return claimOnce(&m.escalated, id), nil
+}
+
+func (m *ReviewRequestModel) ClearReminded(id int) error {
+	delete(m.reminded, id)
+	return nil
+}
+
+func (m *ReviewRequestModel) ClearEscalated(id int) error {
+	delete(m.escalated, id)
+	return nil
+}
+
+func claimOnce(claimed *map[int]bool, id int) bool {
+	if *claimed == nil {
+		*claimed = make(map[int]bool)
+	}
+	if (*claimed)[id] {
+		return false
+	}
+
+	(*claimed)[id] = true
+	return true
+}
@@ -0,0 +1,46 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+type PasswordResetModel struct{}
+
+// Insert returns a token for Alice, the only user with a reset in Get, and another for the other mock users.
+func (m *PasswordResetModel) Insert(email string, _ time.Duration) (string, error) {
+	if email == "alice@example.com" {
+		return "valid-token", nil
+	}
+
+	for _, u := range newMockUsers() {
+		if u.Email == email {
+			return "other-token", nil
+		}
+	}
+
+	return "", models.ErrNoRecord
+}
+
+func (m *PasswordResetModel) Get(token string) (*models.PasswordReset, error) {
+	if token == "valid-token" {
+		return &models.PasswordReset{
+			ID:      1,
+			UserID:  1,
+			Email:   "alice@example.com",
+			Created: time.Now(),
+			Expires: time.Now().Add(time.Hour),
+		}, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *PasswordResetModel) Reset(token, _ string) (int, error) {
+	if token == "valid-token" {
+		return 1, nil
+	}
+
+	return 0, models.ErrNoRecord
+}
@@ -1,8 +1,15 @@
 package mocks
 
-import "github.com/mabego/snippetbox-mysql/internal/models"
+import (
+	"time"
 
-type ReviewModel struct{}
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// ReviewModel keeps the acting admin of the last Update, so that tests can check who a review is recorded for.
+type ReviewModel struct {
+	ActingAdminID int
+}
 
 const reviews = 5
 
@@ -28,4 +35,24 @@ func (m *ReviewModel) Get(_, snippetID int) (*models.Review, error) {
 	}
 }
 
-func (m *ReviewModel) Update(_, _ int) error { return nil }
+func (m *ReviewModel) Update(_, snippetID, actingAdminID int, _ string) error {
+	if snippetID != 1 && snippetID != 3 {
+		return models.ErrNoRecord
+	}
+
+	m.ActingAdminID = actingAdminID
+
+	return nil
+}
+
+func (m *ReviewModel) Stats(time.Time) ([]*models.ReviewerStats, error) {
+	s := &models.ReviewerStats{
+		UserID:          1,
+		Name:            "Alice",
+		Reviews:         4,
+		Approved:        3,
+		AvgTimeToReview: 26 * time.Hour,
+	}
+
+	return []*models.ReviewerStats{s}, nil
+}
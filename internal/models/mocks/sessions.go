@@ -0,0 +1,90 @@
+package mocks
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// UserSessionModel keeps the session index in memory, so that revoking a session can be tested end to end.
+type UserSessionModel struct {
+	sessions map[string]*models.UserSession
+	nextID   int
+}
+
+func (m *UserSessionModel) Touch(token string, userID int, ip, userAgent, rememberFamily string,
+	expires time.Time) error {
+	if m.sessions == nil {
+		m.sessions = make(map[string]*models.UserSession)
+	}
+
+	now := time.Now()
+
+	if s, ok := m.sessions[token]; ok {
+		s.IP = ip
+		s.UserAgent = userAgent
+		s.LastSeen = now
+		return nil
+	}
+
+	m.nextID++
+	m.sessions[token] = &models.UserSession{
+		ID:             m.nextID,
+		UserID:         userID,
+		Token:          token,
+		IP:             ip,
+		UserAgent:      userAgent,
+		RememberFamily: rememberFamily,
+		Created:        now,
+		LastSeen:       now,
+		Expires:        expires,
+	}
+
+	return nil
+}
+
+func (m *UserSessionModel) List(userID int, current string) ([]*models.UserSession, error) {
+	var sessions []*models.UserSession
+
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			c := *s
+			c.Current = s.Token == current
+			sessions = append(sessions, &c)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
+	return sessions, nil
+}
+
+func (m *UserSessionModel) Delete(userID, id int) (*models.UserSession, error) {
+	for token, s := range m.sessions {
+		if s.ID == id && s.UserID == userID {
+			delete(m.sessions, token)
+			return s, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserSessionModel) DeleteToken(token string) error {
+	delete(m.sessions, token)
+	return nil
+}
+
+func (m *UserSessionModel) DeleteAll(userID int) ([]string, error) {
+	var tokens []string
+
+	for token, s := range m.sessions {
+		if s.UserID == userID {
+			tokens = append(tokens, token)
+			delete(m.sessions, token)
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,19 @@
+package mocks
+
+// SettingModel keeps settings in memory, so that tests can change them.
+type SettingModel struct {
+	values map[string]bool
+}
+
+func (m *SettingModel) Bool(name string) (bool, error) {
+	return m.values[name], nil
+}
+
+func (m *SettingModel) SetBool(name string, value bool) error {
+	if m.values == nil {
+		m.values = make(map[string]bool)
+	}
+
+	m.values[name] = value
+	return nil
+}
@@ -6,33 +6,91 @@ import (
 	"github.com/mabego/snippetbox-mysql/internal/models"
 )
 
-type SnippetModel struct{}
+// SnippetModel counts how often Get reads a snippet, so that tests can check that a request reads it once, and
+// keeps the acting admin of the last Update.
+type SnippetModel struct {
+	Gets          int
+	ActingAdminID int
+}
 
 // newMockSnippet creates an instance of the Snippet struct with mock data.
 func newMockSnippet() *models.Snippet {
 	return &models.Snippet{
 		ID:      1,
+		UserID:  1,
 		Title:   "An old silent pond",
 		Content: "An old silent pond...",
 		Created: time.Now(),
 		Expires: time.Now(),
+		Stars:   3,
+	}
+}
+
+// newMockTeamSnippet creates a snippet that Bob wrote for the Platform team.
+func newMockTeamSnippet() *models.Snippet {
+	return &models.Snippet{
+		ID:       3,
+		UserID:   2,
+		TeamID:   1,
+		TeamName: "Platform",
+		Title:    "Deploy checklist",
+		Content:  "Tag the release, then roll out.",
+		Created:  time.Now(),
+		Expires:  time.Now(),
 	}
 }
 
-func (m *SnippetModel) Insert(string, string, int) (int, error) {
+func (m *SnippetModel) Insert(int, int, string, string, int) (int, error) {
 	mockID := 2
 	return mockID, nil
 }
 
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	m.Gets++
+
 	switch id {
 	case 1:
 		return newMockSnippet(), nil
+	case 3:
+		return newMockTeamSnippet(), nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+func (m *SnippetModel) Latest(string) ([]*models.Snippet, error) {
 	return []*models.Snippet{newMockSnippet()}, nil
 }
+
+func (m *SnippetModel) ForUser(userID int) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{newMockSnippet()}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *SnippetModel) ForTeam(teamID int) ([]*models.Snippet, error) {
+	if teamID == 1 {
+		return []*models.Snippet{newMockTeamSnippet()}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *SnippetModel) Update(id, _, actingAdminID int, _, _ string, _ int) error {
+	if id == 1 || id == 3 {
+		m.ActingAdminID = actingAdminID
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	if id == 1 || id == 3 {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
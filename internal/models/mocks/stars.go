@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+type StarModel struct{}
+
+func (m *StarModel) Set(_, _ int, _ bool) error { return nil }
+
+func (m *StarModel) Exists(userID, snippetID int) (bool, error) {
+	return userID == 1 && snippetID == 1, nil
+}
+
+func (m *StarModel) Favorites(userID int) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{newMockSnippet()}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *StarModel) ForUser(userID int) ([]*models.Star, error) {
+	if userID == 1 {
+		return []*models.Star{{SnippetID: 1, SnippetTitle: "An old silent pond", Created: time.Now()}}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,102 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// TeamModel has two teams: Platform, which Alice maintains and Bob is a member of, and Security, which Dave
+// maintains.
+type TeamModel struct{}
+
+var mockTeams = []*models.Team{
+	{ID: 1, Name: "Platform", Members: 2},
+	{ID: 2, Name: "Security", Members: 1},
+}
+
+var mockTeamMembers = []*models.TeamMember{
+	{TeamID: 1, UserID: 1, Name: "Alice", Email: "alice@example.com", Role: models.TeamRoleMaintainer},
+	{TeamID: 1, UserID: 2, Name: "Bob", Email: "bob@example.com", Role: models.TeamRoleMember},
+	{TeamID: 2, UserID: 4, Name: "Dave", Email: "dave@example.com", Role: models.TeamRoleMaintainer},
+}
+
+func (m *TeamModel) Insert(name string, _ int) (int, error) {
+	for _, t := range mockTeams {
+		if t.Name == name {
+			return 0, models.ErrDuplicateTeamName
+		}
+	}
+
+	return 3, nil
+}
+
+func (m *TeamModel) Get(id int) (*models.Team, error) {
+	for _, t := range mockTeams {
+		if t.ID == id {
+			c := *t
+			c.Created = time.Now()
+			return &c, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *TeamModel) List(userID int) ([]*models.Team, error) {
+	var teams []*models.Team
+
+	for _, t := range mockTeams {
+		c := *t
+		c.Role, _ = m.Role(t.ID, userID)
+		teams = append(teams, &c)
+	}
+
+	return teams, nil
+}
+
+func (m *TeamModel) Members(teamID int) ([]*models.TeamMember, error) {
+	var members []*models.TeamMember
+
+	for _, tm := range mockTeamMembers {
+		if tm.TeamID == teamID {
+			members = append(members, tm)
+		}
+	}
+
+	return members, nil
+}
+
+func (m *TeamModel) Role(teamID, userID int) (string, error) {
+	for _, tm := range mockTeamMembers {
+		if tm.TeamID == teamID && tm.UserID == userID {
+			return tm.Role, nil
+		}
+	}
+
+	return "", models.ErrNoRecord
+}
+
+func (m *TeamModel) AddMember(teamID int, email, role string) error {
+	if email == "nobody@example.com" {
+		return models.ErrNoRecord
+	}
+
+	if teamID == 1 && email == "alice@example.com" && role != models.TeamRoleMaintainer {
+		return models.ErrLastMaintainer
+	}
+
+	return nil
+}
+
+func (m *TeamModel) RemoveMember(teamID, userID int) error {
+	if teamID == 1 && userID == 1 {
+		return models.ErrLastMaintainer
+	}
+
+	if _, err := m.Role(teamID, userID); err != nil {
+		return err
+	}
+
+	return nil
+}
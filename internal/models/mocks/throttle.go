@@ -0,0 +1,34 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// LoginThrottleModel counts failures per account in memory and locks an account for a minute once it has too many.
+type LoginThrottleModel struct {
+	failures map[string]int
+}
+
+func (m *LoginThrottleModel) LockedUntil(_, email string) (time.Time, error) {
+	if m.failures[email] > models.AccountFreeFailures {
+		return time.Now().Add(time.Minute), nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (m *LoginThrottleModel) Fail(ip, email string) (time.Time, error) {
+	if m.failures == nil {
+		m.failures = make(map[string]int)
+	}
+
+	m.failures[email]++
+	return m.LockedUntil(ip, email)
+}
+
+func (m *LoginThrottleModel) Reset(email string) error {
+	delete(m.failures, email)
+	return nil
+}
@@ -0,0 +1,24 @@
+package mocks
+
+// TwoFactorModel is available unless Unavailable is set, as if the server had no key for TOTP secrets.
+type TwoFactorModel struct {
+	Unavailable bool
+}
+
+func (m *TwoFactorModel) Available() bool { return !m.Unavailable }
+
+func (m *TwoFactorModel) Enable(int, string) ([]string, error) {
+	return []string{"abcde-fghij", "klmno-pqrst"}, nil
+}
+
+func (m *TwoFactorModel) Disable(int) error { return nil }
+
+func (m *TwoFactorModel) Validate(userID int, code string) (bool, error) {
+	return userID == 4 && code == "123456", nil
+}
+
+func (m *TwoFactorModel) Recover(userID int, code string) (bool, error) {
+	return userID == 4 && code == "abcde-fghij", nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(int) (int, error) { return 8, nil }
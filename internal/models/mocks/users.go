@@ -1,14 +1,43 @@
 package mocks
 
 import (
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/mabego/snippetbox-mysql/internal/models"
 )
 
-type UserModel struct{}
+// UserModel serves the mock users below, along with any users provisioned by single sign-on, less any who deleted
+// their accounts and with any roles single sign-on or an admin gave them. Users whose password an admin reset and
+// disabled users can't log in or keep their sessions.
+type UserModel struct {
+	provisioned   []*models.User
+	deleted       []int
+	resetRequired []int
+	disabled      []int
+	roles         map[int]string
+}
+
+// users returns the mock users and the provisioned users.
+func (m *UserModel) users() []*models.User {
+	var users []*models.User
+
+	for _, u := range append(newMockUsers(), m.provisioned...) {
+		if !slices.Contains(m.deleted, u.ID) {
+			u.PasswordResetRequired = slices.Contains(m.resetRequired, u.ID)
+			u.Disabled = slices.Contains(m.disabled, u.ID)
+			if role, ok := m.roles[u.ID]; ok {
+				u.Role = role
+			}
+			users = append(users, u)
+		}
+	}
 
-func (m *UserModel) Insert(_, email, _ string, _ bool) error {
+	return users
+}
+
+func (m *UserModel) Insert(_, email, _, _ string) error {
 	switch email {
 	case "dupe@example.com":
 		return models.ErrDuplicateEmail
@@ -18,46 +47,136 @@ func (m *UserModel) Insert(_, email, _ string, _ bool) error {
 }
 
 func (m *UserModel) Authenticate(email, password string) (int, error) {
+	for _, u := range m.users() {
+		if u.Email == email && u.PasswordResetRequired {
+			return 0, models.ErrInvalidCredentials
+		}
+		if u.Email == email && u.Disabled {
+			return 0, models.ErrAccountDisabled
+		}
+	}
+
 	if email == "alice@example.com" && password == "pa$$word" {
 		return 1, nil
 	}
 
+	if email == "bob@example.com" && password == "pa$$word" {
+		return 2, nil
+	}
+
+	if email == "carol@example.com" && password == "pa$$word" {
+		return 3, nil
+	}
+
+	if email == "dave@example.com" && password == "pa$$word" {
+		return 4, nil
+	}
+
 	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Authorize(id int) (bool, error) {
-	if id == 1 {
-		return true, nil
+func (m *UserModel) Role(id int) (string, bool, error) {
+	for _, u := range m.users() {
+		if u.ID == id {
+			return u.Role, !u.EmailVerified.IsZero(), nil
+		}
 	}
-	return false, nil
+
+	return "", false, models.ErrNoRecord
 }
 
 func (m *UserModel) Exists(id int) (bool, error) {
-	switch id {
-	case 1:
-		return true, nil
-	default:
-		return false, nil
+	for _, u := range m.users() {
+		if u.ID == id {
+			return !u.PasswordResetRequired && !u.Disabled, nil
+		}
 	}
+
+	return false, nil
 }
 
-func (m *UserModel) Get(id int) (*models.User, error) {
-	if id == 1 {
-		u := &models.User{
-			ID:      1,
-			Name:    "Alice",
-			Email:   "alice@example.com",
+// newMockUsers creates an admin, Alice, a reviewer, Bob, an editor, Carol, who has not verified their email
+// address, and a reviewer, Dave, who uses two-factor authentication, with mock data. All of them log in with the
+// password "pa$$word".
+func newMockUsers() []*models.User {
+	return []*models.User{
+		{
+			ID:            1,
+			Name:          "Alice",
+			Email:         "alice@example.com",
+			Created:       time.Now(),
+			Role:          models.RoleAdmin,
+			EmailVerified: time.Now(),
+			Bio:           "Writes haiku about ponds.",
+			HasAvatar:     true,
+		},
+		{
+			ID:            2,
+			Name:          "Bob",
+			Email:         "bob@example.com",
+			Created:       time.Now(),
+			Role:          models.RoleReviewer,
+			EmailVerified: time.Now(),
+		},
+		{
+			ID:      3,
+			Name:    "Carol",
+			Email:   "carol@example.com",
 			Created: time.Now(),
-			Owner:   true,
-		}
+			Role:    models.RoleEditor,
+		},
+		{
+			ID:               4,
+			Name:             "Dave",
+			Email:            "dave@example.com",
+			Created:          time.Now(),
+			Role:             models.RoleReviewer,
+			EmailVerified:    time.Now(),
+			TwoFactorEnabled: true,
+		},
+	}
+}
 
-		return u, nil
+func (m *UserModel) Get(id int) (*models.User, error) {
+	for _, u := range m.users() {
+		if u.ID == id {
+			return u, nil
+		}
 	}
 
 	return nil, models.ErrNoRecord
 }
 
-func (m *UserModel) Owner() (bool, error) { return false, nil }
+func (m *UserModel) AdminExists() (bool, error) { return false, nil }
+
+// UpdateProfile reports a change of email address for any address other than the user's own, except for
+// dupe@example.com, which is taken.
+func (m *UserModel) UpdateProfile(id int, _, email, _ string) (bool, error) {
+	user, err := m.Get(id)
+	if err != nil {
+		return false, err
+	}
+
+	if email == "dupe@example.com" {
+		return false, models.ErrDuplicateEmail
+	}
+
+	return !strings.EqualFold(email, user.Email), nil
+}
+
+func (m *UserModel) SetAvatar(int, []byte, string) error { return nil }
+
+// mockAvatar is the start of a PNG image, enough for its content type to be detected.
+var mockAvatar = []byte("\x89PNG\r\n\x1a\n")
+
+// Avatar returns an image for Alice, the only mock user with an avatar.
+func (m *UserModel) Avatar(id int) ([]byte, string, error) {
+	if id == 1 {
+		return mockAvatar, "image/png", nil
+	}
+
+	return nil, "", models.ErrNoRecord
+}
 
 func (m *UserModel) PasswordUpdate(id int, currentPassword, _ string) error {
 	if id == 1 {
@@ -70,3 +189,114 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, _ string) error {
 
 	return models.ErrNoRecord
 }
+
+func (m *UserModel) List(search string) ([]*models.User, error) {
+	var users []*models.User
+
+	for _, u := range newMockUsers() {
+		if strings.Contains(u.Name, search) || strings.Contains(u.Email, search) {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	if m.roles == nil {
+		m.roles = make(map[int]string)
+	}
+	m.roles[id] = role
+
+	return nil
+}
+
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	m.disabled = slices.DeleteFunc(m.disabled, func(d int) bool { return d == id })
+	if disabled {
+		m.disabled = append(m.disabled, id)
+	}
+
+	return nil
+}
+
+func (m *UserModel) RequirePasswordReset(id int) error {
+	m.resetRequired = append(m.resetRequired, id)
+	return nil
+}
+
+func (m *UserModel) Delete(int) error { return nil }
+
+// DeleteAccount refuses to delete Alice, the only admin, and only reassigns snippets to another mock user.
+func (m *UserModel) DeleteAccount(id int, reassignTo string) error {
+	if id == 1 {
+		return models.ErrLastAdmin
+	}
+
+	if reassignTo != "" {
+		found := false
+		for _, u := range m.users() {
+			if u.Email == reassignTo && u.ID != id {
+				found = true
+			}
+		}
+		if !found {
+			return models.ErrNoRecord
+		}
+	}
+
+	m.deleted = append(m.deleted, id)
+	return nil
+}
+
+// ProvisionOIDC provisions users the same way as Provision.
+func (m *UserModel) ProvisionOIDC(_, name, email, role string) (int, error) {
+	return m.Provision(name, email, role)
+}
+
+// onlyAdmin reports whether the user id is the only admin.
+func (m *UserModel) onlyAdmin(id int) bool {
+	for _, u := range m.users() {
+		if u.Role == models.RoleAdmin && !u.Disabled && u.ID != id {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Provision links the mock users by their email address and gives them role, except that it refuses to demote
+// Alice, the only admin, and to link Carol, who hasn't verified their email address. Anyone else is provisioned as
+// a new user with an ID from 100 up, and keeps that ID on later logins.
+func (m *UserModel) Provision(name, email, role string) (int, error) {
+	for _, u := range m.users() {
+		if u.Email == email {
+			if u.EmailVerified.IsZero() {
+				return 0, models.ErrDuplicateEmail
+			}
+
+			if u.Role == models.RoleAdmin && role != models.RoleAdmin && m.onlyAdmin(u.ID) {
+				return 0, models.ErrLastAdmin
+			}
+
+			if m.roles == nil {
+				m.roles = make(map[int]string)
+			}
+			m.roles[u.ID] = role
+
+			return u.ID, nil
+		}
+	}
+
+	user := &models.User{
+		ID:            100 + len(m.provisioned),
+		Name:          name,
+		Email:         email,
+		Created:       time.Now(),
+		Role:          role,
+		EmailVerified: time.Now(),
+	}
+	m.provisioned = append(m.provisioned, user)
+
+	return user.ID, nil
+}
@@ -0,0 +1,54 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/models"
+)
+
+// EmailVerificationModel records when it creates each verification, so that tests can reach the resend limits.
+type EmailVerificationModel struct {
+	sent []time.Time
+}
+
+func (m *EmailVerificationModel) Insert(string, time.Duration) (string, error) {
+	m.sent = append(m.sent, time.Now())
+	return "valid-token", nil
+}
+
+func (m *EmailVerificationModel) InsertPending(int, time.Duration) (string, error) {
+	m.sent = append(m.sent, time.Now())
+	return "pending-token", nil
+}
+
+// Age moves every verification created so far d into the past, as if that much time had gone by.
+func (m *EmailVerificationModel) Age(d time.Duration) {
+	for i := range m.sent {
+		m.sent[i] = m.sent[i].Add(-d)
+	}
+}
+
+func (m *EmailVerificationModel) Verify(token string) (int, error) {
+	switch token {
+	case "valid-token":
+		return 3, nil
+	case "pending-token":
+		return 1, nil
+	case "taken-token":
+		return 0, models.ErrDuplicateEmail
+	}
+
+	return 0, models.ErrNoRecord
+}
+
+func (m *EmailVerificationModel) CountSince(_ int, since time.Time) (int, error) {
+	count := 0
+
+	for _, sent := range m.sent {
+		if !sent.Before(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
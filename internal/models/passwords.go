@@ -0,0 +1,182 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var errUnknownHash = errors.New("models: unknown password hash format")
+
+// PasswordHasher hashes new passwords with Algorithm and checks passwords against hashes made with either
+// algorithm. Argon2id hashes are stored in the PHC string format, such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>, so that each hash records the parameters it was made with.
+type PasswordHasher struct {
+	Algorithm string
+	// BcryptCost is the bcrypt work factor.
+	BcryptCost int
+	// Argon2Memory is in KiB, Argon2Time is the number of passes over the memory and Argon2Threads is the degree
+	// of parallelism.
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+	// Argon2Concurrency caps how many argon2id hashes are worked out at once, and so the memory they take, at
+	// Argon2Concurrency times Argon2Memory. Further logins wait their turn. Zero means no cap.
+	Argon2Concurrency int
+
+	once  sync.Once
+	slots chan struct{}
+}
+
+// DefaultPasswordHasher uses argon2id with the parameters RFC 9106 recommends for memory-constrained systems, and
+// takes up to 256 MiB for four hashes at a time.
+var DefaultPasswordHasher = &PasswordHasher{
+	Algorithm:         AlgorithmArgon2id,
+	BcryptCost:        PasswordHashCost,
+	Argon2Memory:      64 * 1024,
+	Argon2Time:        3,
+	Argon2Threads:     2,
+	Argon2Concurrency: 4,
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Validate checks that the algorithm is known and its parameters are usable.
+func (h *PasswordHasher) Validate() error {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		if h.Argon2Memory < 8*uint32(h.Argon2Threads) || h.Argon2Time < 1 || h.Argon2Threads < 1 {
+			return errors.New("models: argon2id needs a time and threads of at least 1, and 8 KiB memory per thread")
+		}
+		if h.Argon2Concurrency < 0 {
+			return errors.New("models: argon2id concurrency can't be negative")
+		}
+	case AlgorithmBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("models: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("models: unknown password hashing algorithm %q", h.Algorithm)
+	}
+
+	return nil
+}
+
+// Hash returns the hash of a password to store.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	release := h.acquire()
+	key := argon2.IDKey([]byte(password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, argon2KeyLength)
+	release()
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Argon2Memory, h.Argon2Time,
+		h.Argon2Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check reports whether a password matches a hash, and whether a matching hash should be replaced because it was
+// made with another algorithm or parameters.
+func (h *PasswordHasher) Check(hash, password string) (bool, bool, error) {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, err
+		}
+
+		return true, h.Algorithm != AlgorithmBcrypt || cost != h.BcryptCost, nil
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, errUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errUnknownHash
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil || time < 1 || threads < 1 {
+		return false, false, errUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errUnknownHash
+	}
+
+	release := h.acquire()
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	release()
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	outdated := h.Algorithm != AlgorithmArgon2id || memory != h.Argon2Memory || time != h.Argon2Time ||
+		threads != h.Argon2Threads || len(salt) != argon2SaltLength || len(key) != argon2KeyLength
+
+	return true, outdated, nil
+}
+
+// acquire waits until fewer than Argon2Concurrency argon2id hashes are being worked out, and returns a function
+// that makes way for the next one.
+func (h *PasswordHasher) acquire() func() {
+	if h.Argon2Concurrency <= 0 {
+		return func() {}
+	}
+
+	h.once.Do(func() {
+		h.slots = make(chan struct{}, h.Argon2Concurrency)
+	})
+
+	h.slots <- struct{}{}
+	return func() { <-h.slots }
+}
+
+// passwordHasher returns h, or DefaultPasswordHasher if h is nil.
+func passwordHasher(h *PasswordHasher) *PasswordHasher {
+	if h == nil {
+		return DefaultPasswordHasher
+	}
+	return h
+}
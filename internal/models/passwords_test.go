@@ -0,0 +1,245 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher returns a hasher with parameters small enough to keep tests fast.
+func testHasher(algorithm string) *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:     algorithm,
+		BcryptCost:    bcrypt.MinCost,
+		Argon2Memory:  64,
+		Argon2Time:    1,
+		Argon2Threads: 1,
+	}
+}
+
+func TestPasswordHasherHash(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := testHasher(algorithm)
+
+			hash, err := h.Hash("pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			other, err := h.Hash("pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Each hash has its own salt.
+			assert.Equal(t, hash == other, false)
+
+			match, outdated, err := h.Check(hash, "pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, match, true)
+			assert.Equal(t, outdated, false)
+
+			match, outdated, err = h.Check(hash, "wrong")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, match, false)
+			assert.Equal(t, outdated, false)
+		})
+	}
+}
+
+func TestPasswordHasherArgon2idFormat(t *testing.T) {
+	hash, err := testHasher(AlgorithmArgon2id).Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), true)
+	assert.Equal(t, len(strings.Split(hash, "$")), 6)
+}
+
+func TestPasswordHasherOutdated(t *testing.T) {
+	argon2idHash, err := testHasher(AlgorithmArgon2id).Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := testHasher(AlgorithmBcrypt).Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   string
+		hasher func(h *PasswordHasher)
+		want   bool
+	}{
+		{
+			name:   "Same argon2id parameters",
+			hash:   argon2idHash,
+			hasher: func(h *PasswordHasher) {},
+			want:   false,
+		},
+		{
+			name:   "More argon2id memory",
+			hash:   argon2idHash,
+			hasher: func(h *PasswordHasher) { h.Argon2Memory = 128 },
+			want:   true,
+		},
+		{
+			name:   "More argon2id passes",
+			hash:   argon2idHash,
+			hasher: func(h *PasswordHasher) { h.Argon2Time = 2 },
+			want:   true,
+		},
+		{
+			name:   "More argon2id threads",
+			hash:   argon2idHash,
+			hasher: func(h *PasswordHasher) { h.Argon2Threads = 2 },
+			want:   true,
+		},
+		{
+			name:   "Argon2id hash with bcrypt configured",
+			hash:   argon2idHash,
+			hasher: func(h *PasswordHasher) { h.Algorithm = AlgorithmBcrypt },
+			want:   true,
+		},
+		{
+			// Hashes made before argon2id are upgraded on the next login.
+			name:   "Bcrypt hash with argon2id configured",
+			hash:   bcryptHash,
+			hasher: func(h *PasswordHasher) {},
+			want:   true,
+		},
+		{
+			name:   "Same bcrypt cost",
+			hash:   bcryptHash,
+			hasher: func(h *PasswordHasher) { h.Algorithm = AlgorithmBcrypt },
+			want:   false,
+		},
+		{
+			name: "Higher bcrypt cost",
+			hash: bcryptHash,
+			hasher: func(h *PasswordHasher) {
+				h.Algorithm = AlgorithmBcrypt
+				h.BcryptCost = bcrypt.MinCost + 1
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHasher(AlgorithmArgon2id)
+			tt.hasher(h)
+
+			match, outdated, err := h.Check(tt.hash, "pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, match, true)
+			assert.Equal(t, outdated, tt.want)
+		})
+	}
+}
+
+func TestPasswordHasherMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "Too few parts", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{name: "Unknown version", hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5"},
+		{name: "No passes", hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5"},
+		{name: "Bad salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{name: "Bad key", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$!!!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, _, err := testHasher(AlgorithmArgon2id).Check(tt.hash, "pa$$word")
+			assert.Equal(t, err, errUnknownHash)
+			assert.Equal(t, match, false)
+		})
+	}
+}
+
+func TestPasswordHasherValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hasher  func(h *PasswordHasher)
+		wantErr bool
+	}{
+		{name: "Valid argon2id", hasher: func(h *PasswordHasher) {}},
+		{name: "Valid bcrypt", hasher: func(h *PasswordHasher) { h.Algorithm = AlgorithmBcrypt }},
+		{name: "Unknown algorithm", hasher: func(h *PasswordHasher) { h.Algorithm = "md5" }, wantErr: true},
+		{name: "No argon2id passes", hasher: func(h *PasswordHasher) { h.Argon2Time = 0 }, wantErr: true},
+		{name: "No argon2id threads", hasher: func(h *PasswordHasher) { h.Argon2Threads = 0 }, wantErr: true},
+		{name: "Too little argon2id memory", hasher: func(h *PasswordHasher) { h.Argon2Memory = 4 }, wantErr: true},
+		{
+			name:    "Negative argon2id concurrency",
+			hasher:  func(h *PasswordHasher) { h.Argon2Concurrency = -1 },
+			wantErr: true,
+		},
+		{
+			name: "Bcrypt cost too low",
+			hasher: func(h *PasswordHasher) {
+				h.Algorithm = AlgorithmBcrypt
+				h.BcryptCost = bcrypt.MinCost - 1
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHasher(AlgorithmArgon2id)
+			tt.hasher(h)
+
+			assert.Equal(t, h.Validate() != nil, tt.wantErr)
+		})
+	}
+}
+
+func TestPasswordHasherConcurrency(t *testing.T) {
+	h := testHasher(AlgorithmArgon2id)
+	h.Argon2Concurrency = 2
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			hash, err := h.Hash("pa$$word")
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if _, _, err := h.Check(hash, "pa$$word"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Every slot is free again.
+	assert.Equal(t, len(h.slots), 0)
+}
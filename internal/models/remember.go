@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RememberGracePeriod is how long a remember token can still be used after it was swapped for the next one, as
+// long as the next one was not used yet. A browser that sends several requests at once sends the same token with
+// each of them, and only the first of them swaps it.
+const RememberGracePeriod = 30 * time.Second
+
+type RememberTokenModelInterface interface {
+	Insert(userID int, ttl time.Duration) (string, string, error)
+	Use(token string) (int, string, string, error)
+	DeleteFamily(family string) error
+	DeleteAll(userID int, except string) error
+}
+
+// RememberTokenModel wraps a database connection pool
+type RememberTokenModel struct {
+	DB *sql.DB
+}
+
+// Insert starts a new family of remember tokens for a user and returns the family and its first token. Each token
+// is used once and replaced by the next token in the family, and the family expires ttl from now.
+func (m *RememberTokenModel) Insert(userID int, ttl time.Duration) (string, string, error) {
+	family, _, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	// Expired tokens of the user are not needed any more, not even to spot a replay.
+	_, err = m.DB.Exec(`DELETE FROM remember_tokens WHERE userID = ? AND expires < UTC_TIMESTAMP()`, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	statement := `INSERT INTO remember_tokens (userID, family, token_hash, created, expires)
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(statement, userID, family, tokenHash, int(ttl.Seconds()))
+	if err != nil {
+		return "", "", err
+	}
+
+	return family, token, nil
+}
+
+// Use exchanges a remember token for the next token in its family, and returns the user it belongs to and the
+// family. It returns ErrNoRecord if the token does not exist or has expired. A token used again within
+// RememberGracePeriod, before the next token was used, returns no next token, so that the browser keeps the one it
+// got first. Any other token that was already used means that someone copied it, so Use deletes the whole family
+// and returns ErrTokenReused with the user it belonged to.
+func (m *RememberTokenModel) Use(token string) (int, string, string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	var id, userID int
+	var family string
+	var used sql.NullTime
+	var recent sql.NullBool
+
+	query := `SELECT id, userID, family, used, used > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+	FROM remember_tokens
+	WHERE token_hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, int(RememberGracePeriod.Seconds()), hashToken(token)).Scan(&id, &userID, &family,
+		&used, &recent)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", "", ErrNoRecord
+		}
+		return 0, "", "", err
+	}
+
+	if used.Valid && recent.Bool {
+		var nextUsed bool
+
+		query := `SELECT EXISTS (SELECT 1 FROM remember_tokens WHERE family = ? AND id > ? AND used IS NOT NULL)`
+
+		if err := tx.QueryRow(query, family, id).Scan(&nextUsed); err != nil {
+			tx.Rollback()
+			return 0, "", "", err
+		}
+
+		if !nextUsed {
+			if err := tx.Commit(); err != nil {
+				return 0, "", "", err
+			}
+
+			return userID, family, "", nil
+		}
+	}
+
+	if used.Valid {
+		if _, err := tx.Exec(`DELETE FROM remember_tokens WHERE family = ?`, family); err != nil {
+			tx.Rollback()
+			return 0, "", "", err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return 0, "", "", err
+		}
+
+		return userID, family, "", ErrTokenReused
+	}
+
+	next, nextHash, err := newToken()
+	if err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	if _, err := tx.Exec(`UPDATE remember_tokens SET used = UTC_TIMESTAMP() WHERE id = ?`, id); err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	// The next token keeps the expiry of the family, so that using a token does not extend it.
+	statement := `INSERT INTO remember_tokens (userID, family, token_hash, created, expires)
+	SELECT userID, family, ?, UTC_TIMESTAMP(), expires FROM remember_tokens WHERE id = ?`
+
+	if _, err := tx.Exec(statement, nextHash, id); err != nil {
+		tx.Rollback()
+		return 0, "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", "", err
+	}
+
+	return userID, family, next, nil
+}
+
+func (m *RememberTokenModel) DeleteFamily(family string) error {
+	_, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE family = ?`, family)
+	return err
+}
+
+// DeleteAll deletes every remember token of a user, except for those in the family except.
+func (m *RememberTokenModel) DeleteAll(userID int, except string) error {
+	_, err := m.DB.Exec(`DELETE FROM remember_tokens WHERE userID = ? AND family != ?`, userID, except)
+	return err
+}
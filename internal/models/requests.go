@@ -0,0 +1,315 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Verdicts a reviewer can give when submitting a review.
+const (
+	VerdictApproved         = "approved"
+	VerdictChangesRequested = "changes_requested"
+)
+
+type ReviewRequestModelInterface interface {
+	Insert(snippetID, requesterID int, reviewerEmail string, due time.Time) (int, error)
+	InsertTeam(snippetID, requesterID, teamID int, due time.Time) (int, error)
+	Complete(reviewerID, snippetID int, verdict string) error
+	ForSnippet(snippetID int) ([]*ReviewRequest, error)
+	Queue(reviewerID int) ([]*ReviewRequest, error)
+	ForUser(userID int) ([]*ReviewRequest, error)
+	DueSoon(within time.Duration) ([]*ReviewRequest, error)
+	Overdue() ([]*ReviewRequest, error)
+	MarkReminded(id int) (bool, error)
+	MarkEscalated(id int) (bool, error)
+	ClearReminded(id int) error
+	ClearEscalated(id int) error
+}
+
+// ReviewRequest is a request from an editor or admin for a reviewer to review a snippet by a due date.
+type ReviewRequest struct {
+	ID             int
+	SnippetID      int
+	SnippetTitle   string
+	RequesterID    int
+	RequesterName  string
+	RequesterEmail string
+	ReviewerID     int
+	ReviewerName   string
+	ReviewerEmail  string
+	Created        time.Time
+	Due            time.Time
+	Completed      time.Time
+}
+
+// Overdue reports whether the request is still open after its due date.
+func (rr *ReviewRequest) Overdue() bool {
+	return rr.Completed.IsZero() && time.Now().After(rr.Due)
+}
+
+// ReviewRequestModel wraps a database connection pool
+type ReviewRequestModel struct {
+	DB *sql.DB
+}
+
+// reviewRequestQuery selects review requests together with the snippet title and the names and email addresses
+// of the requester and the reviewer. Callers append a WHERE clause and an ORDER BY clause.
+const reviewRequestQuery = `
+	SELECT rr.id, rr.snippetID, snippets.title, rr.requesterID, requesters.name, requesters.email,
+		rr.reviewerID, reviewers.name, reviewers.email, rr.created, rr.due, rr.completed
+	FROM review_requests rr
+	JOIN snippets ON snippets.id = rr.snippetID
+	JOIN users requesters ON requesters.id = rr.requesterID
+	JOIN users reviewers ON reviewers.id = rr.reviewerID`
+
+// Insert asks the user with the given email address to review a snippet. Like InsertTeam, it only asks an enabled
+// user whose role lets them review, or who is in the team that owns the snippet. It returns ErrNoRecord if the
+// snippet or the user does not exist, ErrSelfReview if the user is the requester, ErrCannotReview if they can't
+// review the snippet, and ErrAlreadyRequested if they already have an open request for it.
+func (m *ReviewRequestModel) Insert(snippetID, requesterID int, reviewerEmail string, due time.Time) (int, error) {
+	roles := RolesWith(PermReviewSubmit)
+	inRoles := `users.role IN (?` + strings.Repeat(", ?", len(roles)-1) + `)`
+
+	statement := `
+		INSERT INTO review_requests (snippetID, requesterID, reviewerID, created, due)
+		SELECT snippets.id, ?, users.id, UTC_TIMESTAMP(), ?
+		FROM snippets, users
+		WHERE snippets.id = ? AND users.email = ? AND users.id <> ? AND users.disabled = false
+		AND (` + inRoles + ` OR EXISTS (SELECT true FROM team_members
+			WHERE team_members.teamID = snippets.teamID AND team_members.userID = users.id))
+		AND NOT EXISTS (SELECT true FROM review_requests open
+			WHERE open.snippetID = snippets.id AND open.reviewerID = users.id AND open.completed IS NULL)`
+
+	args := []any{requesterID, due.UTC(), snippetID, reviewerEmail, requesterID}
+	for _, role := range roles {
+		args = append(args, role)
+	}
+
+	result, err := m.DB.Exec(statement, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, m.whyNotInserted(snippetID, requesterID, reviewerEmail, inRoles, roles)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// whyNotInserted finds out why Insert asked nobody, so that the requester can be told.
+func (m *ReviewRequestModel) whyNotInserted(snippetID, requesterID int, reviewerEmail, inRoles string,
+	roles []string) error {
+	var reviewerID int
+	var canReview, open bool
+
+	query := `
+		SELECT users.id, users.disabled = false AND (` + inRoles + ` OR EXISTS (SELECT true FROM team_members
+			WHERE team_members.teamID = snippets.teamID AND team_members.userID = users.id)),
+		EXISTS (SELECT true FROM review_requests open
+			WHERE open.snippetID = snippets.id AND open.reviewerID = users.id AND open.completed IS NULL)
+		FROM snippets, users
+		WHERE snippets.id = ? AND users.email = ?`
+
+	var args []any
+	for _, role := range roles {
+		args = append(args, role)
+	}
+	args = append(args, snippetID, reviewerEmail)
+
+	err := m.DB.QueryRow(query, args...).Scan(&reviewerID, &canReview, &open)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNoRecord
+	case err != nil:
+		return err
+	case reviewerID == requesterID:
+		return ErrSelfReview
+	case !canReview:
+		return ErrCannotReview
+	case open:
+		return ErrAlreadyRequested
+	}
+
+	// The user or their requests changed between the two queries, so the request can't be made as it was.
+	return ErrCannotReview
+}
+
+// InsertTeam asks every member of a team who can review the snippet to review it, and returns how many requests it
+// made. Only a member of the team can ask it, unless the team owns the snippet. Members can review the snippets
+// their team owns, and those of any team if their own role lets them review. The requester and members who already
+// have an open request for the snippet are left out. It returns ErrNoRecord if nobody is left.
+func (m *ReviewRequestModel) InsertTeam(snippetID, requesterID, teamID int, due time.Time) (int, error) {
+	roles := RolesWith(PermReviewSubmit)
+
+	statement := `
+		INSERT INTO review_requests (snippetID, requesterID, reviewerID, created, due)
+		SELECT snippets.id, ?, users.id, UTC_TIMESTAMP(), ?
+		FROM snippets
+		JOIN team_members ON team_members.teamID = ?
+		JOIN users ON users.id = team_members.userID
+		WHERE snippets.id = ? AND users.id <> ? AND users.disabled = false
+		AND (snippets.teamID = team_members.teamID OR users.role IN (?` + strings.Repeat(", ?", len(roles)-1) + `))
+		AND (snippets.teamID = team_members.teamID OR EXISTS (SELECT true FROM team_members requester
+			WHERE requester.teamID = team_members.teamID AND requester.userID = ?))
+		AND NOT EXISTS (SELECT true FROM review_requests open
+			WHERE open.snippetID = snippets.id AND open.reviewerID = users.id AND open.completed IS NULL)`
+
+	args := []any{requesterID, due.UTC(), teamID, snippetID, requesterID}
+	for _, role := range roles {
+		args = append(args, role)
+	}
+	args = append(args, requesterID)
+
+	result, err := m.DB.Exec(statement, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, ErrNoRecord
+	}
+
+	return int(rows), nil
+}
+
+func (m *ReviewRequestModel) Complete(reviewerID, snippetID int, verdict string) error {
+	statement := `UPDATE review_requests SET completed = UTC_TIMESTAMP(), verdict = ?
+		WHERE reviewerID = ? AND snippetID = ? AND completed IS NULL`
+
+	_, err := m.DB.Exec(statement, verdict, reviewerID, snippetID)
+	return err
+}
+
+func (m *ReviewRequestModel) ForSnippet(snippetID int) ([]*ReviewRequest, error) {
+	query := reviewRequestQuery + ` WHERE rr.snippetID = ? ORDER BY rr.due`
+
+	return m.list(query, snippetID)
+}
+
+func (m *ReviewRequestModel) Queue(reviewerID int) ([]*ReviewRequest, error) {
+	query := reviewRequestQuery + `
+		WHERE rr.reviewerID = ? AND rr.completed IS NULL AND snippets.expires > UTC_TIMESTAMP()
+		ORDER BY rr.due`
+
+	return m.list(query, reviewerID)
+}
+
+// ForUser returns the requests a user made or was asked to review, open or completed, oldest first.
+func (m *ReviewRequestModel) ForUser(userID int) ([]*ReviewRequest, error) {
+	query := reviewRequestQuery + ` WHERE rr.requesterID = ? OR rr.reviewerID = ? ORDER BY rr.created, rr.id`
+
+	return m.list(query, userID, userID)
+}
+
+// DueSoon returns open requests that fall due within the given duration and have not had a reminder sent. Requests
+// for expired snippets and disabled reviewers are left out, since nobody can act on them.
+func (m *ReviewRequestModel) DueSoon(within time.Duration) ([]*ReviewRequest, error) {
+	query := reviewRequestQuery + `
+		WHERE rr.completed IS NULL AND rr.reminded IS NULL
+		AND snippets.expires > UTC_TIMESTAMP() AND reviewers.disabled = false
+		AND rr.due > UTC_TIMESTAMP() AND rr.due <= DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		ORDER BY rr.due`
+
+	return m.list(query, int(within.Seconds()))
+}
+
+// Overdue returns open requests past their due date that have not been escalated to the requester. Like DueSoon,
+// it leaves out requests for expired snippets and disabled reviewers.
+func (m *ReviewRequestModel) Overdue() ([]*ReviewRequest, error) {
+	query := reviewRequestQuery + `
+		WHERE rr.completed IS NULL AND rr.escalated IS NULL AND rr.due <= UTC_TIMESTAMP()
+		AND snippets.expires > UTC_TIMESTAMP() AND reviewers.disabled = false
+		ORDER BY rr.due`
+
+	return m.list(query)
+}
+
+// MarkReminded claims the reminder for a request before it is sent. It returns false if another process already
+// claimed it, so that running several containers does not send duplicate reminders.
+func (m *ReviewRequestModel) MarkReminded(id int) (bool, error) {
+	statement := `UPDATE review_requests SET reminded = UTC_TIMESTAMP() WHERE id = ? AND reminded IS NULL`
+
+	return m.claim(statement, id)
+}
+
+// MarkEscalated claims the escalation of an overdue request before it is sent. Like MarkReminded, it returns false
+// if another process already claimed it.
+func (m *ReviewRequestModel) MarkEscalated(id int) (bool, error) {
+	statement := `UPDATE review_requests SET escalated = UTC_TIMESTAMP() WHERE id = ? AND escalated IS NULL`
+
+	return m.claim(statement, id)
+}
+
+// ClearReminded gives up the claim on a reminder that could not be sent, so that it is sent again.
+func (m *ReviewRequestModel) ClearReminded(id int) error {
+	_, err := m.DB.Exec(`UPDATE review_requests SET reminded = NULL WHERE id = ?`, id)
+	return err
+}
+
+// ClearEscalated gives up the claim on an escalation that could not be sent, so that it is sent again.
+func (m *ReviewRequestModel) ClearEscalated(id int) error {
+	_, err := m.DB.Exec(`UPDATE review_requests SET escalated = NULL WHERE id = ?`, id)
+	return err
+}
+
+func (m *ReviewRequestModel) claim(statement string, id int) (bool, error) {
+	result, err := m.DB.Exec(statement, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (m *ReviewRequestModel) list(query string, args ...any) ([]*ReviewRequest, error) {
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*ReviewRequest
+
+	for rows.Next() {
+		rr := &ReviewRequest{}
+		var completed sql.NullTime
+
+		err = rows.Scan(&rr.ID, &rr.SnippetID, &rr.SnippetTitle, &rr.RequesterID, &rr.RequesterName,
+			&rr.RequesterEmail, &rr.ReviewerID, &rr.ReviewerName, &rr.ReviewerEmail, &rr.Created, &rr.Due,
+			&completed)
+		if err != nil {
+			return nil, err
+		}
+
+		// Completed stays the zero time for open requests.
+		rr.Completed = completed.Time
+		requests = append(requests, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
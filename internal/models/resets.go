@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PasswordResetModelInterface interface {
+	Insert(email string, ttl time.Duration) (string, error)
+	Get(token string) (*PasswordReset, error)
+	Reset(token, password string) (int, error)
+}
+
+// PasswordReset lets the user UserID choose a new password through a single-use link sent to Email.
+type PasswordReset struct {
+	ID      int
+	UserID  int
+	Email   string
+	Created time.Time
+	Expires time.Time
+}
+
+// PasswordResetModel wraps a database connection pool
+type PasswordResetModel struct {
+	DB        *sql.DB
+	Passwords *PasswordHasher
+}
+
+// Insert creates a password reset for the active user with an email address that expires after ttl, and returns
+// the token for the reset link. It returns ErrNoRecord if there is no such user.
+func (m *PasswordResetModel) Insert(email string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	statement := `
+		INSERT INTO password_resets (userID, token_hash, created, expires)
+		SELECT id, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		FROM users
+		WHERE email = ? AND disabled = false`
+
+	result, err := m.DB.Exec(statement, tokenHash, int(ttl.Seconds()), email)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", ErrNoRecord
+	}
+
+	return token, nil
+}
+
+// Get returns the unused, unexpired password reset for a token, or ErrNoRecord.
+func (m *PasswordResetModel) Get(token string) (*PasswordReset, error) {
+	pr := &PasswordReset{}
+
+	query := `
+		SELECT password_resets.id, password_resets.userID, users.email, password_resets.created,
+			password_resets.expires
+		FROM password_resets
+		JOIN users ON users.id = password_resets.userID
+		WHERE password_resets.token_hash = ? AND password_resets.used IS NULL
+		AND password_resets.expires > UTC_TIMESTAMP()`
+
+	err := m.DB.QueryRow(query, hashToken(token)).Scan(&pr.ID, &pr.UserID, &pr.Email, &pr.Created, &pr.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// Reset sets a new password for the user of an unused, unexpired token, and marks every reset for that user as
+// used so that older links stop working too. It returns the ID of the user, or ErrNoRecord if the token is not
+// valid.
+func (m *PasswordResetModel) Reset(token, password string) (int, error) {
+	hashedPassword, err := passwordHasher(m.Passwords).Hash(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+
+	query := `SELECT userID FROM password_resets
+		WHERE token_hash = ? AND used IS NULL AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, hashToken(token)).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	statement := `UPDATE password_resets SET used = UTC_TIMESTAMP() WHERE userID = ? AND used IS NULL`
+
+	if _, err := tx.Exec(statement, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// A new password also satisfies a reset required by an admin.
+	statement = `UPDATE users SET hashed_password = ?, password_reset_required = false WHERE id = ?`
+
+	if _, err := tx.Exec(statement, hashedPassword, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
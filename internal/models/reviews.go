@@ -3,13 +3,15 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"time"
 )
 
 type ReviewModelInterface interface {
 	Insert(userID, snippetID int) error
 	Exists(userID, snippetID int) (bool, error)
 	Get(userID, snippetID int) (*Review, error)
-	Update(userID, snippetID int) error
+	Update(userID, snippetID, actingAdminID int, verdict string) error
+	Stats(since time.Time) ([]*ReviewerStats, error)
 }
 
 type Review struct {
@@ -18,6 +20,23 @@ type Review struct {
 	Reviews   uint8
 }
 
+// ReviewerStats summarizes the reviews a user submitted.
+type ReviewerStats struct {
+	UserID          int
+	Name            string
+	Reviews         int
+	Approved        int
+	AvgTimeToReview time.Duration
+}
+
+// ApprovalRate returns the percentage of reviews that approved the snippet.
+func (s *ReviewerStats) ApprovalRate() float64 {
+	if s.Reviews == 0 {
+		return 0
+	}
+	return float64(s.Approved) / float64(s.Reviews) * 100
+}
+
 // ReviewModel wraps a database connection pool
 type ReviewModel struct {
 	DB *sql.DB
@@ -81,7 +100,10 @@ func (m *ReviewModel) Get(userID, snippetID int) (*Review, error) {
 	return review, nil
 }
 
-func (m *ReviewModel) Update(userID, snippetID int) error {
+// Update counts a review submission and records it with its verdict on the snippet timeline, along with the admin
+// actingAdminID if one is viewing the site as the user. It returns ErrNoRecord if the snippet doesn't exist, and
+// records nothing.
+func (m *ReviewModel) Update(userID, snippetID, actingAdminID int, verdict string) error {
 	// Start a transaction to lock the row for update for multiple reviewers using the same login.
 	tx, err := m.DB.Begin()
 	if err != nil {
@@ -105,10 +127,90 @@ func (m *ReviewModel) Update(userID, snippetID int) error {
 		return err
 	}
 	defer updateRow.Close()
-	if _, err := updateRow.Exec(userID, snippetID); err != nil {
+	result, err := updateRow.Exec(userID, snippetID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// A reviewer who never opened the snippet, such as one using an API token, has no row yet.
+	if rows == 0 {
+		statement := `INSERT INTO reviews (userID, snippetID, review) SELECT ?, id, 1 FROM snippets WHERE id = ?`
+
+		result, err = tx.Exec(statement, userID, snippetID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		rows, err = result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if rows == 0 {
+			tx.Rollback()
+			return ErrNoRecord
+		}
+	}
+
+	if err := insertSnippetEvent(tx, snippetID, userID, actingAdminID, EventReview, verdict); err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	return tx.Commit()
 }
+
+// Stats returns a summary for every user who submitted a review since the given time, ordered by the number of
+// reviews. Reviews are counted from the snippet timeline, so that those submitted without a request count too, and
+// the average time to review is taken from the review requests the user completed. Pass the zero time to include
+// all reviews.
+func (m *ReviewModel) Stats(since time.Time) ([]*ReviewerStats, error) {
+	query := `
+		SELECT users.id, users.name, COUNT(e.id),
+			COALESCE(SUM(e.detail = 'approved'), 0),
+			COALESCE((
+				SELECT AVG(TIMESTAMPDIFF(SECOND, rr.created, rr.completed))
+				FROM review_requests rr
+				WHERE rr.reviewerID = users.id AND rr.completed IS NOT NULL AND rr.completed >= ?
+			), 0)
+		FROM users
+		JOIN snippet_events e ON e.userID = users.id
+		WHERE e.kind = ? AND e.created >= ?
+		GROUP BY users.id, users.name
+		ORDER BY COUNT(e.id) DESC, users.name`
+
+	rows, err := m.DB.Query(query, since.UTC(), EventReview, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*ReviewerStats
+
+	for rows.Next() {
+		s := &ReviewerStats{}
+		var avgSeconds float64
+
+		err = rows.Scan(&s.UserID, &s.Name, &s.Reviews, &s.Approved, &avgSeconds)
+		if err != nil {
+			return nil, err
+		}
+
+		s.AvgTimeToReview = time.Duration(avgSeconds) * time.Second
+		stats = append(stats, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,76 @@
+package models
+
+import "slices"
+
+// Roles a user can have. Existing owners became admins and everyone else a reviewer.
+const (
+	RoleAdmin    = "admin"
+	RoleEditor   = "editor"
+	RoleReviewer = "reviewer"
+	RoleViewer   = "viewer"
+)
+
+// Roles lists every role from the most to the least privileged.
+var Roles = []string{RoleAdmin, RoleEditor, RoleReviewer, RoleViewer}
+
+// Permissions checked by the middleware, handlers and templates.
+const (
+	PermSnippetCreate   = "snippet.create"
+	PermSnippetEdit     = "snippet.edit"
+	PermSnippetDelete   = "snippet.delete"
+	PermReviewRequest   = "review.request"
+	PermReviewSubmit    = "review.submit"
+	PermReviewStats     = "review.stats"
+	PermCommentCreate   = "comment.create"
+	PermCommentModerate = "comment.moderate"
+	PermUserManage      = "user.manage"
+	PermTeamCreate      = "team.create"
+	PermTeamManage      = "team.manage"
+	PermAuditView       = "audit.view"
+)
+
+// rolePermissions maps each role to the permissions it grants. Every authenticated user can view and star
+// snippets, and edit and delete their own comments.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermSnippetCreate, PermSnippetEdit, PermSnippetDelete, PermReviewRequest, PermReviewSubmit,
+		PermReviewStats, PermCommentCreate, PermCommentModerate, PermUserManage, PermTeamCreate, PermTeamManage,
+		PermAuditView,
+	},
+	RoleEditor: {
+		PermSnippetCreate, PermSnippetEdit, PermSnippetDelete, PermReviewRequest, PermReviewSubmit,
+		PermCommentCreate, PermTeamCreate,
+	},
+	RoleReviewer: {PermReviewSubmit, PermCommentCreate},
+	RoleViewer:   {},
+}
+
+// Permissions returns the set of permissions granted to a role. An unknown role has no permissions.
+func Permissions(role string) map[string]bool {
+	permissions := make(map[string]bool)
+
+	for _, permission := range rolePermissions[role] {
+		permissions[permission] = true
+	}
+
+	return permissions
+}
+
+// RolesWith returns the roles that grant a permission.
+func RolesWith(permission string) []string {
+	var roles []string
+
+	for _, role := range Roles {
+		if slices.Contains(rolePermissions[role], permission) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks a value sealed by a SecretBox, which sets it apart from a plaintext value stored before
+// secrets were encrypted.
+const sealedPrefix = "v1:"
+
+var errSecretKeySize = errors.New("models: secret key must be 32 bytes")
+
+// SecretBox encrypts secrets that have to be read back from the database, such as TOTP secrets, with AES-256-GCM,
+// so that a copy of the database alone doesn't give them away.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox returns a SecretBox that uses a 32-byte key.
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, errSecretKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts a secret. The secret can only be opened with the same label, such as the ID of the user it
+// belongs to, so that it can't be copied to another row.
+func (b *SecretBox) Seal(secret, label string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), []byte(label))
+
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed with the same label. A value without the sealed prefix is returned as it is, with
+// sealed set to false, so that callers can seal it. A nil SecretBox, which has no key, returns ErrNoSecretKey for
+// a sealed value.
+func (b *SecretBox) Open(value, label string) (secret string, sealed bool, err error) {
+	encoded, found := strings.CutPrefix(value, sealedPrefix)
+	if !found {
+		return value, false, nil
+	}
+
+	if b == nil {
+		return "", true, ErrNoSecretKey
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", true, fmt.Errorf("models: open secret: %w", err)
+	}
+
+	size := b.aead.NonceSize()
+	if len(data) < size {
+		return "", true, errors.New("models: open secret: too short")
+	}
+
+	plaintext, err := b.aead.Open(nil, data[:size], data[size:], []byte(label))
+	if err != nil {
+		return "", true, fmt.Errorf("models: open secret: %w", err)
+	}
+
+	return string(plaintext), true, nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP", "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(sealed) <= 255, true)
+	assert.Equal(t, sealed == "JBSWY3DPEHPK3PXP", false)
+
+	secret, ok, err := box.Open(sealed, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, true)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	// A secret copied to another user's row can't be opened.
+	_, _, err = box.Open(sealed, "totp:2")
+	assert.Equal(t, err != nil, true)
+
+	// Nor can one sealed with another key.
+	other, err := NewSecretBox(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = other.Open(sealed, "totp:1")
+	assert.Equal(t, err != nil, true)
+
+	// A secret stored before secrets were encrypted is returned as it is.
+	secret, ok, err = box.Open("JBSWY3DPEHPK3PXP", "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, false)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	// Without a key, only secrets stored before secrets were encrypted can be read.
+	var none *SecretBox
+
+	secret, ok, err = none.Open("JBSWY3DPEHPK3PXP", "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ok, false)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	_, _, err = none.Open(sealed, "totp:1")
+	assert.Equal(t, err, ErrNoSecretKey)
+
+	_, err = NewSecretBox([]byte("short"))
+	assert.Equal(t, err != nil, true)
+}
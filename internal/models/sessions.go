@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type UserSessionModelInterface interface {
+	Touch(token string, userID int, ip, userAgent, rememberFamily string, expires time.Time) error
+	List(userID int, current string) ([]*UserSession, error)
+	Delete(userID, id int) (*UserSession, error)
+	DeleteToken(token string) error
+	DeleteAll(userID int) ([]string, error)
+}
+
+// UserSession indexes a session in the session store by the user logged in to it.
+type UserSession struct {
+	ID        int
+	UserID    int
+	Token     string
+	IP        string
+	UserAgent string
+	// RememberFamily is the family of remember tokens that can bring the session back once it expires, if any.
+	RememberFamily string
+	Created        time.Time
+	LastSeen       time.Time
+	Expires        time.Time
+	Current        bool
+}
+
+// UserSessionModel wraps a database connection pool
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+// Touch records that a session was used, adding it to the index if it is not there yet.
+func (m *UserSessionModel) Touch(token string, userID int, ip, userAgent, rememberFamily string,
+	expires time.Time) error {
+	statement := `INSERT INTO user_sessions (token, userID, ip, user_agent, remember_family, created, last_seen, expires)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)
+	ON DUPLICATE KEY UPDATE ip = VALUES(ip), user_agent = VALUES(user_agent), last_seen = VALUES(last_seen)`
+
+	_, err := m.DB.Exec(statement, token, userID, ip, truncate(userAgent, 255), rememberFamily, expires.UTC())
+	return err
+}
+
+// List returns the unexpired sessions of a user, most recently used first. The session with the token current is
+// marked as the current one.
+func (m *UserSessionModel) List(userID int, current string) ([]*UserSession, error) {
+	query := `SELECT id, userID, token, ip, user_agent, remember_family, created, last_seen, expires
+	FROM user_sessions WHERE userID = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sessions []*UserSession
+
+	for rows.Next() {
+		s := &UserSession{}
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Token, &s.IP, &s.UserAgent, &s.RememberFamily, &s.Created, &s.LastSeen,
+			&s.Expires)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Current = s.Token == current
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete removes a session of a user from the index and returns it, so that it can be deleted from the session
+// store too.
+func (m *UserSessionModel) Delete(userID, id int) (*UserSession, error) {
+	s := &UserSession{}
+
+	query := `SELECT id, userID, token, ip, user_agent, remember_family, created, last_seen, expires
+	FROM user_sessions WHERE id = ? AND userID = ?`
+
+	err := m.DB.QueryRow(query, id, userID).Scan(&s.ID, &s.UserID, &s.Token, &s.IP, &s.UserAgent,
+		&s.RememberFamily, &s.Created, &s.LastSeen, &s.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	if err := m.DeleteToken(s.Token); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (m *UserSessionModel) DeleteToken(token string) error {
+	_, err := m.DB.Exec(`DELETE FROM user_sessions WHERE token = ?`, token)
+	return err
+}
+
+// DeleteAll removes every session of a user from the index and returns their tokens.
+func (m *UserSessionModel) DeleteAll(userID int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT token FROM user_sessions WHERE userID = ? FOR UPDATE`, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var tokens []string
+
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_sessions WHERE userID = ?`, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// truncate shortens s to at most n characters, without splitting one in two.
+func truncate(s string, n int) string {
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
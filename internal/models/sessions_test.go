@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{name: "Short", s: "Firefox", n: 255, want: "Firefox"},
+		{name: "Exact", s: "Firefox", n: 7, want: "Firefox"},
+		{name: "Long", s: "Firefox", n: 4, want: "Fire"},
+		{name: "Multibyte", s: "Navigateur é", n: 12, want: "Navigateur é"},
+		{name: "Multibyte cut", s: "éééé", n: 3, want: "ééé"},
+		{name: "Empty", s: "", n: 3, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, truncate(tt.s, tt.n), tt.want)
+		})
+	}
+}
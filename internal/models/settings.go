@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// Names of the site-wide settings admins can change.
+const (
+	SettingRequireAdminTwoFactor = "require_admin_2fa"
+)
+
+type SettingModelInterface interface {
+	Bool(name string) (bool, error)
+	SetBool(name string, value bool) error
+}
+
+// SettingModel wraps a database connection pool
+type SettingModel struct {
+	DB *sql.DB
+}
+
+// Bool returns the value of a true or false setting. A setting that was never set is false.
+func (m *SettingModel) Bool(name string) (bool, error) {
+	var value string
+
+	err := m.DB.QueryRow(`SELECT value FROM settings WHERE name = ?`, name).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func (m *SettingModel) SetBool(name string, value bool) error {
+	statement := `INSERT INTO settings (name, value) VALUES (?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)`
+
+	_, err := m.DB.Exec(statement, name, strconv.FormatBool(value))
+	return err
+}
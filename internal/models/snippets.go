@@ -7,30 +7,62 @@ import (
 )
 
 type SnippetModelInterface interface {
-	Insert(title, content string, expires int) (int, error)
+	Insert(userID, teamID int, title, content string, expires int) (int, error)
 	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Latest(sort string) ([]*Snippet, error)
+	ForUser(userID int) ([]*Snippet, error)
+	ForTeam(teamID int) ([]*Snippet, error)
+	Update(id, userID, actingAdminID int, title, content string, expires int) error
+	Delete(id int) error
 }
 
+// Orders for the list of latest snippets.
+const (
+	SortNewest = "newest"
+	SortStars  = "stars"
+)
+
+// Snippet is a snippet and its star count. UserID is the author, or zero if the snippet predates authorship or its
+// author deleted their account. TeamID is the team that owns the snippet, or zero if it belongs to its author.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int
+	UserID   int
+	TeamID   int
+	TeamName string
+	Title    string
+	Content  string
+	Created  time.Time
+	Expires  time.Time
+	Stars    int
 }
 
+// snippetQuery selects snippets together with the name of the team that owns them and their star counts. Callers
+// append a WHERE clause and an ORDER BY clause.
+const snippetQuery = `
+	SELECT snippets.id, COALESCE(snippets.userID, 0), COALESCE(snippets.teamID, 0), COALESCE(teams.name, ''),
+		snippets.title, snippets.content, snippets.created, snippets.expires,
+		(SELECT COUNT(*) FROM stars WHERE stars.snippetID = snippets.id) AS stars
+	FROM snippets
+	LEFT JOIN teams ON teams.id = snippets.teamID`
+
 // SnippetModel wraps a database connection pool
 type SnippetModel struct {
 	DB *sql.DB
 }
 
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
-	statement := `INSERT INTO snippets (title, content, created, expires) 
-VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// Insert adds a snippet written by the user userID. A teamID of zero leaves the snippet with its author rather than
+// a team.
+func (m *SnippetModel) Insert(userID, teamID int, title, content string, expires int) (int, error) {
+	statement := `INSERT INTO snippets (userID, teamID, title, content, created, expires) 
+VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	var team sql.NullInt64
+	if teamID != 0 {
+		team = sql.NullInt64{Int64: int64(teamID), Valid: true}
+	}
 
 	// DB.Exec returns a sql.Result type which contains basic information about the operation including LastInsertID.
-	result, err := m.DB.Exec(statement, title, content, expires)
+	result, err := m.DB.Exec(statement, userID, team, title, content, expires)
 	if err != nil {
 		return 0, err
 	}
@@ -48,9 +80,10 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	// Initialize a pointer to a new zeroed Snippet struct.
 	s := &Snippet{}
 
-	query := `SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	query := snippetQuery + ` WHERE snippets.expires > UTC_TIMESTAMP() AND snippets.id = ?`
 
-	err := m.DB.QueryRow(query, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := m.DB.QueryRow(query, id).Scan(&s.ID, &s.UserID, &s.TeamID, &s.TeamName, &s.Title, &s.Content, &s.Created,
+		&s.Expires, &s.Stars)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -61,10 +94,35 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	query := `SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY id`
+// Latest returns the unexpired snippets with their star counts. Pass SortStars to list the most starred first;
+// any other value lists them by ID.
+func (m *SnippetModel) Latest(sort string) ([]*Snippet, error) {
+	orderBy := `snippets.id`
+	if sort == SortStars {
+		orderBy = `stars DESC, snippets.id`
+	}
+
+	query := snippetQuery + ` WHERE snippets.expires > UTC_TIMESTAMP() ORDER BY ` + orderBy
+
+	return m.list(query)
+}
+
+// ForUser returns every snippet a user wrote, including expired ones, newest first.
+func (m *SnippetModel) ForUser(userID int) ([]*Snippet, error) {
+	query := snippetQuery + ` WHERE snippets.userID = ? ORDER BY snippets.id DESC`
+
+	return m.list(query, userID)
+}
+
+// ForTeam returns the unexpired snippets a team owns, newest first.
+func (m *SnippetModel) ForTeam(teamID int) ([]*Snippet, error) {
+	query := snippetQuery + ` WHERE snippets.teamID = ? AND snippets.expires > UTC_TIMESTAMP() ORDER BY snippets.id DESC`
 
-	rows, err := m.DB.Query(query)
+	return m.list(query, teamID)
+}
+
+func (m *SnippetModel) list(query string, args ...any) ([]*Snippet, error) {
+	rows, err := m.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +132,8 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 
 	for rows.Next() {
 		s := &Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.UserID, &s.TeamID, &s.TeamName, &s.Title, &s.Content, &s.Created, &s.Expires,
+			&s.Stars)
 		if err != nil {
 			return nil, err
 		}
@@ -88,3 +147,84 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 
 	return snippets, nil
 }
+
+// Update changes the title and content of a snippet and, if expires is more than zero, resets its expiry to that
+// many days from now. Each change is recorded on the snippet timeline as the user userID, and as the admin
+// actingAdminID if one is viewing the site as them.
+func (m *SnippetModel) Update(id, userID, actingAdminID int, title, content string, expires int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var currentTitle, currentContent string
+
+	query := `SELECT title, content FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, id).Scan(&currentTitle, &currentContent)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if title != currentTitle || content != currentContent {
+		statement := `UPDATE snippets SET title = ?, content = ? WHERE id = ?`
+
+		if _, err := tx.Exec(statement, title, content, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := insertSnippetEvent(tx, id, userID, actingAdminID, EventEdit, ""); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if expires > 0 {
+		statement := `UPDATE snippets SET expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY) WHERE id = ?`
+
+		if _, err := tx.Exec(statement, expires, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var newExpires time.Time
+
+		if err := tx.QueryRow(`SELECT expires FROM snippets WHERE id = ?`, id).Scan(&newExpires); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		detail := newExpires.Format("Jan 02 2006 at 15:04")
+		if err := insertSnippetEvent(tx, id, userID, actingAdminID, EventExpiry, detail); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a snippet along with its reviews, review requests, comments and stars.
+func (m *SnippetModel) Delete(id int) error {
+	statement := `DELETE FROM snippets WHERE id = ?`
+
+	result, err := m.DB.Exec(statement, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
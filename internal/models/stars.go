@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type StarModelInterface interface {
+	Set(userID, snippetID int, starred bool) error
+	Exists(userID, snippetID int) (bool, error)
+	Favorites(userID int) ([]*Snippet, error)
+	ForUser(userID int) ([]*Star, error)
+}
+
+// Star records that a user starred a snippet.
+type Star struct {
+	SnippetID    int
+	SnippetTitle string
+	Created      time.Time
+}
+
+// StarModel wraps a database connection pool
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Set stars or unstars a snippet for a user. Setting the state a snippet is already in does nothing, so repeated
+// requests, for example from a double-click, have the same effect as one.
+func (m *StarModel) Set(userID, snippetID int, starred bool) error {
+	statement := `DELETE FROM stars WHERE userID = ? AND snippetID = ?`
+	if starred {
+		statement = `INSERT IGNORE INTO stars (userID, snippetID, created) VALUES (?, ?, UTC_TIMESTAMP())`
+	}
+
+	_, err := m.DB.Exec(statement, userID, snippetID)
+	return err
+}
+
+func (m *StarModel) Exists(userID, snippetID int) (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS(SELECT true FROM stars WHERE userID = ? AND snippetID = ?)`
+
+	err := m.DB.QueryRow(query, userID, snippetID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// Favorites returns the unexpired snippets a user starred, most recently starred first.
+func (m *StarModel) Favorites(userID int) ([]*Snippet, error) {
+	query := `
+		SELECT snippets.id, snippets.title, snippets.content, snippets.created, snippets.expires,
+			(SELECT COUNT(*) FROM stars counts WHERE counts.snippetID = snippets.id)
+		FROM stars
+		JOIN snippets ON snippets.id = stars.snippetID
+		WHERE stars.userID = ? AND snippets.expires > UTC_TIMESTAMP()
+		ORDER BY stars.created DESC`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*Snippet
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Stars)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// ForUser returns every snippet a user starred, expired or not, oldest star first.
+func (m *StarModel) ForUser(userID int) ([]*Star, error) {
+	query := `
+		SELECT stars.snippetID, snippets.title, stars.created
+		FROM stars
+		JOIN snippets ON snippets.id = stars.snippetID
+		WHERE stars.userID = ?
+		ORDER BY stars.created, stars.snippetID`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stars []*Star
+
+	for rows.Next() {
+		s := &Star{}
+		err = rows.Scan(&s.SnippetID, &s.SnippetTitle, &s.Created)
+		if err != nil {
+			return nil, err
+		}
+		stars = append(stars, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stars, nil
+}
@@ -0,0 +1,333 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Roles a user can have in a team. A maintainer manages the team's members and can delete its snippets.
+const (
+	TeamRoleMaintainer = "maintainer"
+	TeamRoleMember     = "member"
+)
+
+// TeamRoles lists every team role from the most to the least privileged.
+var TeamRoles = []string{TeamRoleMaintainer, TeamRoleMember}
+
+// teamRolePermissions maps each team role to the permissions it grants on the team and the snippets it owns, on top
+// of those the user's own role grants.
+var teamRolePermissions = map[string][]string{
+	TeamRoleMaintainer: {
+		PermSnippetCreate, PermSnippetEdit, PermSnippetDelete, PermReviewRequest, PermReviewSubmit,
+		PermCommentCreate, PermTeamManage,
+	},
+	TeamRoleMember: {
+		PermSnippetCreate, PermSnippetEdit, PermReviewRequest, PermReviewSubmit, PermCommentCreate,
+	},
+}
+
+// TeamPermissions returns the set of permissions granted by a team role. An unknown team role has no permissions.
+func TeamPermissions(role string) map[string]bool {
+	permissions := make(map[string]bool)
+
+	for _, permission := range teamRolePermissions[role] {
+		permissions[permission] = true
+	}
+
+	return permissions
+}
+
+// ValidTeamRole reports whether role is one of TeamRoles.
+func ValidTeamRole(role string) bool {
+	_, ok := teamRolePermissions[role]
+	return ok
+}
+
+type TeamModelInterface interface {
+	Insert(name string, userID int) (int, error)
+	Get(id int) (*Team, error)
+	List(userID int) ([]*Team, error)
+	Members(teamID int) ([]*TeamMember, error)
+	Role(teamID, userID int) (string, error)
+	AddMember(teamID int, email, role string) error
+	RemoveMember(teamID, userID int) error
+}
+
+// Team is a group of users that owns snippets together. Role is the role in the team of the user the team was
+// listed for, or empty if they are not a member.
+type Team struct {
+	ID      int
+	Name    string
+	Created time.Time
+	Members int
+	Role    string
+}
+
+type TeamMember struct {
+	TeamID  int
+	UserID  int
+	Name    string
+	Email   string
+	Role    string
+	Created time.Time
+}
+
+// TeamModel wraps a database connection pool
+type TeamModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a team with the user userID as its first maintainer. It returns ErrDuplicateTeamName if another
+// team has the name.
+func (m *TeamModel) Insert(name string, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO teams (name, created) VALUES (?, UTC_TIMESTAMP())`, name)
+	if err != nil {
+		tx.Rollback()
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "teams_uc_name") {
+				return 0, ErrDuplicateTeamName
+			}
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	statement := `INSERT INTO team_members (teamID, userID, role, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	if _, err := tx.Exec(statement, id, userID, TeamRoleMaintainer); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *TeamModel) Get(id int) (*Team, error) {
+	t := &Team{}
+
+	query := `SELECT id, name, created, (SELECT COUNT(*) FROM team_members WHERE teamID = teams.id)
+	FROM teams WHERE id = ?`
+
+	err := m.DB.QueryRow(query, id).Scan(&t.ID, &t.Name, &t.Created, &t.Members)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// List returns every team by name, each with the role the user userID has in it.
+func (m *TeamModel) List(userID int) ([]*Team, error) {
+	query := `SELECT teams.id, teams.name, teams.created,
+		(SELECT COUNT(*) FROM team_members counts WHERE counts.teamID = teams.id), COALESCE(team_members.role, '')
+	FROM teams
+	LEFT JOIN team_members ON team_members.teamID = teams.id AND team_members.userID = ?
+	ORDER BY teams.name`
+
+	rows, err := m.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var teams []*Team
+
+	for rows.Next() {
+		t := &Team{}
+
+		if err := rows.Scan(&t.ID, &t.Name, &t.Created, &t.Members, &t.Role); err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+// Members returns the members of a team, maintainers first.
+func (m *TeamModel) Members(teamID int) ([]*TeamMember, error) {
+	query := `SELECT team_members.teamID, team_members.userID, users.name, users.email, team_members.role,
+		team_members.created
+	FROM team_members
+	JOIN users ON users.id = team_members.userID
+	WHERE team_members.teamID = ?
+	ORDER BY team_members.role = ? DESC, users.name`
+
+	rows, err := m.DB.Query(query, teamID, TeamRoleMaintainer)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var members []*TeamMember
+
+	for rows.Next() {
+		tm := &TeamMember{}
+
+		if err := rows.Scan(&tm.TeamID, &tm.UserID, &tm.Name, &tm.Email, &tm.Role, &tm.Created); err != nil {
+			return nil, err
+		}
+
+		members = append(members, tm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Role returns the role the user userID has in a team. It returns ErrNoRecord if they are not a member.
+func (m *TeamModel) Role(teamID, userID int) (string, error) {
+	var role string
+
+	query := `SELECT role FROM team_members WHERE teamID = ? AND userID = ?`
+
+	err := m.DB.QueryRow(query, teamID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+// AddMember adds the user with an email address to a team, or changes their role if they are already a member. It
+// returns ErrNoRecord if no user has the email address, and ErrLastMaintainer if it would leave the team without a
+// maintainer.
+func (m *TeamModel) AddMember(teamID int, email, role string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID int
+
+	err = tx.QueryRow(`SELECT id FROM users WHERE email = ?`, email).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if role != TeamRoleMaintainer {
+		if err := checkOtherMaintainer(tx, teamID, userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	statement := `INSERT INTO team_members (teamID, userID, role, created) VALUES (?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE role = VALUES(role)`
+
+	if _, err := tx.Exec(statement, teamID, userID, role); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember takes the user userID out of a team. It returns ErrNoRecord if they are not a member, and
+// ErrLastMaintainer if they are its only maintainer.
+func (m *TeamModel) RemoveMember(teamID, userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := checkOtherMaintainer(tx, teamID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM team_members WHERE teamID = ? AND userID = ?`, teamID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rows == 0 {
+		tx.Rollback()
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// checkOtherMaintainer returns ErrLastMaintainer if the user userID is the only maintainer of a team. It locks the
+// team's maintainers until tx ends, so that two changes cannot each remove one of the last two.
+func checkOtherMaintainer(tx *sql.Tx, teamID, userID int) error {
+	rows, err := tx.Query(`SELECT userID FROM team_members WHERE teamID = ? AND role = ? FOR UPDATE`, teamID,
+		TeamRoleMaintainer)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	isMaintainer, others := false, 0
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+
+		if id == userID {
+			isMaintainer = true
+		} else {
+			others++
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if isMaintainer && others == 0 {
+		return ErrLastMaintainer
+	}
+
+	return nil
+}
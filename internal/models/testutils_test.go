@@ -0,0 +1,57 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/mabego/snippetbox-mysql/migrations"
+)
+
+// newTestDB connects to the MySQL test database that the TEST_DSN environment variable names, such as
+// "test_web:pass@/test_snippetbox?parseTime=true", and migrates it. Every table in it is dropped once the test
+// finishes, so it must not hold anything else. Tests that need it are skipped without TEST_DSN.
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DSN")
+	if dsn == "" {
+		t.Skip("models: set TEST_DSN to run database tests")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := iofs.New(migrations.Migrations, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithInstance("migrations", source, "mysql", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		defer db.Close()
+
+		if err := m.Drop(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	return db
+}
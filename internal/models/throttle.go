@@ -0,0 +1,145 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// AccountFreeFailures and IPFreeFailures are the number of failed logins allowed before a lockout starts. An IP
+	// address gets more, since many people can share one.
+	AccountFreeFailures = 5
+	IPFreeFailures      = 20
+	// LockoutBase is the length of the first lockout. Each further failure doubles it, up to LockoutMax.
+	LockoutBase = 30 * time.Second
+	LockoutMax  = 15 * time.Minute
+	// LoginFailureWindow is how long failures are remembered after the last one.
+	LoginFailureWindow = time.Hour
+)
+
+// Scopes of the login failure counters.
+const (
+	scopeAccount = "account"
+	scopeIP      = "ip"
+)
+
+type LoginThrottleModelInterface interface {
+	LockedUntil(ip, email string) (time.Time, error)
+	Fail(ip, email string) (time.Time, error)
+	Reset(email string) error
+}
+
+// LoginThrottleModel wraps a database connection pool
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// LockedUntil returns the time until which logins from an IP address or to an account are refused. It returns the
+// zero time if neither is locked.
+func (m *LoginThrottleModel) LockedUntil(ip, email string) (time.Time, error) {
+	var until sql.NullTime
+
+	query := `SELECT MAX(locked_until) FROM login_failures
+	WHERE ((scope = ? AND name = ?) OR (scope = ? AND name = ?)) AND locked_until > ?`
+
+	err := m.DB.QueryRow(query, scopeIP, ip, scopeAccount, normalizeEmail(email), time.Now().UTC()).Scan(&until)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until.Time, nil
+}
+
+// Fail records a failed login from an IP address to an account. Failures to accounts that do not exist count too,
+// so that the responses do not reveal which accounts exist. It returns the time until which logins are now refused,
+// or the zero time if there is no lockout yet.
+func (m *LoginThrottleModel) Fail(ip, email string) (time.Time, error) {
+	ipUntil, err := m.fail(scopeIP, ip, IPFreeFailures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	accountUntil, err := m.fail(scopeAccount, normalizeEmail(email), AccountFreeFailures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if ipUntil.After(accountUntil) {
+		return ipUntil, nil
+	}
+
+	return accountUntil, nil
+}
+
+func (m *LoginThrottleModel) fail(scope, name string, free int) (time.Time, error) {
+	now := time.Now().UTC()
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var failures int
+	var lastFailure time.Time
+
+	query := `SELECT failures, last_failure FROM login_failures WHERE scope = ? AND name = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, scope, name).Scan(&failures, &lastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	// Start counting again once the earlier failures are old enough to forget.
+	if lastFailure.Before(now.Add(-LoginFailureWindow)) {
+		failures = 0
+	}
+	failures++
+
+	var until sql.NullTime
+	if failures > free {
+		until = sql.NullTime{Time: now.Add(lockout(failures - free)), Valid: true}
+	}
+
+	statement := `INSERT INTO login_failures (scope, name, failures, last_failure, locked_until) VALUES (?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE failures = VALUES(failures), last_failure = VALUES(last_failure),
+	locked_until = VALUES(locked_until)`
+
+	if _, err := tx.Exec(statement, scope, name, failures, now, until); err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+
+	return until.Time, nil
+}
+
+// Reset clears the failures of an account after a successful login. Failures from the IP address are kept, so that
+// logging in to one account does not allow more guesses at another.
+func (m *LoginThrottleModel) Reset(email string) error {
+	statement := `DELETE FROM login_failures WHERE scope = ? AND name = ?`
+
+	_, err := m.DB.Exec(statement, scopeAccount, normalizeEmail(email))
+	return err
+}
+
+// lockout returns the length of the nth lockout in a row.
+func lockout(n int) time.Duration {
+	d := LockoutBase
+	for i := 1; i < n && d < LockoutMax; i++ {
+		d *= 2
+	}
+
+	return min(d, LockoutMax)
+}
+
+// normalizeEmail turns the email address given to log in into the name its failures are counted under. It is cut to
+// the length of the name column, since anyone can try to log in with a longer one.
+func normalizeEmail(email string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(email)), 255)
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestLoginThrottleModelLongEmail(t *testing.T) {
+	db := newTestDB(t)
+	m := LoginThrottleModel{DB: db}
+
+	// Failed logins with an email address longer than the name column are counted rather than refused.
+	email := strings.Repeat("a", 300) + "@example.com"
+
+	for i := 0; i < AccountFreeFailures; i++ {
+		until, err := m.Fail("192.0.2.1", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, until.IsZero(), true)
+	}
+
+	until, err := m.Fail("192.0.2.1", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, until.IsZero(), false)
+
+	until, err = m.LockedUntil("192.0.2.1", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, until.IsZero(), false)
+
+	err = m.Reset(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until, err = m.LockedUntil("192.0.2.1", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, until.IsZero(), true)
+}
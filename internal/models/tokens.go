@@ -0,0 +1,29 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// newToken generates a random token to hand to a user, for example in a link, and returns it together with its
+// SHA-256 hash. Only the hash is stored, so a copy of the database does not reveal usable tokens.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token for storage and lookup.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
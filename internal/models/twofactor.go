@@ -0,0 +1,248 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/totp"
+)
+
+const (
+	RecoveryCodeCount = 10
+	// TOTPSkew is the number of time steps either side of the current one in which a code is still accepted.
+	TOTPSkew = 1
+)
+
+type TwoFactorModelInterface interface {
+	Available() bool
+	Enable(userID int, secret string) ([]string, error)
+	Disable(userID int) error
+	Validate(userID int, code string) (bool, error)
+	Recover(userID int, code string) (bool, error)
+	RecoveryCodesLeft(userID int) (int, error)
+}
+
+// TwoFactorModel wraps a database connection pool and the SecretBox that TOTP secrets are encrypted with. Without
+// a SecretBox, users can't turn on two-factor authentication.
+type TwoFactorModel struct {
+	DB      *sql.DB
+	Secrets *SecretBox
+}
+
+// Available reports whether users can turn on two-factor authentication, which needs a key to encrypt their
+// secrets with.
+func (m *TwoFactorModel) Available() bool {
+	return m.Secrets != nil
+}
+
+// HasSealedSecrets reports whether any user has a TOTP secret that was encrypted, and so needs the key to log in.
+func (m *TwoFactorModel) HasSealedSecrets() (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS(SELECT true FROM users WHERE totp_secret LIKE ?)`
+
+	err := m.DB.QueryRow(query, sealedPrefix+"%").Scan(&exists)
+	return exists, err
+}
+
+// Enable turns on two-factor authentication for a user with a secret they have confirmed in their authenticator
+// app. It replaces any recovery codes and returns the new ones, which are only stored as hashes. It returns
+// ErrNoSecretKey if two-factor authentication isn't available.
+func (m *TwoFactorModel) Enable(userID int, secret string) ([]string, error) {
+	if !m.Available() {
+		return nil, ErrNoSecretKey
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	sealed, err := m.Secrets.Seal(secret, totpLabel(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// The code the user confirmed the secret with counts as used.
+	statement := `UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ?`
+
+	if _, err := tx.Exec(statement, sealed, totp.Step(time.Now()), userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE userID = ?`, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, code := range codes {
+		statement := `INSERT INTO recovery_codes (userID, code_hash) VALUES (?, ?)`
+
+		if _, err := tx.Exec(statement, userID, hashToken(code)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication for a user and removes their recovery codes.
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL WHERE id = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE userID = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Validate checks a code from the user's authenticator app. Each code is only accepted once, so a code seen by
+// someone looking over the user's shoulder can't be used again.
+func (m *TwoFactorModel) Validate(userID int, code string) (bool, error) {
+	var stored sql.NullString
+	var lastStep int64
+
+	query := `SELECT totp_secret, totp_last_step FROM users WHERE id = ?`
+
+	err := m.DB.QueryRow(query, userID).Scan(&stored, &lastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+
+	if !stored.Valid {
+		return false, nil
+	}
+
+	secret, sealed, err := m.Secrets.Open(stored.String, totpLabel(userID))
+	if err != nil {
+		return false, err
+	}
+
+	// Secrets stored before they were encrypted are encrypted the next time they are used, once there is a key.
+	if !sealed && m.Available() {
+		if err := m.seal(userID, secret); err != nil {
+			return false, err
+		}
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), TOTPSkew, lastStep)
+	if !ok {
+		return false, nil
+	}
+
+	// Move the last used step forward, which fails if this or a later code was already used.
+	statement := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+
+	result, err := m.DB.Exec(statement, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// seal encrypts a user's plaintext TOTP secret in place.
+func (m *TwoFactorModel) seal(userID int, secret string) error {
+	sealed, err := m.Secrets.Seal(secret, totpLabel(userID))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.Exec(`UPDATE users SET totp_secret = ? WHERE id = ? AND totp_secret = ?`, sealed, userID, secret)
+	return err
+}
+
+// Recover uses up one of the user's recovery codes. It returns false if the code is wrong or was already used.
+func (m *TwoFactorModel) Recover(userID int, code string) (bool, error) {
+	statement := `UPDATE recovery_codes SET used = UTC_TIMESTAMP()
+		WHERE userID = ? AND code_hash = ? AND used IS NULL LIMIT 1`
+
+	result, err := m.DB.Exec(statement, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes a user has.
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE userID = ? AND used IS NULL`
+
+	err := m.DB.QueryRow(query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// totpLabel ties a sealed TOTP secret to the user it belongs to.
+func totpLabel(userID int) string {
+	return "totp:" + strconv.Itoa(userID)
+}
+
+// newRecoveryCode generates a random recovery code that is easy to copy, such as "k7qxm-2vd4p".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode lets users type a recovery code in upper case or with spaces around it.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
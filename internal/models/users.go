@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -15,38 +14,59 @@ const (
 )
 
 type UserModelInterface interface {
-	Insert(name, email, password string, owner bool) error
+	Insert(name, email, password, role string) error
 	Authenticate(email, password string) (int, error)
-	Authorize(id int) (bool, error)
+	Role(id int) (string, bool, error)
 	Exists(id int) (bool, error)
 	Get(id int) (*User, error)
-	Owner() (bool, error)
+	AdminExists() (bool, error)
 	PasswordUpdate(id int, currentPassword, newPassword string) error
+	List(search string) ([]*User, error)
+	SetRole(id int, role string) error
+	ProvisionOIDC(subject, name, email, role string) (int, error)
+	Provision(name, email, role string) (int, error)
+	SetDisabled(id int, disabled bool) error
+	RequirePasswordReset(id int) error
+	Delete(id int) error
+	DeleteAccount(id int, reassignTo string) error
+	UpdateProfile(id int, name, email, bio string) (bool, error)
+	SetAvatar(id int, avatar []byte, contentType string) error
+	Avatar(id int) ([]byte, string, error)
 }
 
 type User struct {
-	ID             int
-	Name           string
-	Email          string
-	HashedPassword []byte
-	Created        time.Time
-	Owner          bool
+	ID                    int
+	Name                  string
+	Email                 string
+	HashedPassword        []byte
+	Created               time.Time
+	Role                  string
+	Disabled              bool
+	PasswordResetRequired bool
+	EmailVerified         time.Time
+	TwoFactorEnabled      bool
+	Bio                   string
+	HasAvatar             bool
+	// PendingEmail is a new email address the user has asked for but not verified yet, which replaces Email once
+	// they do.
+	PendingEmail string
 }
 
-// UserModel wraps a database connection pool
+// UserModel wraps a database connection pool. Passwords hashes passwords, and is DefaultPasswordHasher if nil.
 type UserModel struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Passwords *PasswordHasher
 }
 
-func (m *UserModel) Insert(name, email, password string, owner bool) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+func (m *UserModel) Insert(name, email, password, role string) error {
+	hashedPassword, err := passwordHasher(m.Passwords).Hash(password)
 	if err != nil {
 		return err
 	}
 
-	statement := `INSERT INTO users (name, email, hashed_password, created, owner) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
+	statement := `INSERT INTO users (name, email, hashed_password, created, role) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
 
-	_, err = m.DB.Exec(statement, name, email, string(hashedPassword), owner)
+	_, err = m.DB.Exec(statement, name, email, hashedPassword, role)
 	if err != nil {
 		// Use errors.As to check if the error has the type *mysql.MySQLError.
 		// If it does, the error is assigned to mySQLError and checked for error code 1062
@@ -64,12 +84,13 @@ func (m *UserModel) Insert(name, email, password string, owner bool) error {
 
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
-	var hashedPassword []byte
+	var hashedPassword string
+	var disabled bool
 
-	query := `SELECT id, hashed_password FROM users WHERE email = ?`
+	query := `SELECT id, hashed_password, disabled FROM users WHERE email = ?`
 
 	// Check if email exists in database.
-	err := m.DB.QueryRow(query, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRow(query, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -78,33 +99,63 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	}
 
 	// Check if the provided plain-text password matches the hashed password in the database.
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	hasher := passwordHasher(m.Passwords)
+
+	match, outdated, err := hasher.Check(hashedPassword, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		}
 		return 0, err
 	}
+	if !match {
+		return 0, ErrInvalidCredentials
+	}
+
+	// Upgrade a hash made with an older algorithm or parameters while the plain-text password is at hand. The
+	// hashed_password condition leaves a password changed in the meantime alone.
+	if outdated {
+		newHashedPassword, err := hasher.Hash(password)
+		if err != nil {
+			return 0, err
+		}
+
+		statement := `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+
+		_, err = m.DB.Exec(statement, newHashedPassword, id, hashedPassword)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Only report a disabled account once the password is known to be correct.
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
 
 	return id, nil
 }
 
-func (m *UserModel) Authorize(id int) (bool, error) {
-	var owner bool
-	query := `SELECT owner FROM users WHERE id = ?`
+// Role returns the role of a user and whether they have verified their email address.
+func (m *UserModel) Role(id int) (string, bool, error) {
+	var role string
+	var verified bool
+	query := `SELECT role, email_verified_at IS NOT NULL FROM users WHERE id = ?`
 
-	err := m.DB.QueryRow(query, id).Scan(&owner)
+	err := m.DB.QueryRow(query, id).Scan(&role, &verified)
 	if err != nil {
-		return false, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, ErrNoRecord
+		}
+		return "", false, err
 	}
 
-	return owner, nil
+	return role, verified, nil
 }
 
 func (m *UserModel) Exists(id int) (bool, error) {
 	var exists bool
 
-	query := `SELECT EXISTS(SELECT true FROM users WHERE id = ?)`
+	// A disabled user, or one whose password an admin has reset, is treated as if they no longer exist, which ends
+	// their sessions and stops their API tokens.
+	query := `SELECT EXISTS(SELECT true FROM users WHERE id = ? AND disabled = false AND password_reset_required = false)`
 
 	err := m.DB.QueryRow(query, id).Scan(&exists)
 	if err != nil {
@@ -116,9 +167,15 @@ func (m *UserModel) Exists(id int) (bool, error) {
 func (m *UserModel) Get(id int) (*User, error) {
 	var user User
 
-	query := `SELECT id, name, email, created FROM users WHERE id = ?`
+	var emailVerified sql.NullTime
 
-	err := m.DB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	query := `SELECT id, name, email, created, role, disabled, password_reset_required, email_verified_at,
+		totp_secret IS NOT NULL, bio, avatar IS NOT NULL, COALESCE(pending_email, '')
+		FROM users WHERE id = ?`
+
+	err := m.DB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.Role,
+		&user.Disabled, &user.PasswordResetRequired, &emailVerified, &user.TwoFactorEnabled, &user.Bio,
+		&user.HasAvatar, &user.PendingEmail)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -126,23 +183,28 @@ func (m *UserModel) Get(id int) (*User, error) {
 		return nil, err
 	}
 
+	// EmailVerified stays the zero time until the user verifies their email address.
+	user.EmailVerified = emailVerified.Time
+
 	return &user, nil
 }
 
-func (m *UserModel) Owner() (bool, error) {
-	var owner bool
+// AdminExists reports whether any user has the admin role. Until one does, anyone can sign up to create the first
+// admin.
+func (m *UserModel) AdminExists() (bool, error) {
+	var exists bool
 
-	query := `SELECT EXISTS(SELECT true FROM users WHERE owner = true)`
+	query := `SELECT EXISTS(SELECT true FROM users WHERE role = 'admin')`
 
-	err := m.DB.QueryRow(query).Scan(&owner)
+	err := m.DB.QueryRow(query).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
-	return owner, nil
+	return exists, nil
 }
 
 func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
-	var currentHashedPassword []byte
+	var currentHashedPassword string
 
 	query := `SELECT hashed_password FROM users WHERE id = ?`
 
@@ -151,23 +213,433 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 		return err
 	}
 
+	hasher := passwordHasher(m.Passwords)
+
 	// Check if the provided plain-text password matches the hashed password in the database.
-	err = bcrypt.CompareHashAndPassword(currentHashedPassword, []byte(currentPassword))
+	match, _, err := hasher.Check(currentHashedPassword, currentPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return ErrInvalidCredentials
-		}
 		return err
 	}
+	if !match {
+		return ErrInvalidCredentials
+	}
 
 	// Hash the new password and update hashed_password column for the user
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), PasswordHashCost)
+	newHashedPassword, err := hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// Choosing a new password satisfies a reset required by an admin.
+	statement := `UPDATE users SET hashed_password = ?, password_reset_required = false WHERE id = ?`
+
+	_, err = m.DB.Exec(statement, newHashedPassword, id)
+	return err
+}
+
+// List returns the users whose name or email address contains search, ordered by name. An empty search string
+// returns every user.
+func (m *UserModel) List(search string) ([]*User, error) {
+	pattern := "%" + escapeLike(search) + "%"
+
+	query := `SELECT id, name, email, created, role, disabled, password_reset_required, email_verified_at,
+		totp_secret IS NOT NULL
+		FROM users WHERE name LIKE ? OR email LIKE ? ORDER BY name, id`
+
+	rows, err := m.DB.Query(query, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		u := &User{}
+		var emailVerified sql.NullTime
+
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired,
+			&emailVerified, &u.TwoFactorEnabled)
+		if err != nil {
+			return nil, err
+		}
+
+		u.EmailVerified = emailVerified.Time
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	statement := `UPDATE users SET role = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(statement, role, id)
+	return err
+}
+
+// ProvisionOIDC returns the ID of the user with an identity provider subject, and creates the user just in time on
+// their first single sign-on. A first sign-on with the verified email address of an existing user links the subject
+// to that user instead, and one with an unverified address returns ErrDuplicateEmail. The identity provider decides
+// the role, so role replaces the user's role every time, except that it returns ErrLastAdmin rather than demote the
+// only active admin. The caller must make sure the identity provider has verified the email address.
+func (m *UserModel) ProvisionOIDC(subject, name, email, role string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	var disabled bool
+
+	err = tx.QueryRow(`SELECT id, disabled FROM users WHERE oidc_subject = ? FOR UPDATE`, subject).Scan(&id, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		id, disabled, err = linkByEmail(tx, email)
+
+		switch {
+		case err == nil:
+			_, err = tx.Exec(`UPDATE users SET oidc_subject = ? WHERE id = ?`, subject, id)
+		case errors.Is(err, sql.ErrNoRows):
+			id, err = m.insertExternal(tx, name, email, role, subject)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if disabled {
+		tx.Rollback()
+		return 0, ErrAccountDisabled
+	}
+
+	if err := setExternalRole(tx, id, role); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Provision returns the ID of the user with an email address that an external backend, such as an LDAP directory,
+// has authenticated, and creates the user just in time on their first login. Like ProvisionOIDC, it replaces the
+// user's role with the one the backend gives them, and returns ErrLastAdmin rather than demote the only active
+// admin. It only links an existing user who has verified their email address, and returns ErrDuplicateEmail if
+// an unverified user has it.
+func (m *UserModel) Provision(name, email, role string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	var disabled bool
+
+	id, disabled, err = linkByEmail(tx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		id, err = m.insertExternal(tx, name, email, role, nil)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if disabled {
+		tx.Rollback()
+		return 0, ErrAccountDisabled
+	}
+
+	if err := setExternalRole(tx, id, role); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// linkByEmail returns the user with an email address that an external backend vouches for, and whether they are
+// disabled. Only a user who verified the address themselves is linked, so that nobody can sign up or change their
+// profile to someone else's address and wait for them to log in. It returns ErrDuplicateEmail if an unverified
+// user has the address, and sql.ErrNoRows if nobody does.
+func linkByEmail(tx *sql.Tx, email string) (int, bool, error) {
+	var id int
+	var disabled, verified bool
+
+	query := `SELECT id, disabled, email_verified_at IS NOT NULL FROM users WHERE email = ? FOR UPDATE`
+
+	err := tx.QueryRow(query, email).Scan(&id, &disabled, &verified)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if !verified {
+		return 0, false, ErrDuplicateEmail
+	}
+
+	return id, disabled, nil
+}
+
+// setExternalRole gives a user the role an external backend gave them. It returns ErrLastAdmin rather than take
+// the admin role away from the only active admin, which would leave nobody to manage users and open up signup.
+func setExternalRole(tx *sql.Tx, id int, role string) error {
+	if role != RoleAdmin {
+		var lastAdmin bool
+
+		query := `SELECT role = ? AND NOT EXISTS(
+			SELECT true FROM users others WHERE others.role = ? AND others.disabled = false AND others.id <> users.id)
+			FROM users WHERE id = ?`
+
+		if err := tx.QueryRow(query, RoleAdmin, RoleAdmin, id).Scan(&lastAdmin); err != nil {
+			return err
+		}
+
+		if lastAdmin {
+			return ErrLastAdmin
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	return err
+}
+
+// insertExternal creates a user who logs in through an external backend, with an identity provider subject if
+// there is one. They get a random password they don't know, so they can only log in with a local password after
+// resetting it.
+func (m *UserModel) insertExternal(tx *sql.Tx, name, email, role string, subject any) (int, error) {
+	password, _, err := newToken()
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := passwordHasher(m.Passwords).Hash(password)
+	if err != nil {
+		return 0, err
+	}
+
+	statement := `INSERT INTO users (name, email, hashed_password, created, role, email_verified_at, oidc_subject)
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), ?, UTC_TIMESTAMP(), ?)`
+
+	result, err := tx.Exec(statement, name, email, hashedPassword, role, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	statement := `UPDATE users SET disabled = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(statement, disabled, id)
+	return err
+}
+
+// RequirePasswordReset replaces the user's password with a random one nobody knows, so that they can only log in
+// again after choosing a new password through a reset link. Until then, Exists treats them as if they no longer
+// exist.
+func (m *UserModel) RequirePasswordReset(id int) error {
+	password, _, err := newToken()
 	if err != nil {
 		return err
 	}
 
-	statement := `UPDATE users SET hashed_password = ? WHERE id = ?`
+	hashedPassword, err := passwordHasher(m.Passwords).Hash(password)
+	if err != nil {
+		return err
+	}
+
+	statement := `UPDATE users SET hashed_password = ?, password_reset_required = true WHERE id = ?`
 
-	_, err = m.DB.Exec(statement, string(newHashedPassword), id)
+	_, err = m.DB.Exec(statement, hashedPassword, id)
 	return err
 }
+
+// Delete removes a user. Their reviews, review requests, comments, stars and invitations are removed with them by
+// the foreign key constraints, while their snippet events are kept without a user.
+func (m *UserModel) Delete(id int) error {
+	statement := `DELETE FROM users WHERE id = ?`
+
+	_, err := m.DB.Exec(statement, id)
+	return err
+}
+
+// DeleteAccount deletes a user at their own request. Their snippets go to the user with the email address
+// reassignTo, or are deleted along with their reviews, review requests, comments and stars if reassignTo is empty.
+// Snippets a team owns are never deleted. The user's own reviews, review requests, comments and stars go with them
+// through the foreign keys. It returns ErrNoRecord if reassignTo names no other active user, and ErrLastAdmin if the
+// user is the only active admin.
+func (m *UserModel) DeleteAccount(id int, reassignTo string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var lastAdmin bool
+
+	query := `SELECT role = ? AND NOT EXISTS(
+		SELECT true FROM users others WHERE others.role = ? AND others.disabled = false AND others.id <> users.id)
+		FROM users WHERE id = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, RoleAdmin, RoleAdmin, id).Scan(&lastAdmin)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if lastAdmin {
+		tx.Rollback()
+		return ErrLastAdmin
+	}
+
+	if reassignTo != "" {
+		var newOwner int
+
+		query := `SELECT id FROM users WHERE email = ? AND id <> ? AND disabled = false`
+
+		err = tx.QueryRow(query, reassignTo, id).Scan(&newOwner)
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		statement := `UPDATE snippets SET userID = ? WHERE userID = ?`
+
+		if _, err := tx.Exec(statement, newOwner, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		// The snippets' reviews, review requests, comments and stars cascade. Team snippets stay with their team.
+		statement := `DELETE FROM snippets WHERE userID = ? AND teamID IS NULL`
+
+		if _, err := tx.Exec(statement, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	statement := `DELETE FROM users WHERE id = ?`
+
+	if _, err := tx.Exec(statement, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateProfile changes a user's name and bio. A new email address is kept aside as pending until the user
+// verifies it, and the current one stays in use meanwhile; giving the current address again drops a pending one.
+// It reports whether there is a new pending address, and returns ErrDuplicateEmail if another user has it.
+func (m *UserModel) UpdateProfile(id int, name, email, bio string) (bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	var currentEmail string
+
+	query := `SELECT email FROM users WHERE id = ? FOR UPDATE`
+
+	err = tx.QueryRow(query, id).Scan(&currentEmail)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRecord
+		}
+		return false, err
+	}
+
+	changed := !strings.EqualFold(email, currentEmail)
+
+	var pending any
+	if changed {
+		var taken bool
+
+		err = tx.QueryRow(`SELECT EXISTS(SELECT true FROM users WHERE email = ? AND id <> ?)`, email, id).Scan(&taken)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+
+		if taken {
+			tx.Rollback()
+			return false, ErrDuplicateEmail
+		}
+
+		pending = email
+	}
+
+	statement := `UPDATE users SET name = ?, bio = ?, pending_email = ? WHERE id = ?`
+
+	_, err = tx.Exec(statement, name, bio, pending, id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// SetAvatar stores a user's avatar image and its content type, or removes it if avatar is nil.
+func (m *UserModel) SetAvatar(id int, avatar []byte, contentType string) error {
+	statement := `UPDATE users SET avatar = ?, avatar_type = ? WHERE id = ?`
+
+	var avatarValue, avatarType any
+	if avatar != nil {
+		avatarValue, avatarType = avatar, contentType
+	}
+
+	_, err := m.DB.Exec(statement, avatarValue, avatarType, id)
+	return err
+}
+
+// Avatar returns a user's avatar image and its content type, or ErrNoRecord if they have none.
+func (m *UserModel) Avatar(id int) ([]byte, string, error) {
+	var avatar []byte
+	var contentType string
+
+	query := `SELECT avatar, avatar_type FROM users WHERE id = ? AND avatar IS NOT NULL`
+
+	err := m.DB.QueryRow(query, id).Scan(&avatar, &contentType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrNoRecord
+		}
+		return nil, "", err
+	}
+
+	return avatar, contentType, nil
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern so that user input matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestUserModelAuthenticate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping database test")
+	}
+
+	db := newTestDB(t)
+
+	hashedPassword := func() string {
+		var hash string
+
+		err := db.QueryRow(`SELECT hashed_password FROM users WHERE email = ?`, "alice@example.com").Scan(&hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hash
+	}
+
+	// Alice signed up while passwords were hashed with bcrypt.
+	old := &UserModel{DB: db, Passwords: testHasher(AlgorithmBcrypt)}
+
+	err := old.Insert("Alice", "alice@example.com", "pa$$word", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash := hashedPassword()
+	assert.Equal(t, strings.HasPrefix(bcryptHash, "$2a$"), true)
+
+	m := &UserModel{DB: db, Passwords: testHasher(AlgorithmArgon2id)}
+
+	t.Run("Wrong password", func(t *testing.T) {
+		_, err := m.Authenticate("alice@example.com", "wrong")
+		assert.Equal(t, err, ErrInvalidCredentials)
+
+		// A wrong password leaves the hash alone.
+		assert.Equal(t, hashedPassword(), bcryptHash)
+	})
+
+	t.Run("Unknown email", func(t *testing.T) {
+		_, err := m.Authenticate("nobody@example.com", "pa$$word")
+		assert.Equal(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("Rehashed on login", func(t *testing.T) {
+		id, err := m.Authenticate("alice@example.com", "pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, id > 0, true)
+
+		argon2idHash := hashedPassword()
+		assert.Equal(t, strings.HasPrefix(argon2idHash, "$argon2id$v=19$m=64,t=1,p=1$"), true)
+
+		// The new hash works, and is up to date, so it is kept.
+		_, err = m.Authenticate("alice@example.com", "pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, hashedPassword(), argon2idHash)
+	})
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type EmailVerificationModelInterface interface {
+	Insert(email string, ttl time.Duration) (string, error)
+	InsertPending(userID int, ttl time.Duration) (string, error)
+	Verify(token string) (int, error)
+	CountSince(userID int, since time.Time) (int, error)
+}
+
+// EmailVerificationModel wraps a database connection pool
+type EmailVerificationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a verification for the unverified user with an email address that expires after ttl, and returns
+// the token for the verification link. It returns ErrNoRecord if there is no such user.
+func (m *EmailVerificationModel) Insert(email string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	statement := `
+		INSERT INTO email_verifications (userID, email, token_hash, created, expires)
+		SELECT id, email, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		FROM users
+		WHERE email = ? AND email_verified_at IS NULL`
+
+	return token, m.insert(statement, tokenHash, int(ttl.Seconds()), email)
+}
+
+// InsertPending creates a verification for the pending email address of a user that expires after ttl, and returns
+// the token for the verification link. It returns ErrNoRecord if the user has no pending address.
+func (m *EmailVerificationModel) InsertPending(userID int, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	statement := `
+		INSERT INTO email_verifications (userID, email, token_hash, created, expires)
+		SELECT id, pending_email, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		FROM users
+		WHERE id = ? AND pending_email IS NOT NULL`
+
+	return token, m.insert(statement, tokenHash, int(ttl.Seconds()), userID)
+}
+
+func (m *EmailVerificationModel) insert(statement string, args ...any) error {
+	result, err := m.DB.Exec(statement, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Verify marks the email address of the user of an unused, unexpired token as verified and uses up every
+// verification for that user. A token sent to a pending address makes it the user's email address. The token only
+// works for the address it was sent to, so it fails once the user asks for another one. It returns the ID of the
+// user, ErrNoRecord if the token is not valid, or ErrDuplicateEmail if another user took the pending address first.
+func (m *EmailVerificationModel) Verify(token string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	var email string
+
+	query := `SELECT userID, email FROM email_verifications
+		WHERE token_hash = ? AND used IS NULL AND expires > UTC_TIMESTAMP() FOR UPDATE`
+
+	err = tx.QueryRow(query, hashToken(token)).Scan(&userID, &email)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	statement := `UPDATE users SET email_verified_at = UTC_TIMESTAMP() WHERE id = ? AND email = ?`
+
+	result, err := tx.Exec(statement, userID, email)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if rows == 0 {
+		statement = `UPDATE users SET email = pending_email, pending_email = NULL, email_verified_at = UTC_TIMESTAMP()
+			WHERE id = ? AND pending_email = ?`
+
+		result, err = tx.Exec(statement, userID, email)
+		if err != nil {
+			tx.Rollback()
+			var mySQLError *mysql.MySQLError
+			if errors.As(err, &mySQLError) {
+				if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+					return 0, ErrDuplicateEmail
+				}
+			}
+			return 0, err
+		}
+
+		rows, err = result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if rows == 0 {
+		tx.Rollback()
+		return 0, ErrNoRecord
+	}
+
+	statement = `UPDATE email_verifications SET used = UTC_TIMESTAMP() WHERE userID = ? AND used IS NULL`
+
+	if _, err := tx.Exec(statement, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CountSince returns the number of verification emails created for a user since a time, to limit how often they
+// can be resent.
+func (m *EmailVerificationModel) CountSince(userID int, since time.Time) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM email_verifications WHERE userID = ? AND created >= ?`
+
+	err := m.DB.QueryRow(query, userID, since.UTC()).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
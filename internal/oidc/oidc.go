@@ -0,0 +1,141 @@
+// Package oidc logs users in with an OpenID Connect identity provider, using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/mabego/snippetbox-mysql/internal/auth"
+	"golang.org/x/oauth2"
+)
+
+var ErrNonce = errors.New("oidc: nonce does not match")
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// RoleClaim names the claim, such as "groups", that Roles are looked up in. The first mapping that matches
+	// gives the user their role, and users who match none get DefaultRole.
+	RoleClaim   string
+	Roles       []auth.RoleMapping
+	DefaultRole string
+}
+
+// Identity is who the identity provider says a user is.
+type Identity struct {
+	Subject       string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Role          string
+}
+
+type Provider struct {
+	config   Config
+	oauth2   oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// New looks up the endpoints of the identity provider at config.Issuer. The HTTP client in ctx, if any, is used to
+// talk to the provider.
+func New(ctx context.Context, config Config) (*Provider, error) {
+	provider, err := gooidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	return &Provider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{gooidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// NewState returns a random value for the state and nonce parameters.
+func NewState() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL returns the URL of the identity provider's login page. Only the S256 hash of verifier is sent.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange swaps the code the identity provider sent the user back with for their verified identity.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc exchange: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc exchange: no id_token in response")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc verify: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, ErrNonce
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc claims: %w", err)
+	}
+
+	identity := &Identity{
+		Subject: idToken.Subject,
+		Role:    p.role(claims[p.config.RoleClaim]),
+	}
+
+	identity.Name, _ = claims["name"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+
+	return identity, nil
+}
+
+// role maps the value of the role claim, which is a string or a list of strings, to a role.
+func (p *Provider) role(claim any) string {
+	var values []string
+
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	return auth.MapRole(values, p.config.Roles, p.config.DefaultRole)
+}
@@ -0,0 +1,185 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+// rfcSecret is the SHA-1 key from RFC 6238, appendix B, the ASCII string "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The test vectors from RFC 6238, appendix B, which have eight digits. Codes with six digits are the last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, code, tt.want[len(tt.want)-Digits:])
+		})
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	code, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, code, "287082")
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("got: nil error; want: an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		lastUsed int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{
+			name:     "Current step",
+			code:     code(current),
+			skew:     1,
+			wantStep: current,
+			wantOK:   true,
+		},
+		{
+			name:     "Surrounding spaces",
+			code:     " " + code(current) + " ",
+			skew:     1,
+			wantStep: current,
+			wantOK:   true,
+		},
+		{
+			name:     "Previous step within skew",
+			code:     code(current - 1),
+			skew:     1,
+			wantStep: current - 1,
+			wantOK:   true,
+		},
+		{
+			name:     "Next step within skew",
+			code:     code(current + 1),
+			skew:     1,
+			wantStep: current + 1,
+			wantOK:   true,
+		},
+		{
+			name: "Previous step outside skew",
+			code: code(current - 2),
+			skew: 1,
+		},
+		{
+			name: "Next step outside skew",
+			code: code(current + 2),
+			skew: 1,
+		},
+		{
+			name: "No skew",
+			code: code(current - 1),
+		},
+		{
+			name:     "Replayed code",
+			code:     code(current),
+			skew:     1,
+			lastUsed: current,
+		},
+		{
+			name:     "Code older than the last used one",
+			code:     code(current - 1),
+			skew:     1,
+			lastUsed: current,
+		},
+		{
+			name:     "Code newer than the last used one",
+			code:     code(current + 1),
+			skew:     1,
+			lastUsed: current,
+			wantStep: current + 1,
+			wantOK:   true,
+		},
+		{
+			name: "Wrong code",
+			code: "000000",
+			skew: 1,
+		},
+		{
+			name: "Too short",
+			code: code(current)[1:],
+			skew: 1,
+		},
+		{
+			name: "Blank",
+			skew: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.skew, tt.lastUsed)
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(key), SecretSize)
+
+	// A secret works with Validate.
+	now := time.Now()
+
+	code, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := Validate(secret, code, now, 0, 0)
+	assert.Equal(t, ok, true)
+}
@@ -0,0 +1,277 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password strength scores, from trivially guessable to very hard to guess.
+const (
+	StrengthVeryWeak = iota
+	StrengthWeak
+	StrengthFair
+	StrengthStrong
+	StrengthVeryStrong
+)
+
+// PasswordPolicy decides whether a new password is good enough.
+type PasswordPolicy struct {
+	MinLength int
+	// MinStrength is the lowest score PasswordStrength may give.
+	MinStrength int
+	// Breached is a list of passwords known from data breaches, or nil to skip that check.
+	Breached *BreachedPasswords
+}
+
+// Check returns why password breaks the policy, or an empty string if it doesn't. Personal holds things about the
+// user such as their name and email address, which the password must not contain.
+func (p *PasswordPolicy) Check(password string, personal ...string) (string, error) {
+	if !MinChars(password, p.MinLength) {
+		return fmt.Sprintf("This field must be at least %d characters long", p.MinLength), nil
+	}
+
+	if ContainsPersonal(password, personal...) {
+		return "This field must not contain your name or email address", nil
+	}
+
+	if PasswordStrength(password) < p.MinStrength {
+		return "This password is too easy to guess", nil
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return "", err
+		}
+		if breached {
+			return "This password has appeared in a data breach and cannot be used", nil
+		}
+	}
+
+	return "", nil
+}
+
+// ContainsPersonal reports whether a password contains, ignoring case, any word of at least three letters from
+// personal. An email address counts as a whole and by the words in its local part.
+func ContainsPersonal(password string, personal ...string) bool {
+	password = strings.ToLower(password)
+
+	var words []string
+	for _, p := range personal {
+		p = strings.ToLower(strings.TrimSpace(p))
+
+		if local, _, found := strings.Cut(p, "@"); found {
+			words = append(words, p)
+			p = local
+		}
+
+		words = append(words, strings.FieldsFunc(p, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+
+	for _, w := range words {
+		if utf8.RuneCountInString(w) >= 3 && strings.Contains(password, w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// leet undoes the usual character substitutions, such as "pa$$w0rd" for "password".
+var leet = strings.NewReplacer("@", "a", "4", "a", "8", "b", "(", "c", "3", "e", "6", "g", "1", "i", "!", "i",
+	"|", "l", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t", "2", "z")
+
+// dictionaryBits is what guessing a common word costs. The list below is short, but attackers use far longer ones.
+const dictionaryBits = 13
+
+// commonWords are passwords and words that crack lists try first.
+var commonWords = []string{
+	"password", "passwd", "passw", "letmein", "welcome", "admin", "administrator", "login", "master", "secret",
+	"qwerty", "qwertyuiop", "asdfgh", "asdfghjkl", "zxcvbn", "zxcvbnm", "azerty", "abc123", "iloveyou", "trustno",
+	"dragon", "monkey", "football", "baseball", "soccer", "hockey", "basketball", "superman", "batman", "starwars",
+	"princess", "sunshine", "shadow", "michael", "jennifer", "jordan", "hunter", "ranger", "buster", "thomas",
+	"charlie", "daniel", "andrew", "joshua", "robert", "matthew", "jessica", "ashley", "nicole", "hannah",
+	"summer", "winter", "spring", "autumn", "freedom", "whatever", "computer", "internet", "access", "flower",
+	"cookie", "cheese", "pepper", "ginger", "orange", "banana", "purple", "silver", "golden", "diamond",
+	"killer", "mustang", "harley", "corvette", "ferrari", "porsche", "yankees", "lakers",
+	"snippet", "snippetbox", "changeme", "default", "test", "testing", "guest", "root", "user", "temp",
+	"love", "lovely", "angel", "baby", "hello", "money", "happy", "family", "friend", "forever",
+}
+
+// PasswordStrength estimates how hard a password is to guess and returns a score from StrengthVeryWeak to
+// StrengthVeryStrong. It adds up the bits an attacker needs for each part of the password: common words and their
+// leetspeak forms are cheap, and so are years, repeated characters and runs such as "abcd" or "4321".
+func PasswordStrength(password string) int {
+	bits := passwordBits(password)
+
+	switch {
+	case bits < 20:
+		return StrengthVeryWeak
+	case bits < 30:
+		return StrengthWeak
+	case bits < 40:
+		return StrengthFair
+	case bits < 55:
+		return StrengthStrong
+	default:
+		return StrengthVeryStrong
+	}
+}
+
+func passwordBits(password string) float64 {
+	runes := []rune(password)
+	plain := []rune(leet.Replace(strings.ToLower(password)))
+
+	// The substitutions above all replace one character with one, so positions in plain match those in runes.
+	if len(plain) != len(runes) {
+		plain = []rune(strings.ToLower(password))
+	}
+
+	charBits := math.Log2(float64(poolSize(runes)))
+	bits := 0.0
+
+	for i := 0; i < len(runes); {
+		if n := commonWordAt(plain, i); n > 0 {
+			bits += dictionaryBits
+			if string(runes[i:i+n]) != string(plain[i:i+n]) {
+				bits++
+			}
+			i += n
+			continue
+		}
+
+		// Years are a handful of likely guesses rather than four random digits.
+		if isYear(runes, i) {
+			bits += yearBits
+			i += 4
+			continue
+		}
+
+		// A character that repeats or continues a run from the one before it adds about one bit.
+		if i > 0 {
+			d := runes[i] - runes[i-1]
+			if d >= -1 && d <= 1 {
+				bits++
+				i++
+				continue
+			}
+		}
+
+		bits += charBits
+		i++
+	}
+
+	return bits
+}
+
+// yearBits is what guessing a year from 1900 to 2099 costs.
+const yearBits = 7.6
+
+// isYear reports whether s holds a year from 1900 to 2099 at position i.
+func isYear(s []rune, i int) bool {
+	if i+4 > len(s) {
+		return false
+	}
+
+	for _, r := range s[i : i+4] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	century := string(s[i : i+2])
+	return century == "19" || century == "20"
+}
+
+// commonWordAt returns the length of the longest common word at position i of s, or 0 if there is none.
+func commonWordAt(s []rune, i int) int {
+	longest := 0
+
+	for _, w := range commonWords {
+		n := utf8.RuneCountInString(w)
+		if n > longest && i+n <= len(s) && string(s[i:i+n]) == w {
+			longest = n
+		}
+	}
+
+	return longest
+}
+
+// poolSize estimates how many different characters the password could have been made from, given the kinds of
+// characters it uses.
+func poolSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, kind := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if kind.used {
+			size += kind.size
+		}
+	}
+
+	return max(size, 2)
+}
+
+// BreachedPasswords looks passwords up in a directory of SHA-1 hash files laid out like the Have I Been Pwned range
+// API: a file named after the first five hex digits of a hash, such as 5BAA6, holds lines of the remaining 35
+// digits followed by a colon and a count, such as "1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365".
+type BreachedPasswords struct {
+	Dir string
+}
+
+// Contains reports whether the password's hash is in the list. A missing prefix file means no breached password
+// has that prefix.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.Dir, prefix))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,152 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mabego/snippetbox-mysql/internal/assert"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     int
+	}{
+		{name: "Empty", password: "", want: StrengthVeryWeak},
+		{name: "Common word", password: "password", want: StrengthVeryWeak},
+		{name: "Common word with a digit", password: "password1", want: StrengthVeryWeak},
+		{name: "Keyboard row", password: "qwerty", want: StrengthVeryWeak},
+		{name: "Run", password: "abcdefgh", want: StrengthVeryWeak},
+		{name: "Descending digits", password: "87654321", want: StrengthVeryWeak},
+		{name: "Repeated character", password: "aaaaaaaa", want: StrengthVeryWeak},
+		{name: "Common word and year", password: "summer1999", want: StrengthWeak},
+		{name: "Leetspeak with symbols", password: "P@ssw0rd!", want: StrengthWeak},
+		{name: "Short random letters", password: "mxqvtr", want: StrengthWeak},
+		{name: "Common word and random letters", password: "passwordmxq", want: StrengthWeak},
+		{name: "Random letters", password: "mxqvtrwp", want: StrengthFair},
+		{name: "Uncommon word", password: "zebracake", want: StrengthStrong},
+		{name: "Mixed kinds", password: "Tr0ub4dor", want: StrengthStrong},
+		{name: "Passphrase", password: "correcthorsebatterystaple", want: StrengthVeryStrong},
+		{name: "Random", password: "x7#Qm!2pLz9@", want: StrengthVeryStrong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, PasswordStrength(tt.password), tt.want)
+		})
+	}
+}
+
+func TestPasswordBitsLeet(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		plain    string
+	}{
+		{name: "Digits", password: "pa55w0rd", plain: "password"},
+		{name: "Symbols", password: "p@$$word", plain: "password"},
+		{name: "Capitals", password: "PASSWORD", plain: "password"},
+		{name: "Mixed", password: "M0NK3Y", plain: "monkey"},
+		{name: "Pipe and plus", password: "|e+mein", plain: "letmein"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A substituted common word costs one bit more than the word itself, rather than a character's worth.
+			assert.Equal(t, passwordBits(tt.password), passwordBits(tt.plain)+1)
+		})
+	}
+}
+
+func TestContainsPersonal(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		personal []string
+		want     bool
+	}{
+		{name: "Name", password: "xxaliceyy", personal: []string{"Alice"}, want: true},
+		{name: "Name ignoring case", password: "ALICE-rules", personal: []string{"alice"}, want: true},
+		{name: "Part of a name", password: "smith4ever", personal: []string{"Alice Smith"}, want: true},
+		{name: "Email address", password: "alice@example.com!", personal: []string{"alice@example.com"}, want: true},
+		{name: "Email local part", password: "4liceandwonder", personal: []string{"wonder.land@example.com"},
+			want: true},
+		{name: "Email domain", password: "example-pass", personal: []string{"al@example.com"}, want: false},
+		{name: "Short word", password: "alpaca-farm", personal: []string{"Al Ba"}, want: false},
+		{name: "Unrelated", password: "zebracake", personal: []string{"Alice", "alice@example.com"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, ContainsPersonal(tt.password, tt.personal...), tt.want)
+		})
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	// The SHA-1 hash of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+	err := os.WriteFile(filepath.Join(dir, "5BAA6"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+
+		"1e4c9b93f3f0682250b6cf8331b7ee68fd8:9659365\r\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breached := &BreachedPasswords{Dir: dir}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{name: "Listed", password: "password", want: true},
+		{name: "No prefix file", password: "zebracake", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := breached.Contains(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	// The SHA-1 hash of "Tr0ub4dor" is listed, as if it were breached.
+	err := os.WriteFile(filepath.Join(dir, "60A4E"), []byte("DEA376BAD6F327682B1E15ACEA8BCC9E060:3\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &PasswordPolicy{MinLength: 8, MinStrength: StrengthFair, Breached: &BreachedPasswords{Dir: dir}}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{name: "Valid", password: "zebracake", want: ""},
+		{name: "Too short", password: "x7#Qm!", want: "This field must be at least 8 characters long"},
+		{name: "Personal", password: "alicezebracake", want: "This field must not contain your name or email address"},
+		{name: "Too weak", password: "P@ssw0rd!", want: "This password is too easy to guess"},
+		{name: "Breached", password: "Tr0ub4dor", want: "This password has appeared in a data breach and cannot be used"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Check(tt.password, "Alice", "alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
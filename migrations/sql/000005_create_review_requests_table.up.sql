@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS `review_requests` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `snippetID` integer NOT NULL,
+  `requesterID` integer NOT NULL,
+  `reviewerID` integer NOT NULL,
+  `created` datetime NOT NULL,
+  `due` datetime NOT NULL,
+  `completed` datetime NULL,
+  `reminded` datetime NULL,
+  `escalated` datetime NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_review_requests_due` (`due`),
+  CONSTRAINT `FK_snippet_review_requests` FOREIGN KEY (`snippetID`) REFERENCES snippets(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_requester_review_requests` FOREIGN KEY (`requesterID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_reviewer_review_requests` FOREIGN KEY (`reviewerID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
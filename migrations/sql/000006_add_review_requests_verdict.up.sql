@@ -0,0 +1 @@
+ALTER TABLE `review_requests` ADD COLUMN `verdict` varchar(20) NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `snippet_events` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `snippetID` integer NOT NULL,
+  `userID` integer NULL,
+  `kind` varchar(20) NOT NULL,
+  `detail` varchar(255) NOT NULL DEFAULT '',
+  `created` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_snippet_events_snippet` (`snippetID`, `created`),
+  CONSTRAINT `FK_user_snippet_events` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE
+);
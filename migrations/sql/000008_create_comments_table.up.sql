@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `comments` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `snippetID` integer NOT NULL,
+  `userID` integer NOT NULL,
+  `parentID` integer NULL,
+  `body` text NOT NULL,
+  `created` datetime NOT NULL,
+  `updated` datetime NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_comments_snippet` (`snippetID`, `created`),
+  CONSTRAINT `FK_snippet_comments` FOREIGN KEY (`snippetID`) REFERENCES snippets(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_user_comments` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_parent_comments` FOREIGN KEY (`parentID`) REFERENCES comments(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
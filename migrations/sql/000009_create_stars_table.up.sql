@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `stars` (
+  `userID` integer NOT NULL,
+  `snippetID` integer NOT NULL,
+  `created` datetime NOT NULL,
+  PRIMARY KEY (`userID`, `snippetID`),
+  KEY `idx_stars_snippet` (`snippetID`),
+  CONSTRAINT `FK_snippet_stars` FOREIGN KEY (`snippetID`) REFERENCES snippets(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_user_stars` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
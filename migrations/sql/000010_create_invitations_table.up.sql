@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `invitations` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `email` varchar(255) NOT NULL,
+  `owner` BOOLEAN NOT NULL DEFAULT false,
+  `token_hash` char(64) NOT NULL,
+  `createdBy` integer NOT NULL,
+  `created` datetime NOT NULL,
+  `expires` datetime NOT NULL,
+  `accepted` datetime NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `invitations_uc_token_hash` (`token_hash`),
+  CONSTRAINT `FK_user_invitations` FOREIGN KEY (`createdBy`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
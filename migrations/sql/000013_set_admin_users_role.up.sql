@@ -0,0 +1 @@
+UPDATE `users` SET `role` = 'admin' WHERE `owner` = true;
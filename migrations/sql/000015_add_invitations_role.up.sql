@@ -0,0 +1 @@
+ALTER TABLE `invitations` ADD COLUMN `role` varchar(20) NOT NULL DEFAULT 'reviewer';
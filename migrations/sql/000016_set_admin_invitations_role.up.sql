@@ -0,0 +1 @@
+UPDATE `invitations` SET `role` = 'admin' WHERE `owner` = true;
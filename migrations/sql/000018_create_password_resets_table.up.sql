@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `password_resets` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `userID` integer NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `created` datetime NOT NULL,
+  `expires` datetime NOT NULL,
+  `used` datetime NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `password_resets_uc_token_hash` (`token_hash`),
+  CONSTRAINT `FK_user_password_resets` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1 @@
+UPDATE `users` SET `email_verified_at` = `created`;
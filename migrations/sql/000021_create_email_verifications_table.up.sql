@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `email_verifications` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `userID` integer NOT NULL,
+  `email` varchar(255) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `created` datetime NOT NULL,
+  `expires` datetime NOT NULL,
+  `used` datetime NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `email_verifications_uc_token_hash` (`token_hash`),
+  CONSTRAINT `FK_user_email_verifications` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `userID` integer NOT NULL,
+  `code_hash` char(64) NOT NULL,
+  `used` datetime NULL,
+  PRIMARY KEY (`id`),
+  CONSTRAINT `FK_user_recovery_codes` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `settings` (
+  `name` varchar(64) NOT NULL,
+  `value` varchar(255) NOT NULL,
+  PRIMARY KEY (`name`)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `login_failures` (
+  `scope` varchar(16) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `failures` integer NOT NULL,
+  `last_failure` datetime NOT NULL,
+  `locked_until` datetime NULL,
+  PRIMARY KEY (`scope`, `name`)
+);
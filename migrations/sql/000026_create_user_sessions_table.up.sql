@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `user_sessions` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `token` char(43) NOT NULL,
+  `userID` integer NOT NULL,
+  `ip` varchar(45) NOT NULL,
+  `user_agent` varchar(255) NOT NULL,
+  `created` datetime NOT NULL,
+  `last_seen` datetime NOT NULL,
+  `expires` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token` (`token`),
+  KEY `idx_user_sessions_userID` (`userID`),
+  CONSTRAINT `FK_user_user_sessions` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
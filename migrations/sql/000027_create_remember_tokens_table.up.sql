@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `remember_tokens` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `userID` integer NOT NULL,
+  `family` char(43) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `created` datetime NOT NULL,
+  `expires` datetime NOT NULL,
+  `used` datetime NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token_hash` (`token_hash`),
+  KEY `idx_remember_tokens_family` (`family`),
+  CONSTRAINT `FK_user_remember_tokens` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
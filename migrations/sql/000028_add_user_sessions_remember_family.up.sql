@@ -0,0 +1 @@
+ALTER TABLE `user_sessions` ADD COLUMN `remember_family` char(43) NOT NULL DEFAULT '';
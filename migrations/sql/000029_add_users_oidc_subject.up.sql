@@ -0,0 +1 @@
+ALTER TABLE `users` ADD COLUMN `oidc_subject` varchar(255) NULL, ADD UNIQUE KEY `users_uc_oidc_subject` (`oidc_subject`);
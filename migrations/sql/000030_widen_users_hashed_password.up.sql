@@ -0,0 +1 @@
+ALTER TABLE `users` MODIFY `hashed_password` varchar(255) NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE `snippets` ADD COLUMN `userID` integer NULL,
+  ADD INDEX `idx_snippets_userID` (`userID`),
+  ADD CONSTRAINT `FK_user_snippets` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE;
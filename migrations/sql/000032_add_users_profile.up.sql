@@ -0,0 +1,3 @@
+ALTER TABLE `users` ADD COLUMN `bio` varchar(500) NOT NULL DEFAULT '',
+  ADD COLUMN `avatar` mediumblob NULL,
+  ADD COLUMN `avatar_type` varchar(50) NULL;
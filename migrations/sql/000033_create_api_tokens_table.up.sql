@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `api_tokens` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `userID` integer NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `scopes` varchar(100) NOT NULL,
+  `created` datetime NOT NULL,
+  `expires` datetime NULL,
+  `last_used` datetime NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token_hash` (`token_hash`),
+  KEY `idx_api_tokens_userID` (`userID`),
+  CONSTRAINT `FK_user_api_tokens` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `teams` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `name` varchar(100) NOT NULL,
+  `created` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `teams_uc_name` (`name`)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `team_members` (
+  `teamID` integer NOT NULL,
+  `userID` integer NOT NULL,
+  `role` varchar(20) NOT NULL,
+  `created` datetime NOT NULL,
+  PRIMARY KEY (`teamID`, `userID`),
+  KEY `idx_team_members_userID` (`userID`),
+  CONSTRAINT `FK_team_team_members` FOREIGN KEY (`teamID`) REFERENCES teams(id) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `FK_user_team_members` FOREIGN KEY (`userID`) REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
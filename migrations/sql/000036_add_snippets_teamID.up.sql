@@ -0,0 +1,3 @@
+ALTER TABLE `snippets` ADD COLUMN `teamID` integer NULL,
+  ADD INDEX `idx_snippets_teamID` (`teamID`),
+  ADD CONSTRAINT `FK_team_snippets` FOREIGN KEY (`teamID`) REFERENCES teams(id) ON DELETE SET NULL ON UPDATE CASCADE;
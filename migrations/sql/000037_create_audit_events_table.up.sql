@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `audit_events` (
+  `id` integer NOT NULL AUTO_INCREMENT,
+  `actorID` integer NULL,
+  `actor_email` varchar(255) NOT NULL DEFAULT '',
+  `action` varchar(50) NOT NULL,
+  `targetID` integer NULL,
+  `target_email` varchar(255) NOT NULL DEFAULT '',
+  `detail` varchar(255) NOT NULL DEFAULT '',
+  `ip` varchar(45) NOT NULL,
+  `user_agent` varchar(255) NOT NULL,
+  `created` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_audit_events_created` (`created`),
+  KEY `idx_audit_events_action` (`action`, `created`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `users` MODIFY `totp_secret` varchar(255) NULL;
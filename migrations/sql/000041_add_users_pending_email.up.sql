@@ -0,0 +1 @@
+ALTER TABLE `users` ADD COLUMN `pending_email` varchar(255) NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE `audit_events` ADD COLUMN `actingAdminID` integer NULL AFTER `actor_email`,
+  ADD COLUMN `acting_admin_email` varchar(255) NOT NULL DEFAULT '' AFTER `actingAdminID`;
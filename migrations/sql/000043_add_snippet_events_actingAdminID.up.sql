@@ -0,0 +1,3 @@
+ALTER TABLE `snippet_events` ADD COLUMN `actingAdminID` integer NULL AFTER `userID`,
+  ADD CONSTRAINT `FK_acting_admin_snippet_events` FOREIGN KEY (`actingAdminID`) REFERENCES users(id)
+    ON DELETE SET NULL ON UPDATE CASCADE;